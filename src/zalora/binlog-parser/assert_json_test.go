@@ -0,0 +1,39 @@
+// +build unit integration
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func assertJson(t *testing.T, buffer bytes.Buffer, expectedJsonFiles ...string) {
+	var expectedJson []string
+
+	for _, expectedJsonFile := range expectedJsonFiles {
+		content, err := ioutil.ReadFile(expectedJsonFile)
+
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Failed to open expected JSON file: %s", err))
+		}
+
+		expectedJson = append(expectedJson, strings.TrimSpace(string(content)))
+	}
+
+	expected := strings.Join(expectedJson, "\n")
+	actual := strings.TrimSpace(buffer.String())
+
+	if expected != actual {
+		errorMessage := fmt.Sprintf(
+			"JSON file(s) %s do not match\nExpected:\n==========\n%s\n==========\nActual generated:\n%s\n==========",
+			strings.Join(expectedJsonFiles, ", "),
+			expected,
+			actual,
+		)
+
+		t.Fatal(errorMessage)
+	}
+}
@@ -0,0 +1,708 @@
+package database
+
+import (
+	"strings"
+)
+
+type tableName struct {
+	schema string
+	table  string
+}
+
+type createTableStatement struct {
+	tableName
+	columns   []Column
+	keys      []Key
+	likeTable *tableName
+}
+
+type alterTableStatement struct {
+	tableName
+	specifications []alterSpecification
+	renameTo       *tableName
+}
+
+type alterAction int
+
+const (
+	addColumn alterAction = iota
+	dropColumn
+	changeColumn
+	renameColumn
+	addKey
+	dropKey
+	renameKey
+)
+
+// The definition is the one of an added or changed column, the key the one of an added
+// key. Dropped and renamed keys are named by column and newColumn.
+type alterSpecification struct {
+	action     alterAction
+	column     string
+	newColumn  string
+	definition Column
+	key        Key
+	first      bool
+	after      string
+}
+
+type renameTableStatement struct {
+	renames [][2]tableName
+}
+
+type dropTableStatement struct {
+	tables []tableName
+}
+
+// Keywords starting an index or constraint definition instead of a column definition
+var tableConstraintKeywords = []string{"PRIMARY", "KEY", "INDEX", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK"}
+
+func parseUse(tokens []sqlToken) (string, bool) {
+	if len(tokens) != 2 || !tokens[0].isKeyword("USE") || !tokens[1].isIdentifier() {
+		return "", false
+	}
+
+	return tokens[1].text, true
+}
+
+func parseCreateTable(tokens []sqlToken) (createTableStatement, bool) {
+	i, ok := expectKeywords(tokens, 0, "CREATE")
+
+	if !ok {
+		return createTableStatement{}, false
+	}
+
+	i, _ = expectKeywords(tokens, i, "TEMPORARY")
+	i, ok = expectKeywords(tokens, i, "TABLE")
+
+	if !ok {
+		return createTableStatement{}, false
+	}
+
+	i, _ = expectKeywords(tokens, i, "IF", "NOT", "EXISTS")
+
+	name, i, ok := parseTableName(tokens, i)
+
+	if !ok || i >= len(tokens) {
+		return createTableStatement{}, false
+	}
+
+	if j, ok := expectKeywords(tokens, i, "LIKE"); ok {
+		if likeTable, _, ok := parseTableName(tokens, j); ok {
+			return createTableStatement{tableName: name, likeTable: &likeTable}, true
+		}
+	}
+
+	if tokens[i].isPunctuation("(") && i+1 < len(tokens) && tokens[i+1].isKeyword("LIKE") {
+		if likeTable, _, ok := parseTableName(tokens, i+2); ok {
+			return createTableStatement{tableName: name, likeTable: &likeTable}, true
+		}
+	}
+
+	if !tokens[i].isPunctuation("(") {
+		return createTableStatement{}, false // e.g. CREATE TABLE ... SELECT
+	}
+
+	// columns without a charset get the default charset of the table, from the table options
+	characterSet, collation := parseCharset(tokens[closingParenthesis(tokens, i):])
+	definitions := splitParenthesizedList(tokens, i)
+	columns := columnDefinitions(definitions)
+
+	for j, column := range columns {
+		if column.CharacterSet == "" && isCharacterType(column.Type) {
+			columns[j].CharacterSet = characterSet
+			columns[j].Collation = collation
+		}
+	}
+
+	return createTableStatement{tableName: name, columns: columns, keys: keyDefinitions(definitions)}, true
+}
+
+func parseAlterTable(tokens []sqlToken) (alterTableStatement, bool) {
+	i, ok := expectKeywords(tokens, 0, "ALTER")
+
+	if !ok {
+		return alterTableStatement{}, false
+	}
+
+	for i < len(tokens) && tokens[i].isKeyword("ONLINE", "OFFLINE", "IGNORE") {
+		i++
+	}
+
+	i, ok = expectKeywords(tokens, i, "TABLE")
+
+	if !ok {
+		return alterTableStatement{}, false
+	}
+
+	name, i, ok := parseTableName(tokens, i)
+
+	if !ok {
+		return alterTableStatement{}, false
+	}
+
+	statement := alterTableStatement{tableName: name}
+
+	for _, specification := range splitTopLevel(tokens[i:]) {
+		if renameTo, ok := parseRenameTo(specification); ok {
+			statement.renameTo = &renameTo
+			continue
+		}
+
+		statement.specifications = append(statement.specifications, parseAlterSpecification(specification)...)
+	}
+
+	return statement, true
+}
+
+func parseRenameTo(tokens []sqlToken) (tableName, bool) {
+	i, ok := expectKeywords(tokens, 0, "RENAME")
+
+	if !ok || i >= len(tokens) || tokens[i].isKeyword("COLUMN", "INDEX", "KEY") {
+		return tableName{}, false
+	}
+
+	if i < len(tokens) && tokens[i].isKeyword("TO", "AS") {
+		i++
+	}
+
+	name, _, ok := parseTableName(tokens, i)
+
+	return name, ok
+}
+
+// Parses an alter specification changing columns or primary and unique keys, other index
+// and table option changes are ignored
+func parseAlterSpecification(tokens []sqlToken) []alterSpecification {
+	if len(tokens) < 2 {
+		return nil
+	}
+
+	if key, ok := keyDefinition(tokens[1:]); ok && tokens[0].isKeyword("ADD") {
+		return []alterSpecification{{action: addKey, key: key}}
+	}
+
+	if name, ok := parseDropKey(tokens); ok {
+		return []alterSpecification{{action: dropKey, column: name}}
+	}
+
+	if len(tokens) == 5 && tokens[0].isKeyword("RENAME") && tokens[1].isKeyword("INDEX", "KEY") && tokens[3].isKeyword("TO") {
+		return []alterSpecification{{action: renameKey, column: tokens[2].text, newColumn: tokens[4].text}}
+	}
+
+	i := 1
+
+	if tokens[0].isKeyword("ADD", "DROP", "CHANGE", "MODIFY", "RENAME") && tokens[1].isKeyword("COLUMN") {
+		i++
+	}
+
+	if i >= len(tokens) {
+		return nil
+	}
+
+	switch {
+	case tokens[0].isKeyword("ADD"):
+		if tokens[i].isPunctuation("(") {
+			var specifications []alterSpecification
+
+			definitions := splitParenthesizedList(tokens, i)
+
+			for _, column := range columnDefinitions(definitions) {
+				specifications = append(specifications, alterSpecification{action: addColumn, column: column.Name, definition: column})
+			}
+
+			for _, key := range keyDefinitions(definitions) {
+				specifications = append(specifications, alterSpecification{action: addKey, key: key})
+			}
+
+			return specifications
+		}
+
+		if !tokens[i].isIdentifier() || i == 1 && tokens[i].isKeyword(append(tableConstraintKeywords, "PARTITION")...) {
+			return nil
+		}
+
+		first, after := parseColumnPosition(tokens[i+1:])
+		specifications := []alterSpecification{{action: addColumn, column: tokens[i].text, definition: columnDefinition(tokens[i:]), first: first, after: after}}
+
+		if key, ok := columnKey(tokens[i:]); ok {
+			specifications = append(specifications, alterSpecification{action: addKey, key: key})
+		}
+
+		return specifications
+
+	case tokens[0].isKeyword("DROP"):
+		if !tokens[i].isIdentifier() || i == 1 && tokens[i].isKeyword(append(tableConstraintKeywords, "PARTITION")...) {
+			return nil
+		}
+
+		return []alterSpecification{{action: dropColumn, column: tokens[i].text}}
+
+	case tokens[0].isKeyword("CHANGE"):
+		if i+1 >= len(tokens) || !tokens[i].isIdentifier() || !tokens[i+1].isIdentifier() {
+			return nil
+		}
+
+		first, after := parseColumnPosition(tokens[i+2:])
+
+		return []alterSpecification{{action: changeColumn, column: tokens[i].text, newColumn: tokens[i+1].text, definition: columnDefinition(tokens[i+1:]), first: first, after: after}}
+
+	case tokens[0].isKeyword("MODIFY"):
+		if !tokens[i].isIdentifier() {
+			return nil
+		}
+
+		first, after := parseColumnPosition(tokens[i+1:])
+
+		return []alterSpecification{{action: changeColumn, column: tokens[i].text, newColumn: tokens[i].text, definition: columnDefinition(tokens[i:]), first: first, after: after}}
+
+	case tokens[0].isKeyword("RENAME"):
+		if i != 2 || i+2 >= len(tokens) || !tokens[i+1].isKeyword("TO") {
+			return nil
+		}
+
+		return []alterSpecification{{action: renameColumn, column: tokens[i].text, newColumn: tokens[i+2].text}}
+	}
+
+	return nil
+}
+
+// Parses DROP PRIMARY KEY, DROP INDEX, DROP KEY and DROP CONSTRAINT, returning the name
+// of the dropped key
+func parseDropKey(tokens []sqlToken) (string, bool) {
+	if _, ok := expectKeywords(tokens, 0, "DROP", "PRIMARY", "KEY"); ok {
+		return primaryKeyName, true
+	}
+
+	if len(tokens) == 3 && tokens[0].isKeyword("DROP") && tokens[1].isKeyword("INDEX", "KEY", "CONSTRAINT") && tokens[2].isIdentifier() {
+		return tokens[2].text, true
+	}
+
+	return "", false
+}
+
+func parseColumnPosition(tokens []sqlToken) (bool, string) {
+	for i, token := range tokens {
+		if token.isKeyword("FIRST") {
+			return true, ""
+		}
+
+		if token.isKeyword("AFTER") && i+1 < len(tokens) && tokens[i+1].isIdentifier() {
+			return false, tokens[i+1].text
+		}
+	}
+
+	return false, ""
+}
+
+func parseRenameTable(tokens []sqlToken) (renameTableStatement, bool) {
+	i, ok := expectKeywords(tokens, 0, "RENAME")
+
+	if !ok {
+		return renameTableStatement{}, false
+	}
+
+	i, ok = expectKeywords(tokens, i, "TABLE")
+
+	if !ok {
+		return renameTableStatement{}, false
+	}
+
+	var statement renameTableStatement
+
+	for _, rename := range splitTopLevel(tokens[i:]) {
+		from, j, ok := parseTableName(rename, 0)
+
+		if !ok {
+			return renameTableStatement{}, false
+		}
+
+		j, ok = expectKeywords(rename, j, "TO")
+
+		if !ok {
+			return renameTableStatement{}, false
+		}
+
+		to, _, ok := parseTableName(rename, j)
+
+		if !ok {
+			return renameTableStatement{}, false
+		}
+
+		statement.renames = append(statement.renames, [2]tableName{from, to})
+	}
+
+	return statement, true
+}
+
+func parseDropTable(tokens []sqlToken) (dropTableStatement, bool) {
+	i, ok := expectKeywords(tokens, 0, "DROP")
+
+	if !ok {
+		return dropTableStatement{}, false
+	}
+
+	i, _ = expectKeywords(tokens, i, "TEMPORARY")
+	i, ok = expectKeywords(tokens, i, "TABLE")
+
+	if !ok {
+		return dropTableStatement{}, false
+	}
+
+	i, _ = expectKeywords(tokens, i, "IF", "EXISTS")
+
+	var statement dropTableStatement
+
+	for _, table := range splitTopLevel(tokens[i:]) {
+		name, _, ok := parseTableName(table, 0)
+
+		if !ok {
+			return dropTableStatement{}, false
+		}
+
+		statement.tables = append(statement.tables, name)
+	}
+
+	return statement, true
+}
+
+func columnDefinitions(definitions [][]sqlToken) []Column {
+	var columns []Column
+
+	for _, definition := range definitions {
+		if len(definition) == 0 || !definition[0].isIdentifier() {
+			continue
+		}
+
+		if definition[0].isKeyword(tableConstraintKeywords...) {
+			continue
+		}
+
+		columns = append(columns, columnDefinition(definition))
+	}
+
+	return columns
+}
+
+// Collects the primary and unique keys of the definitions of CREATE TABLE, declared
+// separately or as attributes of a column
+func keyDefinitions(definitions [][]sqlToken) []Key {
+	var keys []Key
+
+	for _, definition := range definitions {
+		if len(definition) == 0 || !definition[0].isIdentifier() {
+			continue
+		}
+
+		key, ok := keyDefinition(definition)
+
+		if !ok && !definition[0].isKeyword(tableConstraintKeywords...) {
+			key, ok = columnKey(definition)
+		}
+
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Parses [CONSTRAINT [symbol]] PRIMARY KEY (...) or [CONSTRAINT [symbol]] UNIQUE [INDEX|KEY]
+// [name] (...). Keys on expressions are skipped as they don't identify rows by columns.
+// Unnamed unique keys are named after their first column, like MySQL does.
+func keyDefinition(tokens []sqlToken) (Key, bool) {
+	i, symbol := 0, ""
+
+	if j, ok := expectKeywords(tokens, i, "CONSTRAINT"); ok {
+		i = j
+
+		if i < len(tokens) && tokens[i].isIdentifier() && !tokens[i].isKeyword("PRIMARY", "UNIQUE") {
+			symbol = tokens[i].text
+			i++
+		}
+	}
+
+	var key Key
+
+	if j, ok := expectKeywords(tokens, i, "PRIMARY", "KEY"); ok {
+		key.Name = primaryKeyName
+		i = j
+	} else if j, ok := expectKeywords(tokens, i, "UNIQUE"); ok {
+		i = j
+
+		if i < len(tokens) && tokens[i].isKeyword("INDEX", "KEY") {
+			i++
+		}
+
+		if i < len(tokens) && tokens[i].isIdentifier() && !tokens[i].isKeyword("USING") {
+			key.Name = tokens[i].text
+			i++
+		} else {
+			key.Name = symbol
+		}
+	} else {
+		return Key{}, false
+	}
+
+	if i < len(tokens) && tokens[i].isKeyword("USING") {
+		i += 2
+	}
+
+	if i >= len(tokens) || !tokens[i].isPunctuation("(") {
+		return Key{}, false
+	}
+
+	for _, part := range splitParenthesizedList(tokens, i) {
+		if len(part) == 0 || !part[0].isIdentifier() {
+			return Key{}, false
+		}
+
+		key.Columns = append(key.Columns, part[0].text)
+	}
+
+	if key.Name == "" {
+		key.Name = key.Columns[0]
+	}
+
+	return key, true
+}
+
+// Parses the PRIMARY KEY (or just KEY) and UNIQUE [KEY] attributes of a column definition
+// starting with the column name
+func columnKey(tokens []sqlToken) (Key, bool) {
+	depth := 0
+
+	for _, token := range tokens[1:] {
+		switch {
+		case token.isPunctuation("("):
+			depth++
+		case token.isPunctuation(")"):
+			depth--
+		case depth != 0:
+		case token.isKeyword("PRIMARY", "KEY"):
+			return Key{Name: primaryKeyName, Columns: []string{tokens[0].text}}, true
+		case token.isKeyword("UNIQUE"):
+			return Key{Name: tokens[0].text, Columns: []string{tokens[0].text}}, true
+		}
+	}
+
+	return Key{}, false
+}
+
+// Parses a column definition starting with the column name
+func columnDefinition(tokens []sqlToken) Column {
+	characterSet, collation := parseCharset(tokens[1:])
+
+	return Column{Name: tokens[0].text, Type: parseColumnType(tokens[1:]), CharacterSet: characterSet, Collation: collation}
+}
+
+// Formats the data type at the start of a column definition like COLUMN_TYPE in
+// information_schema, e.g. "int(10) unsigned" or "enum('a','b')". Attributes other than
+// UNSIGNED and ZEROFILL are left out.
+func parseColumnType(tokens []sqlToken) string {
+	if len(tokens) == 0 || tokens[0].kind != sqlWord {
+		return ""
+	}
+
+	columnType := strings.ToLower(tokens[0].text)
+	i := 1
+
+	if i < len(tokens) && tokens[i].isPunctuation("(") {
+		var arguments []string
+
+		for _, argument := range splitParenthesizedList(tokens, i) {
+			var text string
+
+			for _, token := range argument {
+				if token.kind == sqlString {
+					text += "'" + strings.Replace(token.text, "'", "''", -1) + "'"
+				} else {
+					text += token.text
+				}
+			}
+
+			arguments = append(arguments, text)
+		}
+
+		columnType += "(" + strings.Join(arguments, ",") + ")"
+		i = closingParenthesis(tokens, i)
+	}
+
+	for ; i < len(tokens) && tokens[i].isKeyword("UNSIGNED", "ZEROFILL", "SIGNED"); i++ {
+		if !tokens[i].isKeyword("SIGNED") {
+			columnType += " " + strings.ToLower(tokens[i].text)
+		}
+	}
+
+	return columnType
+}
+
+// Finds CHARACTER SET, CHARSET and COLLATE clauses outside of parentheses, in a column
+// definition or in table options. The charset of a collation is the start of its name.
+func parseCharset(tokens []sqlToken) (string, string) {
+	var characterSet, collation string
+	depth := 0
+
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case tokens[i].isPunctuation("("):
+			depth++
+			continue
+		case tokens[i].isPunctuation(")"):
+			depth--
+			continue
+		case depth != 0:
+			continue
+		}
+
+		j, ok := expectKeywords(tokens, i, "CHARACTER", "SET")
+
+		if !ok {
+			j, ok = expectKeywords(tokens, i, "CHARSET")
+		}
+
+		isCollation := false
+
+		if !ok {
+			j, ok = expectKeywords(tokens, i, "COLLATE")
+			isCollation = ok
+		}
+
+		if !ok {
+			continue
+		}
+
+		if j < len(tokens) && tokens[j].isPunctuation("=") {
+			j++
+		}
+
+		if j >= len(tokens) || tokens[j].kind == sqlPunctuation {
+			continue
+		}
+
+		if isCollation {
+			collation = strings.ToLower(tokens[j].text)
+		} else {
+			characterSet = strings.ToLower(tokens[j].text)
+		}
+
+		i = j
+	}
+
+	if characterSet == "" && collation != "" {
+		characterSet = strings.SplitN(collation, "_", 2)[0]
+	}
+
+	return characterSet, collation
+}
+
+func isCharacterType(columnType string) bool {
+	switch strings.SplitN(columnType, "(", 2)[0] {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return true
+	}
+
+	return false
+}
+
+// Returns the position after the parenthesis closing the one opening at position i
+func closingParenthesis(tokens []sqlToken, i int) int {
+	for depth := 0; i < len(tokens); i++ {
+		if tokens[i].isPunctuation("(") {
+			depth++
+		} else if tokens[i].isPunctuation(")") {
+			depth--
+
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+// Matches a sequence of keywords at position i, returning the position after them
+func expectKeywords(tokens []sqlToken, i int, keywords ...string) (int, bool) {
+	for j, keyword := range keywords {
+		if i+j >= len(tokens) || !tokens[i+j].isKeyword(keyword) {
+			return i, false
+		}
+	}
+
+	return i + len(keywords), true
+}
+
+func parseTableName(tokens []sqlToken, i int) (tableName, int, bool) {
+	if i >= len(tokens) || !tokens[i].isIdentifier() {
+		return tableName{}, i, false
+	}
+
+	if i+2 < len(tokens) && tokens[i+1].isPunctuation(".") && tokens[i+2].isIdentifier() {
+		return tableName{tokens[i].text, tokens[i+2].text}, i + 3, true
+	}
+
+	return tableName{"", tokens[i].text}, i + 1, true
+}
+
+// Splits the comma-separated list in the parentheses opening at position i, commas in
+// nested parentheses like DECIMAL(10,2) don't split
+func splitParenthesizedList(tokens []sqlToken, i int) [][]sqlToken {
+	var items [][]sqlToken
+	var item []sqlToken
+	depth := 0
+
+	for _, token := range tokens[i:] {
+		switch {
+		case token.isPunctuation("("):
+			depth++
+
+			if depth == 1 {
+				continue
+			}
+		case token.isPunctuation(")"):
+			depth--
+
+			if depth == 0 {
+				return append(items, item)
+			}
+		case token.isPunctuation(",") && depth == 1:
+			items = append(items, item)
+			item = nil
+			continue
+		}
+
+		item = append(item, token)
+	}
+
+	return append(items, item)
+}
+
+// Splits a comma-separated list which is not in parentheses
+func splitTopLevel(tokens []sqlToken) [][]sqlToken {
+	var items [][]sqlToken
+	var item []sqlToken
+	depth := 0
+
+	for _, token := range tokens {
+		switch {
+		case token.isPunctuation("("):
+			depth++
+		case token.isPunctuation(")"):
+			depth--
+		case token.isPunctuation(",") && depth == 0:
+			items = append(items, item)
+			item = nil
+			continue
+		}
+
+		item = append(item, token)
+	}
+
+	if len(item) > 0 {
+		items = append(items, item)
+	}
+
+	return items
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+var mysqldumpDatabaseComment = regexp.MustCompile(`(?m)^-- Host: .*Database: (\S+)`)
+
+// Loads the CREATE TABLE statements of a mysqldump --no-data file. Tables without a
+// schema name belong to the database of the last USE statement, or to the database named
+// in the dump header when the dump was created without --databases.
+func NewMysqldumpSchemaProvider(schemaFilename string) (SchemaProvider, error) {
+	content, err := ioutil.ReadFile(schemaFilename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider := newStaticSchemaProvider()
+	currentSchema := ""
+
+	if match := mysqldumpDatabaseComment.FindSubmatch(content); match != nil {
+		currentSchema = string(match[1])
+	}
+
+	for _, statement := range tokenizeSqlStatements(string(content)) {
+		if schema, ok := parseUse(statement); ok {
+			currentSchema = schema
+			continue
+		}
+
+		createTable, ok := parseCreateTable(statement)
+
+		if !ok {
+			continue
+		}
+
+		schema := createTable.schema
+
+		if schema == "" {
+			schema = currentSchema
+		}
+
+		if schema == "" {
+			return nil, fmt.Errorf("No database selected for table %s in schema file %s", createTable.table, schemaFilename)
+		}
+
+		columns, keys := createTable.columns, createTable.keys
+
+		if createTable.likeTable != nil {
+			likeSchema := createTable.likeTable.schema
+
+			if likeSchema == "" {
+				likeSchema = currentSchema
+			}
+
+			columns = provider.tables[tableKey(likeSchema, createTable.likeTable.table)]
+			keys = provider.keys[tableKey(likeSchema, createTable.likeTable.table)]
+		}
+
+		provider.setColumns(schema, createTable.table, columns)
+		provider.setKeys(schema, createTable.table, keys)
+	}
+
+	return provider, nil
+}
+
+// A table of a JSON schema snapshot with its keys
+type jsonSchemaTable struct {
+	Columns    []string
+	PrimaryKey []string   `json:"primary_key"`
+	UniqueKeys [][]string `json:"unique_keys"`
+}
+
+// Loads a JSON schema snapshot, mapping schema names to table names to the list of
+// column names in table order, or to the columns and keys of the table:
+//
+//	{"test_db": {
+//		"buildings": ["building_no", "building_name", "address"],
+//		"rooms": {"columns": ["room_no", "room_name"], "primary_key": ["room_no"], "unique_keys": [["room_name"]]}
+//	}}
+func NewJsonSchemaProvider(schemaFilename string) (SchemaProvider, error) {
+	content, err := ioutil.ReadFile(schemaFilename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot map[string]map[string]json.RawMessage
+
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("Invalid schema snapshot %s: %s", schemaFilename, err)
+	}
+
+	provider := newStaticSchemaProvider()
+
+	for schema, tables := range snapshot {
+		for table, tableSnapshot := range tables {
+			var snapshotTable jsonSchemaTable
+
+			if err := json.Unmarshal(tableSnapshot, &snapshotTable.Columns); err != nil {
+				if err := json.Unmarshal(tableSnapshot, &snapshotTable); err != nil {
+					return nil, fmt.Errorf("Invalid schema snapshot %s for table %s.%s: %s", schemaFilename, schema, table, err)
+				}
+			}
+
+			var columns []Column
+
+			for _, columnName := range snapshotTable.Columns {
+				columns = append(columns, Column{Name: columnName})
+			}
+
+			var keys []Key
+
+			if len(snapshotTable.PrimaryKey) > 0 {
+				keys = append(keys, Key{Name: primaryKeyName, Columns: snapshotTable.PrimaryKey})
+			}
+
+			for _, uniqueKey := range snapshotTable.UniqueKeys {
+				if len(uniqueKey) > 0 {
+					keys = append(keys, Key{Name: uniqueKey[0], Columns: uniqueKey})
+				}
+			}
+
+			provider.setColumns(schema, table, columns)
+			provider.setKeys(schema, table, keys)
+		}
+	}
+
+	return provider, nil
+}
@@ -0,0 +1,147 @@
+// +build unit
+
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMysqldumpSchemaProvider(t *testing.T) {
+	t.Run("Dump file", func(t *testing.T) {
+		provider, err := NewMysqldumpSchemaProvider(filepath.Join(os.Getenv("DATA_DIR"), "fixtures/test_db.sql"))
+
+		if err != nil {
+			t.Fatalf("Expected no error when loading dump file, got %s", err)
+		}
+
+		assertFields(t, provider, "test_db", "buildings", map[int]string{0: "building_no", 1: "building_name", 2: "address"})
+		assertFields(t, provider, "test_db", "departments", map[int]string{0: "dept_no", 1: "dept_name"})
+		assertFields(t, provider, "test_db", "rooms", map[int]string{0: "room_no", 1: "room_name", 2: "building_no"})
+		assertFields(t, provider, "test_db", "unknown_table", map[int]string{})
+		assertFields(t, provider, "other_db", "buildings", map[int]string{})
+
+		columns, _ := provider.GetColumns("test_db", "language")
+		expectedTypes := []string{"tinyint(3) unsigned", "char(20)", "timestamp", "varchar(255)"}
+
+		for i, column := range columns {
+			if column.Type != expectedTypes[i] {
+				t.Fatalf("Wrong type of column %s - got %s", column.Name, column.Type)
+			}
+		}
+	})
+
+	t.Run("Statements", func(t *testing.T) {
+		dump := "-- comment; with a semicolon\n" +
+			"CREATE TABLE IF NOT EXISTS `db_1`.`t``1` (\n" +
+			"  `price` decimal(10,2) DEFAULT '1;2', # comment\n" +
+			"  `key` int, /* comment */\n" +
+			"  name varchar(10) COMMENT 'it''s the \\'name\\'',\n" +
+			"  UNIQUE KEY `name` (`name`, `key`)\n" +
+			");\n" +
+			"USE db_2;\n" +
+			"CREATE TEMPORARY TABLE t2 (id int);\n" +
+			"CREATE TABLE t3 LIKE t2;\n"
+
+		provider := loadDump(t, dump)
+
+		assertFields(t, provider, "db_1", "t`1", map[int]string{0: "price", 1: "key", 2: "name"})
+		assertFields(t, provider, "db_2", "t2", map[int]string{0: "id"})
+		assertFields(t, provider, "db_2", "t3", map[int]string{0: "id"})
+
+		columns, _ := provider.GetColumns("db_1", "t`1")
+		expectedTypes := []string{"decimal(10,2)", "int", "varchar(10)"}
+
+		for i, column := range columns {
+			if column.Type != expectedTypes[i] {
+				t.Fatalf("Wrong type of column %s - got %s", column.Name, column.Type)
+			}
+		}
+
+		keys, _ := provider.GetKeys("db_1", "t`1")
+
+		if expectedKeys := []Key{{Name: "name", Columns: []string{"name", "key"}}}; !reflect.DeepEqual(keys, expectedKeys) {
+			t.Fatalf("Wrong keys - got %v", keys)
+		}
+	})
+
+	t.Run("No database selected", func(t *testing.T) {
+		tmpfile, _ := ioutil.TempFile("", "schema")
+		defer os.Remove(tmpfile.Name())
+
+		tmpfile.WriteString("CREATE TABLE t1 (id int);")
+		tmpfile.Close()
+
+		_, err := NewMysqldumpSchemaProvider(tmpfile.Name())
+
+		if err == nil {
+			t.Fatal("Expected error when no database is selected for a table")
+		}
+	})
+}
+
+func TestJsonSchemaProvider(t *testing.T) {
+	t.Run("Snapshot file", func(t *testing.T) {
+		provider, err := NewJsonSchemaProvider(filepath.Join(os.Getenv("DATA_DIR"), "fixtures/test_db_schema.json"))
+
+		if err != nil {
+			t.Fatalf("Expected no error when loading snapshot file, got %s", err)
+		}
+
+		assertFields(t, provider, "test_db", "buildings", map[int]string{0: "building_no", 1: "building_name", 2: "address"})
+		assertFields(t, provider, "test_db", "lookup", map[int]string{0: "id", 1: "value", 2: "shorttxt", 3: "longtxt"})
+		assertFields(t, provider, "test_db", "unknown_table", map[int]string{})
+
+		keys, _ := provider.GetKeys("test_db", "departments")
+		expectedKeys := []Key{{Name: "PRIMARY", Columns: []string{"dept_no"}}, {Name: "dept_name", Columns: []string{"dept_name"}}}
+
+		if !reflect.DeepEqual(keys, expectedKeys) {
+			t.Fatalf("Wrong keys - got %v", keys)
+		}
+	})
+
+	t.Run("Invalid snapshot file", func(t *testing.T) {
+		_, err := NewJsonSchemaProvider(filepath.Join(os.Getenv("DATA_DIR"), "fixtures/test_db.sql"))
+
+		if err == nil {
+			t.Fatal("Expected error when loading invalid snapshot file")
+		}
+	})
+}
+
+func loadDump(t *testing.T, dump string) SchemaProvider {
+	tmpfile, _ := ioutil.TempFile("", "schema")
+	defer os.Remove(tmpfile.Name())
+
+	tmpfile.WriteString(dump)
+	tmpfile.Close()
+
+	provider, err := NewMysqldumpSchemaProvider(tmpfile.Name())
+
+	if err != nil {
+		t.Fatalf("Expected no error when loading dump, got %s", err)
+	}
+
+	return provider
+}
+
+func assertFields(t *testing.T, provider SchemaProvider, schema, table string, expectedFields map[int]string) {
+	columns, err := provider.GetColumns(schema, table)
+
+	if err != nil {
+		t.Fatalf("Expected no error when getting columns, got %s", err)
+	}
+
+	fields := make(map[int]string)
+
+	for i, column := range columns {
+		fields[i] = column.Name
+	}
+
+	if !reflect.DeepEqual(fields, expectedFields) {
+		t.Fatalf("Wrong fields for table %s.%s - got %v", schema, table, fields)
+	}
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"github.com/golang/glog"
+	"strings"
+)
+
+// The schema of a table after a DDL statement in the binlog. A dropped table has no
+// columns.
+type SchemaVersion struct {
+	BinlogPosition uint32
+	Columns        []Column
+	Keys           []Key
+	Dropped        bool
+}
+
+// Applies the CREATE TABLE, ALTER TABLE, DROP TABLE and RENAME TABLE statements of a
+// query event, so that tables mapped after it get the schema valid at that position of
+// the binlog instead of the current one. Other statements are ignored.
+func (m *TableMap) ApplyQuery(defaultSchema, query string, binlogPosition uint32) error {
+	for _, tokens := range tokenizeSqlStatements(query) {
+		err := m.applyStatement(defaultSchema, tokens, binlogPosition)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *TableMap) SchemaHistory(schema, table string) []SchemaVersion {
+	return m.schemaHistory[tableKey(schema, table)]
+}
+
+func (m *TableMap) applyStatement(defaultSchema string, tokens []sqlToken, binlogPosition uint32) error {
+	qualify := func(name tableName) tableName {
+		if name.schema == "" {
+			name.schema = defaultSchema
+		}
+
+		return name
+	}
+
+	if statement, ok := parseCreateTable(tokens); ok {
+		columns, keys := statement.columns, statement.keys
+
+		if statement.likeTable != nil {
+			likeColumns, likeKeys, err := m.getTableSchema(qualify(*statement.likeTable))
+
+			if err != nil {
+				return err
+			}
+
+			columns, keys = likeColumns, likeKeys
+		}
+
+		m.addSchemaVersion(qualify(statement.tableName), columns, keys, binlogPosition)
+
+		return nil
+	}
+
+	if statement, ok := parseAlterTable(tokens); ok {
+		name := qualify(statement.tableName)
+		columns, keys, err := m.getTableSchema(name)
+
+		if err != nil {
+			return err
+		}
+
+		for _, specification := range statement.specifications {
+			columns = applyAlterSpecification(columns, specification)
+			keys = applyAlterSpecificationToKeys(keys, specification)
+		}
+
+		if statement.renameTo != nil {
+			m.addDroppedSchemaVersion(name, binlogPosition)
+			name = qualify(*statement.renameTo)
+		}
+
+		m.addSchemaVersion(name, columns, keys, binlogPosition)
+
+		return nil
+	}
+
+	if statement, ok := parseRenameTable(tokens); ok {
+		for _, rename := range statement.renames {
+			columns, keys, err := m.getTableSchema(qualify(rename[0]))
+
+			if err != nil {
+				return err
+			}
+
+			m.addDroppedSchemaVersion(qualify(rename[0]), binlogPosition)
+			m.addSchemaVersion(qualify(rename[1]), columns, keys, binlogPosition)
+		}
+
+		return nil
+	}
+
+	if statement, ok := parseDropTable(tokens); ok {
+		for _, name := range statement.tables {
+			m.addDroppedSchemaVersion(qualify(name), binlogPosition)
+		}
+	}
+
+	return nil
+}
+
+func (m *TableMap) getTableSchema(name tableName) ([]Column, []Key, error) {
+	columns, err := m.getColumns(name.schema, name.table)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := m.getKeys(name.schema, name.table)
+
+	return columns, keys, err
+}
+
+func (m *TableMap) addSchemaVersion(name tableName, columns []Column, keys []Key, binlogPosition uint32) {
+	glog.V(3).Infof("Schema of table %s.%s at position %d is %v with keys %v", name.schema, name.table, binlogPosition, columns, keys)
+
+	key := tableKey(name.schema, name.table)
+	m.schemaHistory[key] = append(m.schemaHistory[key], SchemaVersion{BinlogPosition: binlogPosition, Columns: columns, Keys: keys})
+}
+
+func (m *TableMap) addDroppedSchemaVersion(name tableName, binlogPosition uint32) {
+	glog.V(3).Infof("Table %s.%s dropped at position %d", name.schema, name.table, binlogPosition)
+
+	key := tableKey(name.schema, name.table)
+	m.schemaHistory[key] = append(m.schemaHistory[key], SchemaVersion{BinlogPosition: binlogPosition, Dropped: true})
+}
+
+// The schema of a table only altered in the binlog comes from the schema provider, which
+// may already contain the change unless the binlog was undone first, e.g. when streaming.
+// Adding an existing column or dropping a missing one is therefore skipped.
+func applyAlterSpecification(columns []Column, specification alterSpecification) []Column {
+	index := columnIndex(columns, specification.column)
+
+	switch specification.action {
+	case addColumn:
+		if index >= 0 {
+			return columns
+		}
+
+		return insertColumn(columns, specification.definition, len(columns), specification)
+
+	case dropColumn:
+		if index < 0 {
+			return columns
+		}
+
+		return removeColumn(columns, index)
+
+	case changeColumn:
+		if index < 0 {
+			return columns
+		}
+
+		return insertColumn(removeColumn(columns, index), specification.definition, index, specification)
+
+	case renameColumn:
+		if index < 0 {
+			return columns
+		}
+
+		renamed := append([]Column(nil), columns...)
+		renamed[index].Name = specification.newColumn
+
+		return renamed
+	}
+
+	return columns
+}
+
+// Adding a key replaces the key of the same name. Renamed columns are renamed in the keys,
+// dropped columns are removed from them and keys without columns left are dropped.
+func applyAlterSpecificationToKeys(keys []Key, specification alterSpecification) []Key {
+	var altered []Key
+
+	for _, key := range keys {
+		switch {
+		case specification.action == addKey && strings.EqualFold(key.Name, specification.key.Name),
+			specification.action == dropKey && strings.EqualFold(key.Name, specification.column):
+			continue
+		case specification.action == renameKey && strings.EqualFold(key.Name, specification.column):
+			key.Name = specification.newColumn
+		case specification.action == dropColumn, specification.action == changeColumn, specification.action == renameColumn:
+			key = alterKeyColumn(key, specification)
+		}
+
+		if len(key.Columns) > 0 {
+			altered = append(altered, key)
+		}
+	}
+
+	if specification.action == addKey {
+		altered = append(altered, specification.key)
+	}
+
+	return altered
+}
+
+func alterKeyColumn(key Key, specification alterSpecification) Key {
+	var columns []string
+
+	for _, column := range key.Columns {
+		if strings.EqualFold(column, specification.column) {
+			if specification.action == dropColumn {
+				continue
+			}
+
+			column = specification.newColumn
+		}
+
+		columns = append(columns, column)
+	}
+
+	return Key{Name: key.Name, Columns: columns}
+}
+
+// Inserts at the FIRST or AFTER position of the specification, or at the given index
+func insertColumn(columns []Column, column Column, index int, specification alterSpecification) []Column {
+	if specification.first {
+		index = 0
+	} else if specification.after != "" {
+		if afterIndex := columnIndex(columns, specification.after); afterIndex >= 0 {
+			index = afterIndex + 1
+		}
+	}
+
+	inserted := append([]Column(nil), columns[:index]...)
+	inserted = append(inserted, column)
+
+	return append(inserted, columns[index:]...)
+}
+
+func removeColumn(columns []Column, index int) []Column {
+	removed := append([]Column(nil), columns[:index]...)
+
+	return append(removed, columns[index+1:]...)
+}
+
+func columnIndex(columns []Column, column string) int {
+	for i, c := range columns {
+		if strings.EqualFold(c.Name, column) {
+			return i
+		}
+	}
+
+	return -1
+}
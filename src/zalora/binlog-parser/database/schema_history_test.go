@@ -0,0 +1,330 @@
+// +build unit
+
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyQuery(t *testing.T) {
+	newTableMap := func() TableMap {
+		provider := newStaticSchemaProvider()
+		provider.setColumns("test_db", "buildings", []Column{{Name: "building_no", Type: "int(11)"}, {Name: "building_name", Type: "varchar(255)"}, {Name: "address", Type: "varchar(355)"}})
+
+		return NewTableMapFromSchemaProvider(provider)
+	}
+
+	testCases := []struct {
+		name           string
+		queries        []string
+		table          string
+		expectedFields map[int]string
+	}{
+		{"No DDL", nil, "buildings", map[int]string{0: "building_no", 1: "building_name", 2: "address"}},
+		{"Other statements", []string{"DELETE FROM buildings", "CREATE DATABASE other_db"}, "buildings", map[int]string{0: "building_no", 1: "building_name", 2: "address"}},
+		{
+			"Create table",
+			[]string{"CREATE TABLE `language` (\n  `language_id` tinyint(3) unsigned NOT NULL,\n  `name` char(20) NOT NULL,\n  PRIMARY KEY (`language_id`)\n)"},
+			"language",
+			map[int]string{0: "language_id", 1: "name"},
+		},
+		{
+			"Create table like",
+			[]string{"CREATE TABLE buildings_copy LIKE test_db.buildings"},
+			"buildings_copy",
+			map[int]string{0: "building_no", 1: "building_name", 2: "address"},
+		},
+		{
+			"Alter table",
+			[]string{
+				"CREATE TABLE t (a int, b int, c int)",
+				"ALTER TABLE t ADD COLUMN d int AFTER a, ADD e int FIRST, DROP COLUMN b, ADD INDEX idx (c)",
+				"alter table t change c c2 varchar(10) default 'first', modify a int after d, rename column e to e2",
+			},
+			"t",
+			map[int]string{0: "e2", 1: "d", 2: "a", 3: "c2"},
+		},
+		{
+			"Alter table add columns",
+			[]string{"CREATE TABLE t (a int)", "ALTER TABLE t ADD (b int, c decimal(10,2), KEY (b))"},
+			"t",
+			map[int]string{0: "a", 1: "b", 2: "c"},
+		},
+		{
+			"Alter table from schema provider",
+			[]string{"ALTER TABLE buildings ADD address varchar(255), DROP unknown_column, ADD floors int"},
+			"buildings",
+			map[int]string{0: "building_no", 1: "building_name", 2: "address", 3: "floors"},
+		},
+		{"Alter table rename", []string{"ALTER TABLE buildings RENAME TO houses"}, "houses", map[int]string{0: "building_no", 1: "building_name", 2: "address"}},
+		{"Alter table renamed", []string{"ALTER TABLE buildings RENAME TO houses"}, "buildings", map[int]string{}},
+		{"Rename table", []string{"RENAME TABLE buildings TO tmp, tmp TO houses"}, "houses", map[int]string{0: "building_no", 1: "building_name", 2: "address"}},
+		{"Rename table swapped", []string{"RENAME TABLE buildings TO tmp, tmp TO houses"}, "tmp", map[int]string{}},
+		{"Drop table", []string{"DROP TABLE IF EXISTS `buildings`,`rooms` /* generated by server */"}, "buildings", map[int]string{}},
+		{"Drop and create table", []string{"DROP TABLE buildings", "CREATE TABLE buildings (id int)"}, "buildings", map[int]string{0: "id"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tableMap := newTableMap()
+
+			for i, query := range tc.queries {
+				err := tableMap.ApplyQuery("test_db", query, uint32(100*(i+1)))
+
+				if err != nil {
+					t.Fatalf("Expected no error when applying query, got %s", err)
+				}
+			}
+
+			tableMap.Add(1, "test_db", tc.table)
+			tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+			if !reflect.DeepEqual(tableMetadata.Fields, tc.expectedFields) {
+				t.Fatalf("Wrong fields for table %s - got %v", tc.table, tableMetadata.Fields)
+			}
+		})
+	}
+
+	t.Run("Column types", func(t *testing.T) {
+		tableMap := newTableMap()
+
+		tableMap.ApplyQuery("test_db", "CREATE TABLE t (a int(10) unsigned zerofill, b enum('x','it''s') NOT NULL, c decimal(10, 2) unsigned)", 100)
+		tableMap.ApplyQuery("test_db", "ALTER TABLE t MODIFY b varchar(10), CHANGE c d bigint unsigned, ADD e tinyint signed, RENAME COLUMN a TO f", 200)
+		tableMap.Add(1, "test_db", "t")
+
+		tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+		expectedColumns := map[int]ColumnMetadata{
+			0: {Type: "int(10) unsigned zerofill", Unsigned: true},
+			1: {Type: "varchar(10)"},
+			2: {Type: "bigint unsigned", Unsigned: true},
+			3: {Type: "tinyint"},
+		}
+
+		if !reflect.DeepEqual(tableMetadata.Columns, expectedColumns) {
+			t.Fatalf("Wrong columns in table metadata - got %v", tableMetadata.Columns)
+		}
+
+		created := tableMap.SchemaHistory("test_db", "t")[0].Columns
+		expectedCreated := []Column{{Name: "a", Type: "int(10) unsigned zerofill"}, {Name: "b", Type: "enum('x','it''s')"}, {Name: "c", Type: "decimal(10,2) unsigned"}}
+
+		if !reflect.DeepEqual(created, expectedCreated) {
+			t.Fatalf("Wrong columns in schema history - got %v", created)
+		}
+	})
+
+	t.Run("Character sets", func(t *testing.T) {
+		tableMap := newTableMap()
+
+		tableMap.ApplyQuery("test_db", "CREATE TABLE t (a varchar(10), b text CHARACTER SET utf8mb4 COLLATE utf8mb4_bin, c char(2) COLLATE ascii_bin, d int, e blob) DEFAULT CHARSET=latin1", 100)
+		tableMap.ApplyQuery("test_db", "ALTER TABLE t ADD f varchar(10) CHARSET utf16", 200)
+		tableMap.Add(1, "test_db", "t")
+
+		tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+		expectedColumns := map[int]ColumnMetadata{
+			0: {Type: "varchar(10)", CharacterSet: "latin1"},
+			1: {Type: "text", CharacterSet: "utf8mb4", Collation: "utf8mb4_bin"},
+			2: {Type: "char(2)", CharacterSet: "ascii", Collation: "ascii_bin"},
+			3: {Type: "int"},
+			4: {Type: "blob"},
+			5: {Type: "varchar(10)", CharacterSet: "utf16"},
+		}
+
+		if !reflect.DeepEqual(tableMetadata.Columns, expectedColumns) {
+			t.Fatalf("Wrong columns in table metadata - got %v", tableMetadata.Columns)
+		}
+	})
+
+	t.Run("ENUM and SET values", func(t *testing.T) {
+		tableMap := newTableMap()
+
+		tableMap.ApplyQuery("test_db", "CREATE TABLE t (a ENUM('new', 'it''s', 'a,b'), b SET('x', 'y;z'), c varchar(10) DEFAULT 'enum(''x'')')", 100)
+		tableMap.Add(1, "test_db", "t")
+
+		tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+		expectedValues := [][]string{{"new", "it's", "a,b"}, {"x", "y;z"}, nil}
+
+		for i, values := range expectedValues {
+			if !reflect.DeepEqual(tableMetadata.Columns[i].Values, values) {
+				t.Fatalf("Wrong values of column %d - got %v", i, tableMetadata.Columns[i].Values)
+			}
+		}
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		testCases := []struct {
+			name               string
+			queries            []string
+			expectedPrimaryKey []int
+			expectedUniqueKeys [][]int
+		}{
+			{
+				"Create table",
+				[]string{"CREATE TABLE t (a int, b int UNIQUE, c int, d int, CONSTRAINT pk PRIMARY KEY USING BTREE (c, `a`), UNIQUE KEY (d(10) DESC), UNIQUE idx ((a + 1)), KEY (b))"},
+				[]int{2, 0},
+				[][]int{{1}, {3}},
+			},
+			{"Column keys", []string{"CREATE TABLE t (a int NOT NULL KEY, b int UNIQUE KEY)"}, []int{0}, [][]int{{1}}},
+			{"From schema provider", []string{"CREATE TABLE t LIKE buildings", "ALTER TABLE t ADD PRIMARY KEY (building_no)"}, []int{0}, nil},
+			{
+				"Alter table",
+				[]string{
+					"CREATE TABLE t (a int PRIMARY KEY, b int, c int, UNIQUE KEY bc (b, c))",
+					"ALTER TABLE t DROP PRIMARY KEY, ADD CONSTRAINT u UNIQUE (c), ADD PRIMARY KEY (b), ADD d int UNIQUE",
+					"ALTER TABLE t DROP COLUMN b, RENAME INDEX u TO u2, CHANGE c c2 int",
+				},
+				nil,
+				[][]int{{1}, {1}, {2}},
+			},
+			{"Drop key", []string{"CREATE TABLE t (a int, UNIQUE KEY ua (a), UNIQUE KEY ua2 (a))", "ALTER TABLE t DROP INDEX ua, DROP KEY `ua2`"}, nil, nil},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				tableMap := newTableMap()
+
+				for i, query := range tc.queries {
+					tableMap.ApplyQuery("test_db", query, uint32(100*(i+1)))
+				}
+
+				tableMap.Add(1, "test_db", "t")
+				tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+				if !reflect.DeepEqual(tableMetadata.PrimaryKey, tc.expectedPrimaryKey) || !reflect.DeepEqual(tableMetadata.UniqueKeys, tc.expectedUniqueKeys) {
+					t.Fatalf("Wrong keys - got %v and %v", tableMetadata.PrimaryKey, tableMetadata.UniqueKeys)
+				}
+			})
+		}
+	})
+
+	t.Run("Schema history", func(t *testing.T) {
+		tableMap := newTableMap()
+
+		tableMap.Add(1, "test_db", "t")
+		tableMap.ApplyQuery("test_db", "CREATE TABLE t (a int)", 100)
+		tableMap.Add(2, "test_db", "t")
+		tableMap.ApplyQuery("other_db", "ALTER TABLE test_db.t ADD b int", 200)
+		tableMap.Add(3, "test_db", "t")
+		tableMap.ApplyQuery("test_db", "DROP TABLE t", 300)
+
+		expectedHistory := []SchemaVersion{
+			{100, []Column{{Name: "a", Type: "int"}}, nil, false},
+			{200, []Column{{Name: "a", Type: "int"}, {Name: "b", Type: "int"}}, nil, false},
+			{300, nil, nil, true},
+		}
+
+		if history := tableMap.SchemaHistory("test_db", "t"); !reflect.DeepEqual(history, expectedHistory) {
+			t.Fatalf("Wrong schema history - got %v", history)
+		}
+
+		expectedFields := []map[int]string{{}, {0: "a"}, {0: "a", 1: "b"}}
+
+		for i, fields := range expectedFields {
+			tableMetadata, _ := tableMap.LookupTableMetadata(uint64(i + 1))
+
+			if !reflect.DeepEqual(tableMetadata.Fields, fields) {
+				t.Fatalf("Wrong fields for table id %d - got %v", i+1, tableMetadata.Fields)
+			}
+		}
+	})
+}
+
+func TestUndoQueries(t *testing.T) {
+	testCases := []struct {
+		name           string
+		queries        []string
+		table          string
+		expectedBefore map[int]string
+		expectedAfter  map[int]string
+	}{
+		{
+			"Add column",
+			[]string{"ALTER TABLE buildings ADD address varchar(355)"},
+			"buildings",
+			map[int]string{0: "building_no", 1: "building_name"},
+			map[int]string{0: "building_no", 1: "building_name", 2: "address"},
+		},
+		{
+			"Drop column",
+			[]string{"ALTER TABLE buildings DROP COLUMN floors"},
+			"buildings",
+			map[int]string{0: "building_no", 1: "building_name", 2: "address", 3: "floors"},
+			map[int]string{0: "building_no", 1: "building_name", 2: "address"},
+		},
+		{
+			"Change and rename columns",
+			[]string{"ALTER TABLE buildings CHANGE name building_name varchar(255)", "ALTER TABLE buildings RENAME COLUMN street TO address"},
+			"buildings",
+			map[int]string{0: "building_no", 1: "name", 2: "street"},
+			map[int]string{0: "building_no", 1: "building_name", 2: "address"},
+		},
+		{
+			"Rename table",
+			[]string{"ALTER TABLE houses ADD address varchar(355)", "RENAME TABLE houses TO buildings"},
+			"houses",
+			map[int]string{0: "building_no", 1: "building_name"},
+			map[int]string{},
+		},
+		{
+			"Table created in the binlog",
+			[]string{"DROP TABLE buildings", "CREATE TABLE buildings (id int)"},
+			"buildings",
+			map[int]string{0: "building_no", 1: "building_name", 2: "address"},
+			map[int]string{0: "id"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := newStaticSchemaProvider()
+			provider.setColumns("test_db", "buildings", []Column{{Name: "building_no", Type: "int(11)"}, {Name: "building_name", Type: "varchar(255)"}, {Name: "address", Type: "varchar(355)"}})
+
+			tableMap := NewTableMapFromSchemaProvider(provider)
+
+			var queries []SchemaQuery
+
+			for _, query := range tc.queries {
+				queries = append(queries, SchemaQuery{"test_db", query})
+			}
+
+			if err := tableMap.UndoQueries(queries); err != nil {
+				t.Fatalf("Expected no error when undoing queries, got %s", err)
+			}
+
+			tableMap.Add(1, "test_db", tc.table)
+
+			for i, query := range tc.queries {
+				tableMap.ApplyQuery("test_db", query, uint32(100*(i+1)))
+			}
+
+			tableMap.Add(2, "test_db", tc.table)
+
+			for id, expectedFields := range []map[int]string{tc.expectedBefore, tc.expectedAfter} {
+				tableMetadata, _ := tableMap.LookupTableMetadata(uint64(id + 1))
+
+				if !reflect.DeepEqual(tableMetadata.Fields, expectedFields) {
+					t.Fatalf("Wrong fields for table id %d - got %v", id+1, tableMetadata.Fields)
+				}
+			}
+		})
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		provider := newStaticSchemaProvider()
+		provider.setColumns("test_db", "t", []Column{{Name: "a", Type: "int"}, {Name: "b2", Type: "int"}})
+		provider.setKeys("test_db", "t", []Key{{Name: "PRIMARY", Columns: []string{"a"}}, {Name: "ub", Columns: []string{"b2"}}})
+
+		tableMap := NewTableMapFromSchemaProvider(provider)
+		tableMap.UndoQueries([]SchemaQuery{{"test_db", "ALTER TABLE t ADD PRIMARY KEY (a), CHANGE b b2 int"}})
+		tableMap.Add(1, "test_db", "t")
+
+		tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+		if tableMetadata.PrimaryKey != nil || !reflect.DeepEqual(tableMetadata.UniqueKeys, [][]int{{1}}) || tableMetadata.Fields[1] != "b" {
+			t.Fatalf("Wrong keys - got %v and %v", tableMetadata.PrimaryKey, tableMetadata.UniqueKeys)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Provides the columns of a table in table order, and its primary and unique keys
+type SchemaProvider interface {
+	GetColumns(schema, table string) ([]Column, error)
+	GetKeys(schema, table string) ([]Key, error)
+}
+
+// The type is formatted like COLUMN_TYPE in information_schema, e.g. "int(10) unsigned",
+// and empty if not known. Only character columns have a character set and collation.
+type Column struct {
+	Name         string
+	Type         string
+	CharacterSet string
+	Collation    string
+}
+
+// The primary key is named PRIMARY like in information_schema, the columns are in key order
+type Key struct {
+	Name    string
+	Columns []string
+}
+
+const primaryKeyName = "PRIMARY"
+
+func (c Column) unsigned() bool {
+	for _, attribute := range strings.Fields(c.Type) {
+		if attribute == "unsigned" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The permitted values of ENUM and SET columns, in the order of their index
+func (c Column) permittedValues() []string {
+	if !strings.HasPrefix(c.Type, "enum(") && !strings.HasPrefix(c.Type, "set(") {
+		return nil
+	}
+
+	var values []string
+
+	for _, statement := range tokenizeSqlStatements(c.Type) {
+		for _, value := range splitParenthesizedList(statement, 1) {
+			if len(value) == 1 && value[0].kind == sqlString {
+				values = append(values, value[0].text)
+			}
+		}
+	}
+
+	return values
+}
+
+type dbSchemaProvider struct {
+	db *sql.DB
+}
+
+func NewDbSchemaProvider(db *sql.DB) SchemaProvider {
+	return dbSchemaProvider{db}
+}
+
+func (p dbSchemaProvider) GetColumns(schema, table string) ([]Column, error) {
+	rows, err := p.db.Query(
+		"SELECT COLUMN_NAME, COLUMN_TYPE, IFNULL(CHARACTER_SET_NAME, ''), IFNULL(COLLATION_NAME, '') FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		schema,
+		table,
+	)
+
+	if err != nil {
+		q := newQueryError(err)
+		return nil, &q
+	}
+
+	defer rows.Close()
+
+	var columns []Column
+
+	var column Column
+	for rows.Next() {
+		err := rows.Scan(&column.Name, &column.Type, &column.CharacterSet, &column.Collation)
+
+		if err != nil {
+			q := newQueryError(err)
+			return nil, &q
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// Foreign keys are in KEY_COLUMN_USAGE as well, with the table they reference
+func (p dbSchemaProvider) GetKeys(schema, table string) ([]Key, error) {
+	rows, err := p.db.Query(
+		"SELECT CONSTRAINT_NAME, COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND REFERENCED_TABLE_NAME IS NULL ORDER BY CONSTRAINT_NAME != 'PRIMARY', CONSTRAINT_NAME, ORDINAL_POSITION",
+		schema,
+		table,
+	)
+
+	if err != nil {
+		q := newQueryError(err)
+		return nil, &q
+	}
+
+	defer rows.Close()
+
+	var keys []Key
+
+	var name, columnName string
+	for rows.Next() {
+		err := rows.Scan(&name, &columnName)
+
+		if err != nil {
+			q := newQueryError(err)
+			return nil, &q
+		}
+
+		if len(keys) == 0 || keys[len(keys)-1].Name != name {
+			keys = append(keys, Key{Name: name})
+		}
+
+		keys[len(keys)-1].Columns = append(keys[len(keys)-1].Columns, columnName)
+	}
+
+	return keys, nil
+}
+
+// Schema loaded up front, e.g. from a schema file. Unknown tables have no fields, like
+// a table missing in information_schema.
+type staticSchemaProvider struct {
+	tables map[string][]Column
+	keys   map[string][]Key
+}
+
+func newStaticSchemaProvider() staticSchemaProvider {
+	return staticSchemaProvider{make(map[string][]Column), make(map[string][]Key)}
+}
+
+func (p staticSchemaProvider) GetColumns(schema, table string) ([]Column, error) {
+	return p.tables[tableKey(schema, table)], nil
+}
+
+func (p staticSchemaProvider) GetKeys(schema, table string) ([]Key, error) {
+	return p.keys[tableKey(schema, table)], nil
+}
+
+func (p staticSchemaProvider) setColumns(schema, table string, columns []Column) {
+	p.tables[tableKey(schema, table)] = columns
+}
+
+func (p staticSchemaProvider) setKeys(schema, table string, keys []Key) {
+	p.keys[tableKey(schema, table)] = keys
+}
+
+func tableKey(schema, table string) string {
+	return schema + "." + table
+}
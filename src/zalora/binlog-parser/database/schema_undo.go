@@ -0,0 +1,166 @@
+package database
+
+import (
+	"github.com/golang/glog"
+)
+
+// A query of a query event, with the schema it was executed in
+type SchemaQuery struct {
+	DefaultSchema string
+	Query         string
+}
+
+// The schema of a table before the statements undone so far. It isn't known for tables
+// the schema provider doesn't know, and for tables created, dropped or renamed to by
+// the statements.
+type undoneSchema struct {
+	columns []Column
+	keys    []Key
+	known   bool
+}
+
+// Undoes the CREATE TABLE, ALTER TABLE, DROP TABLE and RENAME TABLE statements of the
+// queries, last one first, starting from the current schemas of the schema provider.
+// Tables then start with the schema they had before the first query, so that rows
+// logged before an ALTER TABLE are mapped with the columns before it once the queries
+// are applied. Tables with a schema not known before the queries keep the current one.
+func (m *TableMap) UndoQueries(queries []SchemaQuery) error {
+	schemas := make(map[tableName]*undoneSchema)
+
+	for i := len(queries) - 1; i >= 0; i-- {
+		statements := tokenizeSqlStatements(queries[i].Query)
+
+		for j := len(statements) - 1; j >= 0; j-- {
+			err := m.undoStatement(schemas, queries[i].DefaultSchema, statements[j])
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, schema := range schemas {
+		if !schema.known {
+			continue
+		}
+
+		glog.V(3).Infof("Schema of table %s.%s before the binlog is %v with keys %v", name.schema, name.table, schema.columns, schema.keys)
+
+		cacheKey := name.schema + "_" + name.table
+		m.columnsCache[cacheKey] = schema.columns
+		m.keysCache[cacheKey] = schema.keys
+	}
+
+	return nil
+}
+
+func (m *TableMap) undoStatement(schemas map[tableName]*undoneSchema, defaultSchema string, tokens []sqlToken) error {
+	qualify := func(name tableName) tableName {
+		if name.schema == "" {
+			name.schema = defaultSchema
+		}
+
+		return name
+	}
+
+	lookup := func(name tableName) (*undoneSchema, error) {
+		if schema, ok := schemas[name]; ok {
+			return schema, nil
+		}
+
+		columns, keys, err := m.getTableSchema(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		schema := &undoneSchema{columns: columns, keys: keys, known: len(columns) > 0}
+		schemas[name] = schema
+
+		return schema, nil
+	}
+
+	if statement, ok := parseCreateTable(tokens); ok {
+		schemas[qualify(statement.tableName)] = &undoneSchema{}
+
+		return nil
+	}
+
+	if statement, ok := parseAlterTable(tokens); ok {
+		name := qualify(statement.tableName)
+		altered := name
+
+		if statement.renameTo != nil {
+			altered = qualify(*statement.renameTo)
+		}
+
+		schema, err := lookup(altered)
+
+		if err != nil {
+			return err
+		}
+
+		undone := &undoneSchema{columns: schema.columns, keys: schema.keys, known: schema.known}
+
+		for i := len(statement.specifications) - 1; i >= 0; i-- {
+			if inverse, ok := inverseAlterSpecification(statement.specifications[i]); ok {
+				undone.columns = applyAlterSpecification(undone.columns, inverse)
+				undone.keys = applyAlterSpecificationToKeys(undone.keys, inverse)
+			}
+		}
+
+		if altered != name {
+			schemas[altered] = &undoneSchema{}
+		}
+
+		schemas[name] = undone
+
+		return nil
+	}
+
+	if statement, ok := parseRenameTable(tokens); ok {
+		for i := len(statement.renames) - 1; i >= 0; i-- {
+			from, to := qualify(statement.renames[i][0]), qualify(statement.renames[i][1])
+			schema, err := lookup(to)
+
+			if err != nil {
+				return err
+			}
+
+			schemas[to] = &undoneSchema{}
+			schemas[from] = schema
+		}
+
+		return nil
+	}
+
+	if statement, ok := parseDropTable(tokens); ok {
+		for _, name := range statement.tables {
+			schemas[qualify(name)] = &undoneSchema{}
+		}
+	}
+
+	return nil
+}
+
+// The type of changed columns and the columns of dropped keys are not logged, so they
+// stay unknown. The position of dropped columns isn't logged either, they are added
+// back as the last column.
+func inverseAlterSpecification(specification alterSpecification) (alterSpecification, bool) {
+	switch specification.action {
+	case addColumn:
+		return alterSpecification{action: dropColumn, column: specification.column}, true
+	case dropColumn:
+		return alterSpecification{action: addColumn, column: specification.column, definition: Column{Name: specification.column}}, true
+	case changeColumn:
+		return alterSpecification{action: changeColumn, column: specification.newColumn, newColumn: specification.column, definition: Column{Name: specification.column}}, true
+	case renameColumn:
+		return alterSpecification{action: renameColumn, column: specification.newColumn, newColumn: specification.column}, true
+	case addKey:
+		return alterSpecification{action: dropKey, column: specification.key.Name}, true
+	case renameKey:
+		return alterSpecification{action: renameKey, column: specification.newColumn, newColumn: specification.column}, true
+	}
+
+	return alterSpecification{}, false
+}
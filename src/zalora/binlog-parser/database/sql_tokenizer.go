@@ -0,0 +1,131 @@
+package database
+
+import (
+	"strings"
+)
+
+type sqlTokenKind int
+
+const (
+	sqlWord sqlTokenKind = iota
+	sqlQuotedIdentifier
+	sqlString
+	sqlPunctuation
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func (t sqlToken) isKeyword(keywords ...string) bool {
+	if t.kind != sqlWord {
+		return false
+	}
+
+	for _, keyword := range keywords {
+		if strings.EqualFold(t.text, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t sqlToken) isPunctuation(punctuation string) bool {
+	return t.kind == sqlPunctuation && t.text == punctuation
+}
+
+func (t sqlToken) isIdentifier() bool {
+	return t.kind == sqlWord || t.kind == sqlQuotedIdentifier
+}
+
+// Splits SQL into statements of tokens, dropping comments. Versioned comments like
+// /*!40101 ... */ are dropped as well, they only hold options that don't matter for the
+// table structure.
+func tokenizeSqlStatements(sql string) [][]sqlToken {
+	var statements [][]sqlToken
+	var statement []sqlToken
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(sql[i:], "-- ") || strings.HasPrefix(sql[i:], "--\n") || sql[i:] == "--":
+			i = skipPast(sql, i, "\n")
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipPast(sql, i+2, "*/")
+		case c == '`':
+			text, end := readQuoted(sql, i)
+			statement = append(statement, sqlToken{sqlQuotedIdentifier, text})
+			i = end
+		case c == '\'' || c == '"':
+			text, end := readQuoted(sql, i)
+			statement = append(statement, sqlToken{sqlString, text})
+			i = end
+		case c == ';':
+			if len(statement) > 0 {
+				statements = append(statements, statement)
+			}
+
+			statement = nil
+			i++
+		case strings.IndexByte("(),.=", c) >= 0:
+			statement = append(statement, sqlToken{sqlPunctuation, string(c)})
+			i++
+		default:
+			end := i + 1
+
+			for end < len(sql) && strings.IndexByte(" \t\n\r(),.=;`'\"", sql[end]) < 0 {
+				end++
+			}
+
+			statement = append(statement, sqlToken{sqlWord, sql[i:end]})
+			i = end
+		}
+	}
+
+	if len(statement) > 0 {
+		statements = append(statements, statement)
+	}
+
+	return statements
+}
+
+func skipPast(sql string, i int, terminator string) int {
+	end := strings.Index(sql[i:], terminator)
+
+	if end < 0 {
+		return len(sql)
+	}
+
+	return i + end + len(terminator)
+}
+
+// Reads a quoted string or identifier starting at i, quotes are escaped by doubling them
+// or, except in identifiers, with a backslash
+func readQuoted(sql string, i int) (string, int) {
+	quote := sql[i]
+	var text []byte
+
+	for i++; i < len(sql); i++ {
+		c := sql[i]
+
+		switch {
+		case c == '\\' && quote != '`' && i+1 < len(sql):
+			i++
+			text = append(text, sql[i])
+		case c == quote && i+1 < len(sql) && sql[i+1] == quote:
+			i++
+			text = append(text, quote)
+		case c == quote:
+			return string(text), i + 1
+		default:
+			text = append(text, c)
+		}
+	}
+
+	return string(text), i
+}
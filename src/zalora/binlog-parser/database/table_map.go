@@ -5,89 +5,165 @@ import (
 	"fmt"
 )
 
+// Columns hold what is known about the columns from the schema or from the binlog, see
+// binlog_row_metadata in MySQL 8. The PrimaryKey and UniqueKeys are the indexes of their
+// columns, the PrimaryKey comes from the schema or the binlog, UniqueKeys only from the
+// schema.
 type TableMetadata struct {
-	Schema string
-	Table  string
-	Fields map[int]string
+	Schema     string
+	Table      string
+	Fields     map[int]string
+	Columns    map[int]ColumnMetadata
+	PrimaryKey []int
+	UniqueKeys [][]int
+}
+
+// The character set and collation names come from the schema, the collation id from the
+// binlog. Values are the permitted values of ENUM and SET columns.
+type ColumnMetadata struct {
+	Type         string // COLUMN_TYPE, empty if not known
+	Unsigned     bool
+	Charset      uint64 // collation id, 0 if not known
+	CharacterSet string
+	Collation    string
+	Values       []string
 }
 
 type TableMap struct {
 	tableMetadataMap map[uint64]TableMetadata
-	fieldsCache      map[string]map[int]string
-	db               *sql.DB
+	columnsCache     map[string][]Column
+	keysCache        map[string][]Key
+	schemaHistory    map[string][]SchemaVersion
+	schemaProvider   SchemaProvider
 }
 
 func NewTableMap(db *sql.DB) TableMap {
+	return NewTableMapFromSchemaProvider(NewDbSchemaProvider(db))
+}
+
+func NewTableMapFromSchemaProvider(schemaProvider SchemaProvider) TableMap {
 	return TableMap{
-		db:               db,
+		schemaProvider:   schemaProvider,
 		tableMetadataMap: make(map[uint64]TableMetadata),
-		fieldsCache:      make(map[string]map[int]string),
+		columnsCache:     make(map[string][]Column),
+		keysCache:        make(map[string][]Key),
+		schemaHistory:    make(map[string][]SchemaVersion),
 	}
 }
 
 func (m *TableMap) Add(id uint64, schema, table string) error {
-	fields, err := m.getFields(schema, table)
+	columns, err := m.getColumns(schema, table)
+
+	if err != nil {
+		return err
+	}
+
+	tableMetadata := TableMetadata{Schema: schema, Table: table, Fields: make(map[int]string), Columns: make(map[int]ColumnMetadata)}
+
+	for i, column := range columns {
+		tableMetadata.Fields[i] = column.Name
+
+		if column.Type != "" {
+			tableMetadata.Columns[i] = ColumnMetadata{
+				Type:         column.Type,
+				Unsigned:     column.unsigned(),
+				CharacterSet: column.CharacterSet,
+				Collation:    column.Collation,
+				Values:       column.permittedValues(),
+			}
+		}
+	}
+
+	keys, err := m.getKeys(schema, table)
 
 	if err != nil {
 		return err
 	}
 
-	m.tableMetadataMap[id] = TableMetadata{schema, table, fields}
+	for _, key := range keys {
+		indexes, ok := keyColumnIndexes(columns, key)
+
+		if !ok {
+			continue
+		}
+
+		if key.Name == primaryKeyName {
+			tableMetadata.PrimaryKey = indexes
+		} else {
+			tableMetadata.UniqueKeys = append(tableMetadata.UniqueKeys, indexes)
+		}
+	}
+
+	m.tableMetadataMap[id] = tableMetadata
 
 	return nil
 }
 
+// Keys with columns missing from the table are skipped
+func keyColumnIndexes(columns []Column, key Key) ([]int, bool) {
+	var indexes []int
+
+	for _, column := range key.Columns {
+		index := columnIndex(columns, column)
+
+		if index < 0 {
+			return nil, false
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	return indexes, true
+}
+
+// Adds table metadata taken from the binlog itself, without looking up the fields
+func (m *TableMap) AddTableMetadata(id uint64, tableMetadata TableMetadata) {
+	m.tableMetadataMap[id] = tableMetadata
+}
+
 func (m *TableMap) LookupTableMetadata(id uint64) (TableMetadata, bool) {
 	val, ok := m.tableMetadataMap[id]
 	return val, ok
 }
 
-func (m *TableMap) getFields(schema, table string) (map[int]string, error) {
+func (m *TableMap) getColumns(schema, table string) ([]Column, error) {
 	cacheKey := fmt.Sprintf("%s_%s", schema, table)
 
-	if cachedFields, ok := m.fieldsCache[cacheKey]; ok {
-		return cachedFields, nil
+	if versions := m.schemaHistory[tableKey(schema, table)]; len(versions) > 0 {
+		return versions[len(versions)-1].Columns, nil
 	}
 
-	fields, err := getFieldsFromDb(m.db, schema, table)
-	m.fieldsCache[cacheKey] = fields
+	if cachedColumns, ok := m.columnsCache[cacheKey]; ok {
+		return cachedColumns, nil
+	}
+
+	columns, err := m.schemaProvider.GetColumns(schema, table)
+	m.columnsCache[cacheKey] = columns
 
 	if err != nil {
 		return nil, err
 	}
 
-	return fields, nil
+	return columns, nil
 }
 
-func getFieldsFromDb(db *sql.DB, schema string, table string) (map[int]string, error) {
-	rows, err := db.Query(
-		"SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
-		schema,
-		table,
-	)
+func (m *TableMap) getKeys(schema, table string) ([]Key, error) {
+	cacheKey := fmt.Sprintf("%s_%s", schema, table)
 
-	if err != nil {
-		q := newQueryError(err)
-		return nil, &q
+	if versions := m.schemaHistory[tableKey(schema, table)]; len(versions) > 0 {
+		return versions[len(versions)-1].Keys, nil
 	}
 
-	defer rows.Close()
-
-	fields := make(map[int]string)
-	i := 0
-
-	var columnName string
-	for rows.Next() {
-		err := rows.Scan(&columnName)
+	if cachedKeys, ok := m.keysCache[cacheKey]; ok {
+		return cachedKeys, nil
+	}
 
-		if err != nil {
-			q := newQueryError(err)
-			return nil, &q
-		}
+	keys, err := m.schemaProvider.GetKeys(schema, table)
+	m.keysCache[cacheKey] = keys
 
-		fields[i] = columnName
-		i++
+	if err != nil {
+		return nil, err
 	}
 
-	return fields, nil
+	return keys, nil
 }
@@ -43,6 +43,32 @@ func TestLookupTableMetadata(t *testing.T) {
 		}
 	})
 
+	t.Run("Column types", func(t *testing.T) {
+		tableMap := NewTableMap(db)
+		tableMap.Add(1, "test_db", "language")
+
+		tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+		if !tableMetadata.Columns[0].Unsigned || tableMetadata.Columns[1].Unsigned {
+			t.Fatalf("Wrong signedness in table metadata - got %v", tableMetadata.Columns)
+		}
+
+		if tableMetadata.Columns[0].Type != "tinyint(3) unsigned" {
+			t.Fatalf("Wrong column type in table metadata - got %s", tableMetadata.Columns[0].Type)
+		}
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		tableMap := NewTableMap(db)
+		tableMap.Add(1, "test_db", "departments")
+
+		tableMetadata, _ := tableMap.LookupTableMetadata(1)
+
+		if !reflect.DeepEqual(tableMetadata.PrimaryKey, []int{0}) || !reflect.DeepEqual(tableMetadata.UniqueKeys, [][]int{{1}}) {
+			t.Fatalf("Wrong keys in table metadata - got %v and %v", tableMetadata.PrimaryKey, tableMetadata.UniqueKeys)
+		}
+	})
+
 	t.Run("Not Found", func(t *testing.T) {
 		tableMap := NewTableMap(db)
 		_, ok := tableMap.LookupTableMetadata(999)
@@ -7,37 +7,110 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 	"zalora/binlog-parser/parser"
+	"zalora/binlog-parser/parser/conversion"
+	"zalora/binlog-parser/parser/kafka"
 )
 
 var prettyPrintJsonFlag = flag.Bool("prettyprint", false, "Pretty print json")
 var includeTablesFlag = flag.String("include_tables", "", "comma-separated list of tables to include")
 var includeSchemasFlag = flag.String("include_schemas", "", "comma-separated list of schemas to include")
+var startPositionFlag = flag.Uint("start_position", 0, "Start parsing at the event at this position (in the first binlog file)")
+var stopPositionFlag = flag.Uint("stop_position", 0, "Stop parsing at the first event at or after this position (in the last binlog file)")
+var startDatetimeFlag = flag.String("start_datetime", "", "Include only events at or after this time, e.g. \"2017-04-13 06:34:30\" (local time) or RFC3339")
+var stopDatetimeFlag = flag.String("stop_datetime", "", "Include only events before this time, e.g. \"2017-04-13 06:34:30\" (local time) or RFC3339")
+var includeGtidsFlag = flag.String("include_gtids", "", "Include only transactions in this GTID set, e.g. 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5 or 0-1-100")
+var excludeGtidsFlag = flag.String("exclude_gtids", "", "Exclude transactions in this GTID set")
+var transactionBoundariesFlag = flag.Bool("transaction_boundaries", false, "Write TransactionBegin and TransactionCommit messages around the row messages of each transaction")
+var columnTypesFlag = flag.Bool("column_types", false, "Include the type of each column in the row data")
+var rawEnumValuesFlag = flag.Bool("raw_enum_values", false, "Keep the index of ENUM values and the bitmask of SET values instead of their labels")
+var changedColumnsOnlyFlag = flag.Bool("changed_columns_only", false, "Reduce the rows of update messages to the key columns and the columns changed by the update")
+var datetimeTimeZoneFlag = flag.String("datetime_time_zone", "UTC", "Time zone of DATETIME values, e.g. Asia/Singapore or Local")
+var binaryEncodingFlag = flag.String("binary_encoding", "base64", "Encoding of the values of binary columns, base64 or hex")
+var outputFormatFlag = flag.String("output_format", "json", "Output format, json, debezium (JSON change events), sql (MySQL statements), csv, tsv or avro")
+var avroDirFlag = flag.String("avro_dir", ".", "Directory of the Avro object container files, or of the Avro schemas with -avro_single_object")
+var avroSingleObjectFlag = flag.Bool("avro_single_object", false, "Write Avro records to stdout in single object encoding instead of to object container files")
+var kafkaBrokersFlag = flag.String("kafka_brokers", "", "Comma-separated list of Kafka brokers (host:port) to publish row messages to instead of writing them to stdout")
+var kafkaTopicFlag = flag.String("kafka_topic", "{schema}.{table}", "Kafka topic of the row messages of a table, {schema} and {table} are replaced by its schema and name")
+var kafkaBatchSizeFlag = flag.Int("kafka_batch_size", 100, "Send messages to Kafka in batches of up to this many messages")
+var kafkaBatchTimeoutFlag = flag.Duration("kafka_batch_timeout", 100*time.Millisecond, "Send a batch of messages to Kafka at the latest this long after its first message")
+var kafkaRequiredAcksFlag = flag.Int("kafka_required_acks", -1, "Number of replicas which have to acknowledge a batch of messages, -1 for all in-sync replicas")
+var sqlDsnFlag = flag.String("sql_dsn", "", "Apply row messages to the database with this data source name instead of writing them to stdout")
+var sqlDriverFlag = flag.String("sql_driver", "mysql", "Database driver of -sql_dsn, mysql or postgres")
+var sqlTableFlag = flag.String("sql_table", "{table}", "Table the rows of a table are applied to, {schema} and {table} are replaced by its schema and name")
+var sqlConflictsFlag = flag.String("sql_conflicts", "error", "What to do with rows already there for inserts or not there for updates and deletes, error, ignore or replace")
+var flashbackFlag = flag.Bool("flashback", false, "Write MySQL statements undoing the row changes instead of the messages, last change first")
+var csvDirFlag = flag.String("csv_dir", ".", "Directory of the CSV or TSV files")
+var binlogIndexFlag = flag.Bool("binlog_index", false, "Argument is a binlog index file (e.g. mysql-bin.index), parse all binlog files listed in it")
+var schemaFileFlag = flag.String("schema_file", "", "Read table schemas from a mysqldump --no-data file or a JSON schema snapshot (.json) instead of DB_DSN")
+var streamFlag = flag.Bool("stream", false, "Stream binlog events from the server in DB_DSN, connecting as a replica")
+var serverIdFlag = flag.Uint("server_id", 0, "Replica server id used when streaming, must be unique among the replicas of the server")
+var startFileFlag = flag.String("start_file", "", "Binlog file on the server to start streaming from, at -start_position")
+var startGtidFlag = flag.String("start_gtid", "", "GTID set to start streaming from, instead of -start_file")
+var flavorFlag = flag.String("flavor", "mysql", "Server flavor when streaming, mysql or mariadb")
+var maxReconnectAttemptsFlag = flag.Int("max_reconnect_attempts", 0, "Give up streaming after this many failed reconnects (0 retries forever)")
 
 func main() {
 	flag.Usage = func() {
 		printUsage()
 	}
 
+	addHyphenatedFlags()
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	if *streamFlag && flag.NArg() != 0 || !*streamFlag && flag.NArg() != 1 {
 		printUsage()
 		os.Exit(1)
 	}
 
-	binlogFilename := flag.Arg(0)
-	dbDsn := os.Getenv("DB_DSN")
+	source := schemaSource{os.Getenv("DB_DSN"), *schemaFileFlag}
 
-	if dbDsn == "" {
+	if source.dbDsn == "" && (source.schemaFilename == "" || *streamFlag) {
 		fmt.Fprint(os.Stderr, "Please set env variable DB_DSN to a valid MySQL connection string")
 		os.Exit(1)
 	}
 
-	glog.V(1).Infof("Will parse file %s", binlogFilename)
+	startDatetime, stopDatetime, err := datetimeRangeFromArgs()
 
-	parseFunc := createBinlogParseFunc(dbDsn, consumerChainFromArgs())
-	err := parseFunc(binlogFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Got error: %s\n", err)
+		os.Exit(1)
+	}
+
+	parseOptions, err := parseOptionsFromArgs(stopDatetime)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Got error: %s\n", err)
+		os.Exit(1)
+	}
+
+	consumerChain, err := consumerChainFromArgs(startDatetime, stopDatetime)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Got error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *streamFlag {
+		err = streamFromArgs(source, consumerChain, parseOptions)
+	} else {
+		binlogFilename := flag.Arg(0)
+
+		glog.V(1).Infof("Will parse file %s", binlogFilename)
+
+		parseFunc := createBinlogParseFunc(
+			source,
+			consumerChain,
+			parseOptions,
+			*binlogIndexFlag,
+		)
+		err = parseFunc(binlogFilename)
+	}
+
+	if closeErr := consumerChain.Close(); err == nil {
+		err = closeErr
+	}
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Got error: %s\n", err)
@@ -45,11 +118,107 @@ func main() {
 	}
 }
 
-func consumerChainFromArgs() parser.ConsumerChain {
+func streamFromArgs(source schemaSource, consumerChain parser.ConsumerChain, parseOptions parser.ParseOptions) error {
+	if *serverIdFlag == 0 {
+		return fmt.Errorf("Streaming requires a -server_id greater than 0")
+	}
+
+	if *stopPositionFlag != 0 {
+		return fmt.Errorf("-stop_position is not supported when streaming")
+	}
+
+	streamOptions := parser.StreamOptions{
+		ServerId:             uint32(*serverIdFlag),
+		Flavor:               *flavorFlag,
+		StartFile:            *startFileFlag,
+		StartGtidSet:         *startGtidFlag,
+		MaxReconnectAttempts: *maxReconnectAttemptsFlag,
+	}
+
+	glog.V(1).Infof("Will stream from file %s, GTID set %s", streamOptions.StartFile, streamOptions.StartGtidSet)
+
+	return streamBinlog(source, consumerChain, streamOptions, parseOptions)
+}
+
+func consumerChainFromArgs(startDatetime, stopDatetime time.Time) (parser.ConsumerChain, error) {
 	chain := parser.NewConsumerChain()
 
-	chain.CollectAsJson(os.Stdout, *prettyPrintJsonFlag)
-	glog.V(1).Infof("Pretty print JSON %s", *prettyPrintJsonFlag)
+	switch {
+	case *flashbackFlag && (*sqlDsnFlag != "" || *kafkaBrokersFlag != ""):
+		return chain, fmt.Errorf("Flashback statements can only be written to stdout")
+	case *flashbackFlag && *streamFlag:
+		return chain, fmt.Errorf("Flashback statements are written once all messages are parsed, which never happens when streaming")
+	case *flashbackFlag && *changedColumnsOnlyFlag:
+		return chain, fmt.Errorf("Flashback statements need the whole rows before and after the changes, not only the changed columns")
+	case *flashbackFlag:
+		chain.CollectAsFlashback(os.Stdout, *binaryEncodingFlag)
+		glog.V(1).Info("Writing flashback statements")
+	case *sqlDsnFlag != "" && *kafkaBrokersFlag != "":
+		return chain, fmt.Errorf("Row messages can't be both applied to a database and published to Kafka")
+	case *sqlDsnFlag != "" && *changedColumnsOnlyFlag:
+		return chain, fmt.Errorf("Rows of tables without key can't be found to apply updates to with -changed_columns_only")
+	case *sqlDsnFlag != "":
+		if *sqlDriverFlag != parser.SQL_DIALECT_MYSQL && *sqlDriverFlag != parser.SQL_DIALECT_POSTGRES {
+			return chain, fmt.Errorf("Invalid database driver %s, must be mysql or postgres", *sqlDriverFlag)
+		}
+
+		if *sqlConflictsFlag != parser.SQL_CONFLICTS_ERROR && *sqlConflictsFlag != parser.SQL_CONFLICTS_IGNORE && *sqlConflictsFlag != parser.SQL_CONFLICTS_REPLACE {
+			return chain, fmt.Errorf("Invalid conflict handling %s, must be error, ignore or replace", *sqlConflictsFlag)
+		}
+
+		db, err := parser.OpenSqlTarget(*sqlDriverFlag, *sqlDsnFlag)
+
+		if err != nil {
+			return chain, fmt.Errorf("Failed to connect to target database: %s", err)
+		}
+
+		chain.CollectAsSql(db, parser.SqlReplayOptions{
+			Dialect:        *sqlDriverFlag,
+			TableTemplate:  *sqlTableFlag,
+			Conflicts:      *sqlConflictsFlag,
+			BinaryEncoding: *binaryEncodingFlag,
+		})
+		glog.V(1).Infof("Applying row messages to %s tables %s, conflicts %s", *sqlDriverFlag, *sqlTableFlag, *sqlConflictsFlag)
+	case *kafkaBrokersFlag != "" && *outputFormatFlag == "avro":
+		return chain, fmt.Errorf("Avro output can't be published to Kafka")
+	case *kafkaBrokersFlag != "":
+		if *outputFormatFlag != "json" && *outputFormatFlag != "debezium" {
+			return chain, fmt.Errorf("Invalid output format %s, must be json or debezium", *outputFormatFlag)
+		}
+
+		producer := kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:      commaSeparatedListToArray(*kafkaBrokersFlag),
+			RequiredAcks: int16(*kafkaRequiredAcksFlag),
+			BatchSize:    *kafkaBatchSizeFlag,
+			BatchTimeout: *kafkaBatchTimeoutFlag,
+			Retries:      3,
+		})
+
+		chain.CollectAsKafka(producer, *kafkaTopicFlag, *outputFormatFlag == "debezium")
+		glog.V(1).Infof("Publishing %s messages to Kafka topic %s", *outputFormatFlag, *kafkaTopicFlag)
+	case *outputFormatFlag == "json":
+		chain.CollectAsJson(os.Stdout, *prettyPrintJsonFlag)
+		glog.V(1).Infof("Pretty print JSON %s", *prettyPrintJsonFlag)
+	case *outputFormatFlag == "debezium":
+		chain.CollectAsDebezium(os.Stdout, *prettyPrintJsonFlag)
+		glog.V(1).Infof("Pretty print Debezium JSON %t", *prettyPrintJsonFlag)
+	case *outputFormatFlag == "sql":
+		chain.CollectAsSqlText(os.Stdout, *binaryEncodingFlag)
+		glog.V(1).Info("Writing SQL statements")
+	case *outputFormatFlag == "csv", *outputFormatFlag == "tsv":
+		chain.CollectAsCsv(*csvDirFlag, *outputFormatFlag == "tsv")
+		glog.V(1).Infof("Writing %s files to %s", *outputFormatFlag, *csvDirFlag)
+	case *outputFormatFlag == "avro":
+		chain.CollectAsAvro(os.Stdout, *avroDirFlag, *avroSingleObjectFlag)
+		glog.V(1).Infof("Writing Avro to %s, single object encoding %t", *avroDirFlag, *avroSingleObjectFlag)
+	default:
+		return chain, fmt.Errorf("Invalid output format %s, must be json, debezium, sql, csv, tsv or avro", *outputFormatFlag)
+	}
+
+	if *transactionBoundariesFlag {
+		chain.IncludeTransactionBoundaries()
+		glog.V(1).Info("Including transaction boundaries")
+	}
 
 	if *includeTablesFlag != "" {
 		includeTables := commaSeparatedListToArray(*includeTablesFlag)
@@ -65,27 +234,146 @@ func consumerChainFromArgs() parser.ConsumerChain {
 		glog.V(1).Infof("Including schemas %v", includeSchemas)
 	}
 
-	return chain
+	if !startDatetime.IsZero() || !stopDatetime.IsZero() {
+		chain.IncludeTimeRange(startDatetime, stopDatetime)
+		glog.V(1).Infof("Including events from %s to %s", startDatetime, stopDatetime)
+	}
+
+	if *includeGtidsFlag != "" {
+		includeGtids, err := parser.ParseGtidSet(*includeGtidsFlag)
+
+		if err != nil {
+			return chain, fmt.Errorf("Invalid GTID set %s: %s", *includeGtidsFlag, err)
+		}
+
+		chain.IncludeGtids(includeGtids)
+		glog.V(1).Infof("Including GTIDs %s", includeGtids)
+	}
+
+	if *excludeGtidsFlag != "" {
+		excludeGtids, err := parser.ParseGtidSet(*excludeGtidsFlag)
+
+		if err != nil {
+			return chain, fmt.Errorf("Invalid GTID set %s: %s", *excludeGtidsFlag, err)
+		}
+
+		chain.ExcludeGtids(excludeGtids)
+		glog.V(1).Infof("Excluding GTIDs %s", excludeGtids)
+	}
+
+	return chain, nil
+}
+
+func parseOptionsFromArgs(stopDatetime time.Time) (parser.ParseOptions, error) {
+	if *binaryEncodingFlag != conversion.BINARY_ENCODING_BASE64 && *binaryEncodingFlag != conversion.BINARY_ENCODING_HEX {
+		return parser.ParseOptions{}, fmt.Errorf("Invalid binary encoding %s, must be base64 or hex", *binaryEncodingFlag)
+	}
+
+	datetimeLocation, err := time.LoadLocation(*datetimeTimeZoneFlag)
+
+	if err != nil {
+		return parser.ParseOptions{}, fmt.Errorf("Invalid time zone %s: %s", *datetimeTimeZoneFlag, err)
+	}
+
+	options := parser.ParseOptions{
+		StartPosition:      uint32(*startPositionFlag),
+		StopPosition:       uint32(*stopPositionFlag),
+		StopDatetime:       stopDatetime,
+		ColumnTypes:        *columnTypesFlag || *outputFormatFlag != "json" && *outputFormatFlag != "debezium" || *sqlDsnFlag != "" || *flashbackFlag,
+		BinaryEncoding:     *binaryEncodingFlag,
+		RawEnumValues:      *rawEnumValuesFlag,
+		DatetimeLocation:   datetimeLocation,
+		ChangedColumnsOnly: *changedColumnsOnlyFlag,
+	}
+
+	glog.V(1).Infof("Parsing from position %d to position %d", options.StartPosition, options.StopPosition)
+
+	return options, nil
+}
+
+func datetimeRangeFromArgs() (time.Time, time.Time, error) {
+	startDatetime, err := datetimeFromArg(*startDatetimeFlag)
+
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	stopDatetime, err := datetimeFromArg(*stopDatetimeFlag)
+
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return startDatetime, stopDatetime, nil
+}
+
+func datetimeFromArg(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+
+	if datetime, err := time.ParseInLocation("2006-01-02 15:04:05", str, time.Local); err == nil {
+		return datetime, nil
+	}
+
+	datetime, err := time.Parse(time.RFC3339, str)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid datetime %s, expected format is \"2006-01-02 15:04:05\" or RFC3339", str)
+	}
+
+	return datetime, nil
 }
 
 func printUsage() {
 	binName := path.Base(os.Args[0])
 
 	usage := "Parse a binlog file, dump JSON to stdout. Includes options to filter by schema and table.\n" +
-		"Reads from information_schema database to find out the field names for a row event.\n\n" +
-		"Usage:\t%s [options ...] binlog\n\n" +
+		"Reads from information_schema database or a schema file to find out the field names for a row event.\n\n" +
+		"Usage:\t%s [options ...] binlog\n" +
+		"\t%s [options ...] -binlog_index binlog-index\n" +
+		"\t%s [options ...] -stream -server_id id\n\n" +
 		"Options are:\n\n"
 
-	fmt.Fprintf(os.Stderr, usage, binName)
+	fmt.Fprintf(os.Stderr, usage, binName, binName, binName)
 
-	flag.PrintDefaults()
+	printFlagDefaults()
 
 	envVars := "\nRequired environment variables:\n\n" +
-		"DB_DSN\t Database connection string, needs read access to information_schema\n"
+		"DB_DSN\t Database connection string, needs read access to information_schema (not needed with -schema_file)\n" +
+		"\t (and the REPLICATION SLAVE privilege when streaming)\n"
 
 	fmt.Fprint(os.Stderr, envVars)
 }
 
+var hyphenatedFlags = make(map[string]bool)
+
+// Every flag can also be spelt with hyphens instead of underscores, e.g. -start-position
+// for -start_position
+func addHyphenatedFlags() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if name := strings.Replace(f.Name, "_", "-", -1); name != f.Name {
+			flag.Var(f.Value, name, f.Usage)
+			hyphenatedFlags[name] = true
+		}
+	})
+}
+
+// Prints the defaults of the flags without their hyphenated spelling
+func printFlagDefaults() {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hyphenatedFlags[f.Name] {
+			flags.Var(f.Value, f.Name, f.Usage)
+			flags.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+
+	flags.PrintDefaults()
+}
+
 func commaSeparatedListToArray(str string) []string {
 	var arr []string
 
@@ -2,22 +2,62 @@ package main
 
 import (
 	"github.com/golang/glog"
+	"path/filepath"
+	"strings"
 	"zalora/binlog-parser/database"
 	"zalora/binlog-parser/parser"
 )
 
 type binlogParseFunc func(string) error
 
-func createBinlogParseFunc(dbDsn string, consumerChain parser.ConsumerChain) binlogParseFunc {
+// Field names of tables are read from a schema file if given, from DB_DSN otherwise
+type schemaSource struct {
+	dbDsn          string
+	schemaFilename string
+}
+
+func createBinlogParseFunc(source schemaSource, consumerChain parser.ConsumerChain, options parser.ParseOptions, binlogIndex bool) binlogParseFunc {
 	return func(binlogFilename string) error {
-		return parseBinlogFile(binlogFilename, dbDsn, consumerChain)
+		if binlogIndex {
+			return parseBinlogIndexFile(binlogFilename, source, consumerChain, options)
+		}
+
+		return parseBinlogFile(binlogFilename, source, consumerChain, options)
 	}
 }
 
-func parseBinlogFile(binlogFilename, dbDsn string, consumerChain parser.ConsumerChain) error {
+func parseBinlogFile(binlogFilename string, source schemaSource, consumerChain parser.ConsumerChain, options parser.ParseOptions) error {
 	glog.V(2).Infof("Parsing binlog file %s", binlogFilename)
 
-	db, err := database.GetDatabaseInstance(dbDsn)
+	return withTableMap(source, func(tableMap database.TableMap) error {
+		glog.V(2).Info("About to parse file ...")
+
+		return parser.ParseBinlog(binlogFilename, tableMap, consumerChain, options)
+	})
+}
+
+func parseBinlogIndexFile(indexFilename string, source schemaSource, consumerChain parser.ConsumerChain, options parser.ParseOptions) error {
+	glog.V(2).Infof("Parsing binlog files listed in index file %s", indexFilename)
+
+	return withTableMap(source, func(tableMap database.TableMap) error {
+		glog.V(2).Info("About to parse files ...")
+
+		return parser.ParseBinlogIndex(indexFilename, tableMap, consumerChain, options)
+	})
+}
+
+func withTableMap(source schemaSource, parseFunc func(database.TableMap) error) error {
+	if source.schemaFilename != "" {
+		schemaProvider, err := schemaProviderFromFile(source.schemaFilename)
+
+		if err != nil {
+			return err
+		}
+
+		return parseFunc(database.NewTableMapFromSchemaProvider(schemaProvider))
+	}
+
+	db, err := database.GetDatabaseInstance(source.dbDsn)
 
 	if err != nil {
 		return err
@@ -25,9 +65,15 @@ func parseBinlogFile(binlogFilename, dbDsn string, consumerChain parser.Consumer
 
 	defer db.Close()
 
-	tableMap := database.NewTableMap(db)
+	return parseFunc(database.NewTableMap(db))
+}
+
+func schemaProviderFromFile(schemaFilename string) (database.SchemaProvider, error) {
+	glog.V(2).Infof("Reading schema from file %s", schemaFilename)
 
-	glog.V(2).Info("About to parse file ...")
+	if strings.ToLower(filepath.Ext(schemaFilename)) == ".json" {
+		return database.NewJsonSchemaProvider(schemaFilename)
+	}
 
-	return parser.ParseBinlog(binlogFilename, tableMap, consumerChain)
+	return database.NewMysqldumpSchemaProvider(schemaFilename)
 }
@@ -9,7 +9,6 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 	"testing"
 	"zalora/binlog-parser/parser"
 )
@@ -28,7 +27,7 @@ func TestParseBinlogFile(t *testing.T) {
 		tmpfile, _ := ioutil.TempFile("", "test")
 		defer os.RemoveAll(tmpfile.Name())
 
-		err := parseBinlogFile("/not/there", os.Getenv("TEST_DB_DSN"), createConsumerChain(tmpfile))
+		err := parseBinlogFile("/not/there", schemaSource{dbDsn: os.Getenv("TEST_DB_DSN")}, createConsumerChain(tmpfile), parser.ParseOptions{})
 
 		if err == nil {
 			t.Fatal("Expected error when parsing non-existing file")
@@ -69,7 +68,7 @@ func TestParseBinlogFile(t *testing.T) {
 				chain.IncludeSchemas(tc.includeSchemas...)
 			}
 
-			err := parseBinlogFile(binlogFilename, os.Getenv("TEST_DB_DSN"), chain)
+			err := parseBinlogFile(binlogFilename, schemaSource{dbDsn: os.Getenv("TEST_DB_DSN")}, chain, parser.ParseOptions{})
 
 			if err != nil {
 				t.Fatal(fmt.Sprintf("Expected no error when successfully parsing file %s", err))
@@ -80,24 +79,38 @@ func TestParseBinlogFile(t *testing.T) {
 	}
 }
 
-func assertJson(t *testing.T, buffer bytes.Buffer, expectedJsonFile string) {
-	expectedJson, err := ioutil.ReadFile(expectedJsonFile)
+func TestParseBinlogIndexFile(t *testing.T) {
+	dataDir := os.Getenv("DATA_DIR")
 
-	if err != nil {
-		t.Fatal(fmt.Sprintf("Failed to open expected JSON file: %s", err))
-	}
+	t.Run("index file not found", func(t *testing.T) {
+		chain := parser.NewConsumerChain()
+
+		err := parseBinlogIndexFile("/not/there", schemaSource{dbDsn: os.Getenv("TEST_DB_DSN")}, chain, parser.ParseOptions{})
 
-	expected := strings.TrimSpace(string(expectedJson))
-	actual := strings.TrimSpace(buffer.String())
+		if err == nil {
+			t.Fatal("Expected error when parsing non-existing index file")
+		}
+	})
 
-	if expected != actual {
-		errorMessage := fmt.Sprintf(
-			"JSON file %s does not match\nExpected:\n==========\n%s\n==========\nActual generated:\n%s\n==========",
-			expectedJsonFile,
-			expected,
-			actual,
-		)
+	t.Run("Parse binlog files listed in index", func(t *testing.T) {
+		tmpfile, _ := ioutil.TempFile("", "mysql-bin.index")
+		defer os.Remove(tmpfile.Name())
 
-		t.Fatal(errorMessage)
-	}
+		fmt.Fprintln(tmpfile, filepath.Join(dataDir, "fixtures/mysql-bin.01"))
+		fmt.Fprintln(tmpfile, filepath.Join(dataDir, "fixtures/mysql-bin.02"))
+		tmpfile.Close()
+
+		var buffer bytes.Buffer
+
+		chain := parser.NewConsumerChain()
+		chain.CollectAsJson(&buffer, true)
+
+		err := parseBinlogIndexFile(tmpfile.Name(), schemaSource{dbDsn: os.Getenv("TEST_DB_DSN")}, chain, parser.ParseOptions{})
+
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Expected no error when successfully parsing index file %s", err))
+		}
+
+		assertJson(t, buffer, filepath.Join(dataDir, "fixtures/01.json"), filepath.Join(dataDir, "fixtures/02.json"))
+	})
 }
@@ -0,0 +1,97 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"zalora/binlog-parser/parser"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestParseBinlogFileWithSchemaFile(t *testing.T) {
+	dataDir := os.Getenv("DATA_DIR")
+
+	testCases := []struct {
+		schemaFilename   string
+		fixtureFilename  string
+		expectedJsonFile string
+	}{
+		{"fixtures/test_db.sql", "fixtures/mysql-bin.01", "fixtures/01.json"},
+		{"fixtures/test_db.sql", "fixtures/mysql-bin.02", "fixtures/02.json"}, // table created in binlog
+		{"fixtures/test_db.sql", "fixtures/mysql-bin.03", "fixtures/03.json"},
+		{"fixtures/test_db.sql", "fixtures/mysql-bin.06", "fixtures/06.json"}, // table created and altered in binlog
+		{"fixtures/test_db.sql", "fixtures/mysql-bin.07", "fixtures/07.json"}, // mariadb format with GTIDs
+		{"fixtures/test_db_schema.json", "fixtures/mysql-bin.01", "fixtures/01.json"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("Parse binlog %s with schema %s", tc.fixtureFilename, tc.schemaFilename), func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			chain := parser.NewConsumerChain()
+			chain.CollectAsJson(&buffer, true)
+
+			source := schemaSource{schemaFilename: filepath.Join(dataDir, tc.schemaFilename)}
+
+			err := parseBinlogFile(filepath.Join(dataDir, tc.fixtureFilename), source, chain, parser.ParseOptions{})
+
+			if err != nil {
+				t.Fatal(fmt.Sprintf("Expected no error when successfully parsing file %s", err))
+			}
+
+			assertJson(t, buffer, filepath.Join(dataDir, tc.expectedJsonFile))
+		})
+	}
+
+	t.Run("Table altered after the start position", func(t *testing.T) {
+		var buffer bytes.Buffer
+
+		chain := parser.NewConsumerChain()
+		chain.CollectAsJson(&buffer, false)
+
+		source := schemaSource{schemaFilename: filepath.Join(dataDir, "fixtures/test_db.sql")}
+
+		// starts after the table was created, the schema file has the column added later
+		err := parseBinlogFile(filepath.Join(dataDir, "fixtures/mysql-bin.06"), source, chain, parser.ParseOptions{StartPosition: 589})
+
+		if err != nil {
+			t.Fatalf("Expected no error when parsing file, got %s", err)
+		}
+
+		var rows []messages.MessageRowData
+
+		for decoder := json.NewDecoder(&buffer); decoder.More(); {
+			var message struct {
+				Type messages.MessageType
+				Data messages.MessageRowData
+			}
+
+			if err := decoder.Decode(&message); err != nil {
+				t.Fatal(err)
+			}
+
+			if message.Type == messages.MESSAGE_TYPE_INSERT {
+				rows = append(rows, message.Data)
+			}
+		}
+
+		if len(rows) != 2 || len(rows[0].Row) != 3 || rows[0].MappingNotice != "" || rows[1].Row["some_field"] != "some value" {
+			t.Fatalf("Wrong rows before and after the ALTER TABLE - got %v", rows)
+		}
+	})
+
+	t.Run("Schema file not found", func(t *testing.T) {
+		source := schemaSource{schemaFilename: "/not/there.sql"}
+
+		err := parseBinlogFile(filepath.Join(dataDir, "fixtures/mysql-bin.01"), source, parser.NewConsumerChain(), parser.ParseOptions{})
+
+		if err == nil {
+			t.Fatal("Expected error when schema file doesn't exist")
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package avro
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// Blocks are written once their records reach this size
+const containerBlockSize = 64 * 1024
+
+// Writes records of a schema to an object container file, see
+// https://avro.apache.org/docs/1.8.2/spec.html#Object+Container+Files. Records are
+// buffered into blocks, the file is only complete once the writer is flushed.
+type ContainerWriter struct {
+	stream  io.Writer
+	sync    []byte
+	records []byte
+	count   int64
+}
+
+func NewContainerWriter(stream io.Writer, schema *Schema) (*ContainerWriter, error) {
+	sync := make([]byte, 16)
+
+	if _, err := rand.Read(sync); err != nil {
+		return nil, err
+	}
+
+	header := []byte("Obj\x01")
+	header = appendLong(header, 2)
+	header = appendString(header, "avro.schema")
+	header = appendString(header, schema.Json)
+	header = appendString(header, "avro.codec")
+	header = appendString(header, "null")
+	header = appendLong(header, 0)
+	header = append(header, sync...)
+
+	if _, err := stream.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &ContainerWriter{stream: stream, sync: sync}, nil
+}
+
+func (w *ContainerWriter) Write(record []byte) error {
+	w.records = append(w.records, record...)
+	w.count++
+
+	if len(w.records) < containerBlockSize {
+		return nil
+	}
+
+	return w.Flush()
+}
+
+// Writes the buffered records as a block, if there are any
+func (w *ContainerWriter) Flush() error {
+	if w.count == 0 {
+		return nil
+	}
+
+	block := appendLong(nil, w.count)
+	block = appendBytes(block, w.records)
+	block = append(block, w.sync...)
+
+	w.records, w.count = w.records[:0], 0
+
+	_, err := w.stream.Write(block)
+
+	return err
+}
+
+// A record in single object encoding, prefixed with the fingerprint of its schema, see
+// https://avro.apache.org/docs/1.8.2/spec.html#single_object_encoding
+func SingleObject(schema *Schema, record []byte) []byte {
+	object := make([]byte, 10, 10+len(record))
+	object[0], object[1] = 0xc3, 0x01
+	binary.LittleEndian.PutUint64(object[2:], schema.Fingerprint)
+
+	return append(object, record...)
+}
@@ -0,0 +1,58 @@
+// +build unit
+
+package avro
+
+import (
+	"bytes"
+	"testing"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestContainerWriter(t *testing.T) {
+	schema := NewSchema("test_db", "t", map[string]messages.MessageColumnType{"id": {Type: "INT"}})
+
+	var buf bytes.Buffer
+
+	writer, err := NewContainerWriter(&buf, schema)
+
+	if err != nil {
+		t.Fatalf("Expected no error when writing header, got %s", err)
+	}
+
+	header := buf.Len()
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("Obj\x01\x04\x16avro.schema")) {
+		t.Fatalf("Wrong header %q", buf.Bytes())
+	}
+
+	for _, record := range [][]byte{{1, 2, 3}, {4}} {
+		if err := writer.Write(record); err != nil {
+			t.Fatalf("Expected no error when writing record, got %s", err)
+		}
+	}
+
+	if buf.Len() != header {
+		t.Fatalf("Expected records to be buffered until flushed, got %v", buf.Bytes()[header:])
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Expected no error when flushing block, got %s", err)
+	}
+
+	expectedBlock := append([]byte{4, 8, 1, 2, 3, 4}, buf.Bytes()[header-16:header]...)
+
+	if block := buf.Bytes()[header:]; !bytes.Equal(block, expectedBlock) {
+		t.Fatalf("Wrong block - expected %v, got %v", expectedBlock, block)
+	}
+
+	if err := writer.Flush(); err != nil || buf.Len() != header+len(expectedBlock) {
+		t.Fatalf("Expected no empty block to be written (%v)", err)
+	}
+
+	expectedObject := []byte{0xc3, 0x01}
+	expectedObject = append(expectedObject, byte(schema.Fingerprint), byte(schema.Fingerprint>>8))
+
+	if object := SingleObject(schema, []byte{1, 2, 3}); !bytes.HasPrefix(object, expectedObject) || len(object) != 13 {
+		t.Fatalf("Wrong single object %v", object)
+	}
+}
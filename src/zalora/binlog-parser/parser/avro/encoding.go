@@ -0,0 +1,248 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Encodes a row message as a record of the schema of its table. Columns missing from the
+// rows, like the ones not logged, are encoded as null.
+func (s *Schema) Encode(message messages.Message) ([]byte, error) {
+	var before, after *messages.MessageRowData
+	var key messages.MessageRow
+	var changes []string
+
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		key, after = m.Key, &m.Data
+	case messages.UpdateMessage:
+		key, changes, before, after = m.Key, m.Changes, &m.OldData, &m.NewData
+	case messages.DeleteMessage:
+		key, before = m.Key, &m.Data
+	default:
+		return nil, fmt.Errorf("Can't encode %s message as Avro record", message.GetType())
+	}
+
+	header := message.GetHeader()
+
+	buf := appendString(nil, string(message.GetType()))
+	buf = appendString(buf, header.BinlogMessageTime)
+	buf = appendLong(buf, int64(header.BinlogPosition))
+	buf = appendLong(buf, int64(header.XId))
+
+	if header.Gtid == "" {
+		buf = appendLong(buf, 0)
+	} else {
+		buf = appendString(appendLong(buf, 1), header.Gtid)
+	}
+
+	var keyColumns []string
+
+	for column := range key {
+		keyColumns = append(keyColumns, column)
+	}
+
+	sort.Strings(keyColumns)
+
+	buf = appendStrings(buf, keyColumns)
+	buf = appendStrings(buf, changes)
+
+	for _, row := range []*messages.MessageRowData{before, after} {
+		if row == nil {
+			buf = appendLong(buf, 0)
+			continue
+		}
+
+		buf = appendLong(buf, 1)
+
+		for _, f := range s.fields {
+			var err error
+
+			buf, err = appendValue(buf, f, row.Row[f.column])
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+// Values of nullable fields are a union of null and the type of the field
+func appendValue(buf []byte, f field, value interface{}) ([]byte, error) {
+	if value == nil {
+		return appendLong(buf, 0), nil
+	}
+
+	buf = appendLong(buf, 1)
+
+	var err error
+
+	switch {
+	case f.logicalType == "decimal":
+		var unscaled []byte
+
+		if unscaled, err = decimalBytes(value, f.scale); err == nil {
+			return appendBytes(buf, unscaled), nil
+		}
+	case f.logicalType == "date":
+		var t time.Time
+
+		if t, err = time.Parse("2006-01-02", stringValue(value)); err == nil {
+			return appendLong(buf, t.Unix()/86400), nil
+		}
+	case f.logicalType == "timestamp-micros":
+		var t time.Time
+
+		if t, err = time.Parse(time.RFC3339Nano, stringValue(value)); err == nil {
+			return appendLong(buf, t.Unix()*1000000+int64(t.Nanosecond()/1000)), nil
+		}
+	case f.primitive == "int", f.primitive == "long":
+		var i int64
+
+		if i, err = longValue(value); err == nil {
+			return appendLong(buf, i), nil
+		}
+	case f.primitive == "float", f.primitive == "double":
+		v := reflect.ValueOf(value)
+
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			err = fmt.Errorf("not a floating point number")
+		} else if f.primitive == "float" {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v.Float())))
+
+			return append(buf, b...), nil
+		} else {
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v.Float()))
+
+			return append(buf, b...), nil
+		}
+	default:
+		return appendString(buf, stringValue(value)), nil
+	}
+
+	return nil, fmt.Errorf("Can't encode value %v of column %s as Avro %s: %s", value, f.column, f.primitive, err)
+}
+
+// Longs and ints are encoded as zig-zag varints
+func appendLong(buf []byte, i int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+
+	return append(buf, b[:binary.PutUvarint(b, uint64(i<<1)^uint64(i>>63))]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	return append(appendLong(buf, int64(len(b))), b...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendLong(buf, int64(len(s))), s...)
+}
+
+// A nullable array of strings, nil is encoded as null. Arrays are encoded as a block of
+// items ended by an empty block.
+func appendStrings(buf []byte, items []string) []byte {
+	if items == nil {
+		return appendLong(buf, 0)
+	}
+
+	buf = appendLong(buf, 1)
+
+	if len(items) > 0 {
+		buf = appendLong(buf, int64(len(items)))
+
+		for _, item := range items {
+			buf = appendString(buf, item)
+		}
+	}
+
+	return appendLong(buf, 0)
+}
+
+func longValue(value interface{}) (int64, error) {
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("out of range")
+		}
+
+		return int64(v.Uint()), nil
+	}
+
+	return strconv.ParseInt(stringValue(value), 10, 64)
+}
+
+// SET values are written like MySQL does, as a comma-separated list of their labels
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.RawMessage:
+		return string(v)
+	case []byte:
+		return string(v)
+	case []string:
+		return strings.Join(v, ",")
+	}
+
+	return fmt.Sprint(value)
+}
+
+// The unscaled value of a decimal as a big-endian two's complement integer, in as few
+// bytes as possible
+func decimalBytes(value interface{}, scale int) ([]byte, error) {
+	text := stringValue(value)
+	digits := text
+
+	if i := strings.Index(text, "."); i >= 0 {
+		fraction := text[i+1:]
+
+		if len(fraction) > scale {
+			return nil, fmt.Errorf("more than %d fractional digits", scale)
+		}
+
+		digits = text[:i] + fraction + strings.Repeat("0", scale-len(fraction))
+	} else {
+		digits += strings.Repeat("0", scale)
+	}
+
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+
+	if !ok {
+		return nil, fmt.Errorf("not a decimal")
+	}
+
+	if unscaled.Sign() >= 0 {
+		b := unscaled.Bytes()
+
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+
+		return b, nil
+	}
+
+	magnitude := new(big.Int).Sub(new(big.Int).Neg(unscaled), big.NewInt(1))
+	size := magnitude.BitLen()/8 + 1
+	complement := new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), uint(8*size)), unscaled)
+
+	b := complement.Bytes()
+
+	return append(make([]byte, size-len(b)), b...), nil
+}
@@ -0,0 +1,73 @@
+// +build unit
+
+package avro
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestEncode(t *testing.T) {
+	columns := map[string]messages.MessageColumnType{"id": {Type: "INT"}, "name": {Type: "VARCHAR"}}
+	schema := NewSchema("test_db", "t", columns)
+	header := messages.NewMessageHeader("test_db", "t", time.Unix(0, 0), 4, 1, "")
+
+	insert := messages.NewInsertMessage(header, messages.MessageRow{"id": 7}, messages.MessageRowData{Row: messages.MessageRow{"id": int32(7), "name": nil}, Columns: columns})
+	expected := []byte{12, 'I', 'n', 's', 'e', 'r', 't', 40}
+	expected = append(expected, "1970-01-01T00:00:00Z"...)
+	expected = append(expected,
+		8, 2, // position, XId
+		0,                    // no GTID
+		2, 2, 4, 'i', 'd', 0, // key columns
+		0,        // no changes
+		0,        // no row before
+		2, 2, 14, // id
+		0, // name
+	)
+
+	if record, err := schema.Encode(insert); err != nil || !bytes.Equal(record, expected) {
+		t.Fatalf("Wrong record - expected %v, got %v (%v)", expected, record, err)
+	}
+
+	if _, err := schema.Encode(messages.NewQueryMessage(header, "BEGIN")); err == nil {
+		t.Fatal("Expected error when encoding query message")
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		column   messages.MessageColumnType
+		value    interface{}
+		expected []byte
+	}{
+		{"Negative int", messages.MessageColumnType{Type: "INT"}, int32(-65), []byte{2, 0x81, 0x01}},
+		{"Unsigned int", messages.MessageColumnType{Type: "INT", Unsigned: true}, uint32(4294967295), []byte{2, 0xfe, 0xff, 0xff, 0xff, 0x1f}},
+		{"Unsigned bigint", messages.MessageColumnType{Type: "BIGINT", Unsigned: true}, uint64(18446744073709551615), []byte{2, 18, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"Decimal", messages.MessageColumnType{Type: "DECIMAL", Precision: 5, Scale: 2}, "1.28", []byte{2, 4, 0, 128}},
+		{"Negative decimal", messages.MessageColumnType{Type: "DECIMAL", Precision: 5, Scale: 2}, "-1.29", []byte{2, 4, 0xff, 0x7f}},
+		{"Decimal without fraction", messages.MessageColumnType{Type: "DECIMAL", Precision: 5, Scale: 2}, "-1", []byte{2, 2, 0x9c}},
+		{"Float", messages.MessageColumnType{Type: "FLOAT"}, float32(1.5), []byte{2, 0, 0, 0xc0, 0x3f}},
+		{"Double", messages.MessageColumnType{Type: "DOUBLE"}, float64(1.5), []byte{2, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+		{"Date", messages.MessageColumnType{Type: "DATE"}, "1969-12-31", []byte{2, 1}},
+		{"Datetime", messages.MessageColumnType{Type: "DATETIME", Precision: 3}, "1970-01-01T08:00:00.001+08:00", []byte{2, 0xd0, 0x0f}},
+		{"Set", messages.MessageColumnType{Type: "SET"}, []string{"a", "b"}, []byte{2, 6, 'a', ',', 'b'}},
+		{"Null", messages.MessageColumnType{Type: "INT"}, nil, []byte{0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := appendValue(nil, columnField(tc.column), tc.value)
+
+			if err != nil || !bytes.Equal(encoded, tc.expected) {
+				t.Fatalf("Wrong encoded value - expected %v, got %v (%v)", tc.expected, encoded, err)
+			}
+		})
+	}
+
+	if _, err := appendValue(nil, columnField(messages.MessageColumnType{Type: "DECIMAL", Precision: 5, Scale: 2}), "1.234"); err == nil {
+		t.Fatal("Expected error when encoding decimal with more fractional digits than its scale")
+	}
+}
@@ -0,0 +1,232 @@
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// The record schema of the row messages of a table, see the Avro specification at
+// https://avro.apache.org/docs/1.8.2/spec.html. Each record holds the row before and
+// after the change as records of the columns of the table, all of them nullable.
+type Schema struct {
+	Name        string // full name of the record
+	Json        string
+	Canonical   string // Parsing Canonical Form
+	Fingerprint uint64 // CRC-64-AVRO fingerprint of the Parsing Canonical Form
+	fields      []field
+}
+
+// A column of the row record. The column is the name in the row, the name the one in the
+// schema, which only allows letters, digits and underscores.
+type field struct {
+	name        string
+	column      string
+	primitive   string
+	logicalType string
+	precision   int
+	scale       int
+}
+
+var invalidNameCharacters = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Columns are in table order, so that a column added to the table is appended to the
+// record, or sorted by name without their position. Names which are the same once
+// invalid characters are replaced get a numeric suffix.
+func NewSchema(schema, table string, columns map[string]messages.MessageColumnType) *Schema {
+	s := &Schema{Name: avroName(schema) + "." + avroName(table)}
+
+	var names []string
+
+	for column := range columns {
+		names = append(names, column)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if columns[names[i]].Position != columns[names[j]].Position {
+			return columns[names[i]].Position < columns[names[j]].Position
+		}
+
+		return names[i] < names[j]
+	})
+
+	taken := make(map[string]bool)
+
+	for _, column := range names {
+		f := columnField(columns[column])
+		f.name = avroName(column)
+		f.column = column
+
+		for i := 2; taken[f.name]; i++ {
+			f.name = fmt.Sprintf("%s_%d", avroName(column), i)
+		}
+
+		taken[f.name] = true
+		s.fields = append(s.fields, f)
+	}
+
+	s.Json = s.schemaJson(avroName(schema), avroName(table))
+	s.Canonical = s.canonicalForm()
+	s.Fingerprint = Fingerprint([]byte(s.Canonical))
+
+	return s
+}
+
+func avroName(name string) string {
+	name = invalidNameCharacters.ReplaceAllString(name, "_")
+
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// Values are encoded the way they are in the JSON output, except for numbers, decimals,
+// dates and times. Unsigned BIGINT values don't fit into a long and are decimals.
+func columnField(columnType messages.MessageColumnType) field {
+	switch columnType.Type {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "YEAR":
+		return field{primitive: "int"}
+	case "INT":
+		if columnType.Unsigned {
+			return field{primitive: "long"}
+		}
+
+		return field{primitive: "int"}
+	case "BIGINT":
+		if columnType.Unsigned {
+			return field{primitive: "bytes", logicalType: "decimal", precision: 20}
+		}
+
+		return field{primitive: "long"}
+	case "BIT":
+		return field{primitive: "long"}
+	case "FLOAT":
+		return field{primitive: "float"}
+	case "DOUBLE":
+		return field{primitive: "double"}
+	case "DECIMAL":
+		return field{primitive: "bytes", logicalType: "decimal", precision: columnType.Precision, scale: columnType.Scale}
+	case "DATE":
+		return field{primitive: "int", logicalType: "date"}
+	case "DATETIME", "TIMESTAMP":
+		return field{primitive: "long", logicalType: "timestamp-micros"}
+	}
+
+	return field{primitive: "string"}
+}
+
+type jsonField struct {
+	Name    string          `json:"name"`
+	Type    interface{}     `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type jsonRecord struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace,omitempty"`
+	Fields    []jsonField `json:"fields"`
+}
+
+type jsonLogicalType struct {
+	Type        string `json:"type"`
+	LogicalType string `json:"logicalType"`
+	Precision   int    `json:"precision,omitempty"`
+	Scale       int    `json:"scale,omitempty"`
+}
+
+var nullDefault = json.RawMessage("null")
+
+var nullableStrings = []interface{}{"null", map[string]string{"type": "array", "items": "string"}}
+
+func (s *Schema) schemaJson(namespace, name string) string {
+	row := jsonRecord{Type: "record", Name: name + "_row", Fields: []jsonField{}}
+
+	for _, f := range s.fields {
+		var fieldType interface{} = f.primitive
+
+		if f.logicalType != "" {
+			fieldType = jsonLogicalType{f.primitive, f.logicalType, f.precision, f.scale}
+		}
+
+		row.Fields = append(row.Fields, jsonField{Name: f.name, Type: []interface{}{"null", fieldType}, Default: nullDefault})
+	}
+
+	record := jsonRecord{
+		Type:      "record",
+		Name:      name,
+		Namespace: namespace,
+		Fields: []jsonField{
+			{Name: "Type", Type: "string"},
+			{Name: "BinlogMessageTime", Type: "string"},
+			{Name: "BinlogPosition", Type: "long"},
+			{Name: "XId", Type: "long"},
+			{Name: "Gtid", Type: []interface{}{"null", "string"}, Default: nullDefault},
+			{Name: "KeyColumns", Type: nullableStrings, Default: nullDefault},
+			{Name: "Changes", Type: nullableStrings, Default: nullDefault},
+			{Name: "Before", Type: []interface{}{"null", row}, Default: nullDefault},
+			{Name: "After", Type: []interface{}{"null", row.Name}, Default: nullDefault},
+		},
+	}
+
+	encoded, _ := json.Marshal(record)
+
+	return string(encoded)
+}
+
+// See https://avro.apache.org/docs/1.8.2/spec.html#Transforming+into+Parsing+Canonical+Form
+func (s *Schema) canonicalForm() string {
+	var rowFields []string
+
+	for _, f := range s.fields {
+		rowFields = append(rowFields, `{"name":"`+f.name+`","type":["null","`+f.primitive+`"]}`)
+	}
+
+	nullableStrings := `["null",{"type":"array","items":"string"}]`
+
+	return `{"name":"` + s.Name + `","type":"record","fields":[` +
+		`{"name":"Type","type":"string"},` +
+		`{"name":"BinlogMessageTime","type":"string"},` +
+		`{"name":"BinlogPosition","type":"long"},` +
+		`{"name":"XId","type":"long"},` +
+		`{"name":"Gtid","type":["null","string"]},` +
+		`{"name":"KeyColumns","type":` + nullableStrings + `},` +
+		`{"name":"Changes","type":` + nullableStrings + `},` +
+		`{"name":"Before","type":["null",{"name":"` + s.Name + `_row","type":"record","fields":[` + strings.Join(rowFields, ",") + `]}]},` +
+		`{"name":"After","type":["null","` + s.Name + `_row"]}]}`
+}
+
+const emptyFingerprint uint64 = 0xc15d213aa4d7a795
+
+var fingerprintTable = func() [256]uint64 {
+	var table [256]uint64
+
+	for i := range table {
+		fp := uint64(i)
+
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (emptyFingerprint & -(fp & 1))
+		}
+
+		table[i] = fp
+	}
+
+	return table
+}()
+
+// The 64-bit Rabin fingerprint of the specification, see
+// https://avro.apache.org/docs/1.8.2/spec.html#schema_fingerprints
+func Fingerprint(data []byte) uint64 {
+	fp := emptyFingerprint
+
+	for _, b := range data {
+		fp = (fp >> 8) ^ fingerprintTable[byte(fp)^b]
+	}
+
+	return fp
+}
@@ -0,0 +1,91 @@
+// +build unit
+
+package avro
+
+import (
+	"encoding/json"
+	"testing"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestFingerprint(t *testing.T) {
+	// from the test schemas of the Avro specification
+	testCases := map[string]int64{
+		`"null"`:    7195948357588979594,
+		`"boolean"`: -6970731678124411036,
+		`"int"`:     8247732601305521295,
+	}
+
+	for schema, expected := range testCases {
+		if fingerprint := int64(Fingerprint([]byte(schema))); fingerprint != expected {
+			t.Fatalf("Wrong fingerprint of %s - expected %d, got %d", schema, expected, fingerprint)
+		}
+	}
+}
+
+func TestNewSchema(t *testing.T) {
+	columns := map[string]messages.MessageColumnType{
+		"id":         {Type: "BIGINT", Unsigned: true, Position: 1},
+		"first_name": {Type: "VARCHAR", Length: 20, Position: 2},
+		"first name": {Type: "VARCHAR", Length: 20, Position: 3},
+		"1st":        {Type: "DECIMAL", Precision: 10, Scale: 2, Position: 4},
+	}
+
+	schema := NewSchema("test-db", "t", columns)
+
+	expectedCanonical := `{"name":"test_db.t","type":"record","fields":[` +
+		`{"name":"Type","type":"string"},` +
+		`{"name":"BinlogMessageTime","type":"string"},` +
+		`{"name":"BinlogPosition","type":"long"},` +
+		`{"name":"XId","type":"long"},` +
+		`{"name":"Gtid","type":["null","string"]},` +
+		`{"name":"KeyColumns","type":["null",{"type":"array","items":"string"}]},` +
+		`{"name":"Changes","type":["null",{"type":"array","items":"string"}]},` +
+		`{"name":"Before","type":["null",{"name":"test_db.t_row","type":"record","fields":[` +
+		`{"name":"id","type":["null","bytes"]},` +
+		`{"name":"first_name","type":["null","string"]},` +
+		`{"name":"first_name_2","type":["null","string"]},` +
+		`{"name":"_1st","type":["null","bytes"]}]}]},` +
+		`{"name":"After","type":["null","test_db.t_row"]}]}`
+
+	if schema.Canonical != expectedCanonical {
+		t.Fatalf("Wrong canonical form - got %s", schema.Canonical)
+	}
+
+	if schema.Fingerprint != Fingerprint([]byte(expectedCanonical)) {
+		t.Fatalf("Wrong fingerprint %d", schema.Fingerprint)
+	}
+
+	var parsed struct {
+		Namespace string
+		Fields    []struct {
+			Name string
+			Type json.RawMessage
+		}
+	}
+
+	if err := json.Unmarshal([]byte(schema.Json), &parsed); err != nil {
+		t.Fatalf("Expected schema to be JSON, got %s", err)
+	}
+
+	expectedBefore := `["null",{"type":"record","name":"t_row","fields":[` +
+		`{"name":"id","type":["null",{"type":"bytes","logicalType":"decimal","precision":20}],"default":null},` +
+		`{"name":"first_name","type":["null","string"],"default":null},` +
+		`{"name":"first_name_2","type":["null","string"],"default":null},` +
+		`{"name":"_1st","type":["null",{"type":"bytes","logicalType":"decimal","precision":10,"scale":2}],"default":null}]}]`
+
+	if parsed.Namespace != "test_db" || string(parsed.Fields[7].Type) != expectedBefore {
+		t.Fatalf("Wrong schema - got %s", schema.Json)
+	}
+}
+
+func TestNewSchemaWithoutPositions(t *testing.T) {
+	columns := map[string]messages.MessageColumnType{
+		"name": {Type: "VARCHAR"},
+		"id":   {Type: "INT"},
+	}
+
+	if fields := NewSchema("test_db", "t", columns).fields; fields[0].name != "id" || fields[1].name != "name" {
+		t.Fatalf("Expected columns sorted by name - got %v", fields)
+	}
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"zalora/binlog-parser/parser/avro"
+	"zalora/binlog-parser/parser/messages"
+)
+
+type avroTable struct {
+	columns map[string]messages.MessageColumnType
+	schema  *avro.Schema
+	file    *os.File
+	writer  *avro.ContainerWriter
+}
+
+type avroCollector struct {
+	stream       io.Writer
+	dir          string
+	singleObject bool
+	tables       map[string]*avroTable
+}
+
+// The schema of a table is derived from the column types of its rows, a new schema
+// version starts whenever they change, e.g. after an ALTER TABLE
+func (c *avroCollector) collect(message messages.Message) error {
+	data := rowMessageData(message)
+
+	if data == nil {
+		return nil
+	}
+
+	header := message.GetHeader()
+
+	if data.Columns == nil {
+		return fmt.Errorf("Can't write Avro records of table %s.%s without column types", header.Schema, header.Table)
+	}
+
+	name := header.Schema + "." + header.Table
+	table, ok := c.tables[name]
+
+	if !ok || !reflect.DeepEqual(table.columns, data.Columns) {
+		if ok {
+			if err := table.close(); err != nil {
+				glog.Errorf("Failed to close Avro schema version of table %s: %s", name, err)
+				return err
+			}
+		}
+
+		var err error
+
+		table, err = newAvroTable(header.Schema, header.Table, data.Columns, c.dir, c.singleObject)
+
+		if err != nil {
+			glog.Errorf("Failed to start Avro schema version of table %s: %s", name, err)
+			return err
+		}
+
+		c.tables[name] = table
+	}
+
+	record, err := table.schema.Encode(message)
+
+	if err != nil {
+		glog.Errorf("Failed to convert message to Avro: %s", err)
+		return err
+	}
+
+	if c.singleObject {
+		_, err = c.stream.Write(avro.SingleObject(table.schema, record))
+	} else {
+		err = table.writer.Write(record)
+	}
+
+	if err != nil {
+		glog.Errorf("Failed to write Avro record: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *avroCollector) close() error {
+	var err error
+
+	for _, table := range c.tables {
+		if closeErr := table.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	c.tables = make(map[string]*avroTable)
+
+	return err
+}
+
+// Each schema version of a table gets an object container file of its own, named
+// schema.table.n.avro with the first n not taken yet. With single object encoding, the
+// schema is written to a file named after its fingerprint instead, if there is none yet.
+func newAvroTable(schema, table string, columns map[string]messages.MessageColumnType, dir string, singleObject bool) (*avroTable, error) {
+	version := &avroTable{columns: columns, schema: avro.NewSchema(schema, table, columns)}
+
+	if singleObject {
+		filename := filepath.Join(dir, fmt.Sprintf("%016x.avsc", version.schema.Fingerprint))
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+		if os.IsExist(err) {
+			return version, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+
+		_, err = file.WriteString(version.schema.Json + "\n")
+
+		return version, err
+	}
+
+	for n := 1; ; n++ {
+		filename := filepath.Join(dir, fmt.Sprintf("%s.%s.%d.avro", schema, table, n))
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+		if os.IsExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		glog.V(1).Infof("Writing Avro records of table %s.%s to %s", schema, table, filename)
+
+		version.file = file
+		version.writer, err = avro.NewContainerWriter(file, version.schema)
+
+		return version, err
+	}
+}
+
+// Writes the last block of the object container file before closing it
+func (t *avroTable) close() error {
+	if t.file == nil {
+		return nil
+	}
+
+	err := t.writer.Flush()
+
+	if closeErr := t.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func rowMessageData(message messages.Message) *messages.MessageRowData {
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		return &m.Data
+	case messages.UpdateMessage:
+		return &m.NewData
+	case messages.DeleteMessage:
+		return &m.Data
+	}
+
+	return nil
+}
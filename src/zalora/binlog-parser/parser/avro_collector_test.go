@@ -0,0 +1,110 @@
+// +build unit
+
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/avro"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestConsumerChainCollectAsAvro(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "t", time.Now(), 100, 100, "")
+	columns := map[string]messages.MessageColumnType{"id": {Type: "INT"}}
+	altered := map[string]messages.MessageColumnType{"id": {Type: "INT"}, "name": {Type: "VARCHAR"}}
+
+	rowMessages := []messages.Message{
+		messages.NewQueryMessage(header, "ALTER TABLE t ADD name varchar(10)"),
+		messages.NewInsertMessage(header, nil, messages.MessageRowData{Row: messages.MessageRow{"id": int32(1)}, Columns: columns}),
+		messages.NewDeleteMessage(header, nil, messages.MessageRowData{Row: messages.MessageRow{"id": int32(1)}, Columns: columns}),
+		messages.NewInsertMessage(header, nil, messages.MessageRowData{Row: messages.MessageRow{"id": int32(2), "name": "x"}, Columns: altered}),
+	}
+
+	t.Run("Object container files", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "avro")
+		defer os.RemoveAll(dir)
+
+		ioutil.WriteFile(filepath.Join(dir, "test_db.t.1.avro"), nil, 0644)
+
+		chain := NewConsumerChain()
+		chain.CollectAsAvro(nil, dir, false)
+
+		for _, message := range rowMessages {
+			if err := chain.consumeMessage(message); err != nil {
+				t.Fatalf("Expected no error when consuming message, got %s", err)
+			}
+		}
+
+		if err := chain.Close(); err != nil {
+			t.Fatalf("Expected no error when closing, got %s", err)
+		}
+
+		for filename, schema := range map[string]*avro.Schema{"test_db.t.2.avro": avro.NewSchema("test_db", "t", columns), "test_db.t.3.avro": avro.NewSchema("test_db", "t", altered)} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, filename))
+
+			if err != nil || !bytes.Contains(data, []byte(schema.Json)) {
+				t.Fatalf("Expected object container file %s with schema %s (%v)", filename, schema.Json, err)
+			}
+		}
+
+		// the records of each schema version are written as one block
+		for filename, messageIndexes := range map[string][]int{"test_db.t.2.avro": {1, 2}, "test_db.t.3.avro": {3}} {
+			schema := avro.NewSchema("test_db", "t", rowMessages[messageIndexes[0]].(messages.InsertMessage).Data.Columns)
+			var records []byte
+
+			for _, i := range messageIndexes {
+				record, _ := schema.Encode(rowMessages[i])
+				records = append(records, record...)
+			}
+
+			data, _ := ioutil.ReadFile(filepath.Join(dir, filename))
+
+			sync := data[len(data)-16:]
+
+			if !bytes.HasSuffix(data[:len(data)-16], records) || bytes.Index(data, sync) == len(data)-16 {
+				t.Fatalf("Expected a block of %d records in %s, got %v", len(messageIndexes), filename, data)
+			}
+		}
+	})
+
+	t.Run("Single object encoding", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "avro")
+		defer os.RemoveAll(dir)
+
+		var stream bytes.Buffer
+
+		chain := NewConsumerChain()
+		chain.CollectAsAvro(&stream, dir, true)
+
+		for _, message := range rowMessages {
+			if err := chain.consumeMessage(message); err != nil {
+				t.Fatalf("Expected no error when consuming message, got %s", err)
+			}
+		}
+
+		schema := avro.NewSchema("test_db", "t", altered)
+		record, _ := schema.Encode(rowMessages[3])
+
+		if !bytes.HasSuffix(stream.Bytes(), avro.SingleObject(schema, record)) {
+			t.Fatalf("Expected last record in single object encoding, got %v", stream.Bytes())
+		}
+
+		if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+			t.Fatalf("Expected a schema file per schema version, got %v", files)
+		}
+	})
+
+	t.Run("Without column types", func(t *testing.T) {
+		chain := NewConsumerChain()
+		chain.CollectAsAvro(nil, "", true)
+
+		if err := chain.consumeMessage(messages.NewInsertMessage(header, nil, messages.MessageRowData{Row: messages.MessageRow{"id": int32(1)}})); err == nil {
+			t.Fatal("Expected error when consuming message without column types")
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Relative entries in the index file are resolved against the directory of the index file
+func ReadBinlogIndex(indexFilename string) ([]string, error) {
+	f, err := os.Open(indexFilename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	indexDir := filepath.Dir(indexFilename)
+
+	var binlogFilenames []string
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		binlogFilename := strings.TrimSpace(scanner.Text())
+
+		if binlogFilename == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(binlogFilename) {
+			binlogFilename = filepath.Join(indexDir, binlogFilename)
+		}
+
+		binlogFilenames = append(binlogFilenames, binlogFilename)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return binlogFilenames, nil
+}
@@ -0,0 +1,68 @@
+// +build unit
+
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadBinlogIndex(t *testing.T) {
+	dataDir := os.Getenv("DATA_DIR")
+
+	t.Run("Index file not found", func(t *testing.T) {
+		_, err := ReadBinlogIndex("/not/there")
+
+		if err == nil {
+			t.Fatal("Expected error when reading non-existing index file")
+		}
+	})
+
+	testCases := []struct {
+		fixtureFilename         string
+		expectedBinlogFilenames []string
+	}{
+		{"fixtures/mysql-index-file.01", []string{"/tmp/mysql-bin.000001"}},
+		{"fixtures/mysql-index-file.02", []string{"/tmp/mysql-bin.000002", "/tmp/mysql-bin.000001"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixtureFilename, func(t *testing.T) {
+			binlogFilenames, err := ReadBinlogIndex(filepath.Join(dataDir, tc.fixtureFilename))
+
+			if err != nil {
+				t.Fatal("Expected no error when reading index file")
+			}
+
+			if !reflect.DeepEqual(binlogFilenames, tc.expectedBinlogFilenames) {
+				t.Fatalf("Wrong binlog files read from index - got %v", binlogFilenames)
+			}
+		})
+	}
+
+	t.Run("Relative entries", func(t *testing.T) {
+		tmpdir, _ := ioutil.TempDir("", "binlogs")
+		defer os.RemoveAll(tmpdir)
+
+		indexFilename := filepath.Join(tmpdir, "mysql-bin.index")
+		ioutil.WriteFile(indexFilename, []byte("./mysql-bin.000001\n\nmysql-bin.000002\r\n"), 0644)
+
+		binlogFilenames, err := ReadBinlogIndex(indexFilename)
+
+		if err != nil {
+			t.Fatal("Expected no error when reading index file")
+		}
+
+		expected := []string{
+			filepath.Join(tmpdir, "mysql-bin.000001"),
+			filepath.Join(tmpdir, "mysql-bin.000002"),
+		}
+
+		if !reflect.DeepEqual(binlogFilenames, expected) {
+			t.Fatalf("Wrong binlog files read from index - got %v", binlogFilenames)
+		}
+	})
+}
@@ -6,10 +6,28 @@ import (
 	"zalora/binlog-parser/parser/parser"
 )
 
-func ParseBinlog(binlogFilename string, tableMap database.TableMap, consumerChain ConsumerChain) error {
-	if _, err := os.Stat(binlogFilename); os.IsNotExist(err) {
+type ParseOptions parser.ParseOptions
+
+func ParseBinlog(binlogFilename string, tableMap database.TableMap, consumerChain ConsumerChain, options ParseOptions) error {
+	return parseBinlogFiles([]string{binlogFilename}, tableMap, consumerChain, options)
+}
+
+func ParseBinlogIndex(indexFilename string, tableMap database.TableMap, consumerChain ConsumerChain, options ParseOptions) error {
+	binlogFilenames, err := ReadBinlogIndex(indexFilename)
+
+	if err != nil {
 		return err
 	}
 
-	return parser.ParseBinlogToMessages(binlogFilename, tableMap, consumerChain.consumeMessage)
+	return parseBinlogFiles(binlogFilenames, tableMap, consumerChain, options)
+}
+
+func parseBinlogFiles(binlogFilenames []string, tableMap database.TableMap, consumerChain ConsumerChain, options ParseOptions) error {
+	for _, binlogFilename := range binlogFilenames {
+		if _, err := os.Stat(binlogFilename); os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return parser.ParseBinlogFilesToMessages(binlogFilenames, tableMap, consumerChain.consumeMessage, parser.ParseOptions(options))
 }
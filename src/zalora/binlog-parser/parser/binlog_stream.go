@@ -0,0 +1,13 @@
+package parser
+
+import (
+	"context"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/parser"
+)
+
+type StreamOptions parser.StreamOptions
+
+func StreamBinlog(ctx context.Context, streamOptions StreamOptions, tableMap database.TableMap, consumerChain ConsumerChain, options ParseOptions) error {
+	return parser.StreamBinlogToMessages(ctx, parser.StreamOptions(streamOptions), tableMap, consumerChain.consumeMessage, parser.ParseOptions(options))
+}
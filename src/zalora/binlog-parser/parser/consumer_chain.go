@@ -1,17 +1,25 @@
 package parser
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/siddontang/go-mysql/mysql"
 	"io"
+	"strings"
+	"time"
 	"zalora/binlog-parser/parser/messages"
 )
 
 type ConsumerChain struct {
-	predicates  []predicate
-	collectors  []collector
-	prettyPrint bool
+	predicates            []predicate
+	collectors            []collector
+	transactionCollectors []collector
+	closers               []func() error
+	prettyPrint           bool
+	transactionBoundaries bool
+	beginCollected        bool
 }
 
 type predicate func(message messages.Message) bool
@@ -30,6 +38,28 @@ func (c *ConsumerChain) IncludeSchemas(schemas ...string) {
 	c.predicates = append(c.predicates, schemaPredicate(schemas...))
 }
 
+// Zero start or stop datetime means the range is open on that side, the stop datetime
+// itself is excluded
+func (c *ConsumerChain) IncludeTimeRange(startDatetime, stopDatetime time.Time) {
+	c.predicates = append(c.predicates, timeRangePredicate(startDatetime, stopDatetime))
+}
+
+// Messages without a GTID are not included
+func (c *ConsumerChain) IncludeGtids(gtidSet mysql.GTIDSet) {
+	c.predicates = append(c.predicates, gtidPredicate(gtidSet, true))
+}
+
+// Messages without a GTID are not excluded
+func (c *ConsumerChain) ExcludeGtids(gtidSet mysql.GTIDSet) {
+	c.predicates = append(c.predicates, gtidPredicate(gtidSet, false))
+}
+
+// Passes TransactionBegin and TransactionCommit messages around the row messages of each
+// transaction on to the collectors, they are dropped otherwise
+func (c *ConsumerChain) IncludeTransactionBoundaries() {
+	c.transactionBoundaries = true
+}
+
 func (c *ConsumerChain) PrettyPrint(prettyPrint bool) {
 	c.prettyPrint = prettyPrint
 }
@@ -38,16 +68,134 @@ func (c *ConsumerChain) CollectAsJson(stream io.Writer, prettyPrint bool) {
 	c.collectors = append(c.collectors, streamCollector(stream, prettyPrint))
 }
 
+// Writes Insert, Update and Delete messages as the JSON payloads of Debezium change events,
+// one per line. Other messages are dropped.
+func (c *ConsumerChain) CollectAsDebezium(stream io.Writer, prettyPrint bool) {
+	c.collectors = append(c.collectors, debeziumCollector(stream, prettyPrint))
+}
+
+// Writes messages as MySQL statements, row messages as INSERT, UPDATE and DELETE
+// statements with the values of the row
+func (c *ConsumerChain) CollectAsSqlText(stream io.Writer, binaryEncoding string) {
+	c.collectors = append(c.collectors, sqlTextCollector(stream, binaryEncoding))
+}
+
+// Writes Insert, Update and Delete messages as rows of CSV files in dir, or TSV files with
+// tsv, one per table and column set. Other messages are dropped. Needs
+// ParseOptions.ColumnTypes.
+func (c *ConsumerChain) CollectAsCsv(dir string, tsv bool) {
+	csv := &csvCollector{dir: dir, tsv: tsv, tables: make(map[string]*csvTable)}
+
+	c.collectors = append(c.collectors, csv.collect)
+	c.closers = append(c.closers, csv.close)
+}
+
+// Writes Insert, Update and Delete messages as Avro records to object container files in
+// dir, one per table and schema version, or to the stream in single object encoding with
+// the schemas in dir. Other messages are dropped. Needs ParseOptions.ColumnTypes. The
+// container files are complete once the chain is closed.
+func (c *ConsumerChain) CollectAsAvro(stream io.Writer, dir string, singleObject bool) {
+	avro := &avroCollector{stream: stream, dir: dir, singleObject: singleObject, tables: make(map[string]*avroTable)}
+
+	c.collectors = append(c.collectors, avro.collect)
+	c.closers = append(c.closers, avro.close)
+}
+
+// Publishes Insert, Update and Delete messages to Kafka, to the topic named by the template
+// with {schema} and {table} replaced. Messages are keyed by the key of their row and
+// valued by their JSON, or their Debezium change event. Other messages are dropped.
+func (c *ConsumerChain) CollectAsKafka(producer KafkaProducer, topicTemplate string, debezium bool) {
+	c.collectors = append(c.collectors, kafkaCollector(producer, topicTemplate, debezium))
+	c.closers = append(c.closers, producer.Close)
+}
+
+// Applies Insert, Update and Delete messages to a target database as INSERT, UPDATE and
+// DELETE statements, each binlog transaction in one transaction. The transaction
+// boundaries are passed to this collector even if not included otherwise.
+func (c *ConsumerChain) CollectAsSql(db *sql.DB, options SqlReplayOptions) {
+	collector := sqlCollector(db, options)
+
+	c.collectors = append(c.collectors, collector)
+	c.transactionCollectors = append(c.transactionCollectors, collector)
+	c.closers = append(c.closers, db.Close)
+}
+
+// Writes the statements undoing Insert, Update and Delete messages as MySQL SQL, once all
+// messages are collected. Transactions and their statements are written in reverse
+// order, each transaction between BEGIN and COMMIT.
+func (c *ConsumerChain) CollectAsFlashback(stream io.Writer, binaryEncoding string) {
+	f := &flashback{
+		stream: stream,
+		options: SqlReplayOptions{
+			Dialect:        SQL_DIALECT_MYSQL,
+			TableTemplate:  "{schema}.{table}",
+			Conflicts:      SQL_CONFLICTS_ERROR,
+			BinaryEncoding: binaryEncoding,
+		},
+	}
+
+	c.collectors = append(c.collectors, f.collect)
+	c.transactionCollectors = append(c.transactionCollectors, f.collect)
+	c.closers = append(c.closers, f.write)
+}
+
+// Waits for collectors which buffer messages to pass them on, returns the first error of
+// any of them
+func (c *ConsumerChain) Close() error {
+	var err error
+
+	for _, closer := range c.closers {
+		if closerErr := closer(); closerErr != nil && err == nil {
+			err = closerErr
+		}
+	}
+
+	return err
+}
+
 func (c *ConsumerChain) consumeMessage(message messages.Message) error {
-	for _, predicate := range c.predicates {
-		pass := predicate(message)
+	if isTransactionBoundary(message) {
+		return c.consumeTransactionBoundary(message)
+	}
+
+	if !c.passes(message) {
+		return nil
+	}
+
+	return collect(c.collectors, message)
+}
 
-		if !pass {
-			return nil
+// Collectors applying transactions get all boundaries, so that none is left open. The
+// other collectors get the commit of a transaction only if they got its begin, as the
+// time of the commit may be out of the time range of the begin.
+func (c *ConsumerChain) consumeTransactionBoundary(message messages.Message) error {
+	if !c.transactionBoundaries {
+		return collect(c.transactionCollectors, message)
+	}
+
+	if message.GetType() == messages.MESSAGE_TYPE_TRANSACTION_BEGIN {
+		c.beginCollected = c.passes(message)
+	}
+
+	if !c.beginCollected {
+		return collect(c.transactionCollectors, message)
+	}
+
+	return collect(c.collectors, message)
+}
+
+func (c *ConsumerChain) passes(message messages.Message) bool {
+	for _, predicate := range c.predicates {
+		if !predicate(message) {
+			return false
 		}
 	}
 
-	for _, collector := range c.collectors {
+	return true
+}
+
+func collect(collectors []collector, message messages.Message) error {
+	for _, collector := range collectors {
 		collector_err := collector(message)
 
 		if collector_err != nil {
@@ -100,6 +248,67 @@ func tablesPredicate(tables ...string) predicate {
 	}
 }
 
+func timeRangePredicate(startDatetime, stopDatetime time.Time) predicate {
+	return func(message messages.Message) bool {
+		messageTime, err := time.Parse(time.RFC3339, message.GetHeader().BinlogMessageTime)
+
+		if err != nil {
+			glog.Errorf("Failed to parse binlog message time %s: %s", message.GetHeader().BinlogMessageTime, err)
+			return false
+		}
+
+		if !startDatetime.IsZero() && messageTime.Before(startDatetime) {
+			return false
+		}
+
+		if !stopDatetime.IsZero() && !messageTime.Before(stopDatetime) {
+			return false
+		}
+
+		return true
+	}
+}
+
+func gtidPredicate(gtidSet mysql.GTIDSet, include bool) predicate {
+	var lastGtid string
+	var lastContained bool
+
+	return func(message messages.Message) bool {
+		gtid := message.GetHeader().Gtid
+
+		if gtid == "" {
+			return !include
+		}
+
+		// all messages of a transaction have the same GTID
+		if gtid != lastGtid {
+			lastGtid = gtid
+			lastContained = false
+
+			if messageGtidSet, err := ParseGtidSet(gtid); err == nil {
+				lastContained = gtidSet.Contain(messageGtidSet)
+			} else {
+				glog.Errorf("Failed to parse message GTID %s: %s", gtid, err)
+			}
+		}
+
+		return lastContained == include
+	}
+}
+
+// MySQL GTID sets are written as uuid:interval, MariaDB GTID sets as domain-server-sequence
+func ParseGtidSet(str string) (mysql.GTIDSet, error) {
+	if strings.Contains(str, ":") {
+		return mysql.ParseGTIDSet(mysql.MySQLFlavor, str)
+	}
+
+	return mysql.ParseGTIDSet(mysql.MariaDBFlavor, str)
+}
+
+func isTransactionBoundary(message messages.Message) bool {
+	return message.GetType() == messages.MESSAGE_TYPE_TRANSACTION_BEGIN || message.GetType() == messages.MESSAGE_TYPE_TRANSACTION_COMMIT
+}
+
 func marshalMessage(message messages.Message, prettyPrint bool) ([]byte, error) {
 	if prettyPrint {
 		return json.MarshalIndent(message, "", "    ")
@@ -3,8 +3,10 @@
 package parser
 
 import (
+	"github.com/siddontang/go-mysql/mysql"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 	"zalora/binlog-parser/parser/messages"
@@ -12,12 +14,12 @@ import (
 
 func TestConsumerChain(t *testing.T) {
 	messageOne := messages.NewQueryMessage(
-		messages.NewMessageHeader("database_name", "table_name", time.Now(), 100, 100),
+		messages.NewMessageHeader("database_name", "table_name", time.Now(), 100, 100, ""),
 		messages.SqlQuery("SELECT * FROM table"),
 	)
 
 	messageTwo := messages.NewQueryMessage(
-		messages.NewMessageHeader("database_name", "table_name", time.Now(), 100, 100),
+		messages.NewMessageHeader("database_name", "table_name", time.Now(), 100, 100, ""),
 		messages.SqlQuery("SELECT * FROM table"),
 	)
 
@@ -97,6 +99,78 @@ func TestConsumerChain(t *testing.T) {
 		assertJsonOutputNotEmpty(t, tmpfile)
 	})
 
+	t.Run("Filter time range, passes through", func(t *testing.T) {
+		tmpfile, _ := ioutil.TempFile("", "messages.json")
+		defer os.Remove(tmpfile.Name())
+
+		message := messages.NewQueryMessage(
+			messages.NewMessageHeader("database_name", "table_name", time.Date(2017, 4, 13, 6, 34, 30, 0, time.UTC), 100, 100, ""),
+			messages.SqlQuery("SELECT * FROM table"),
+		)
+
+		chain := NewConsumerChain()
+		chain.CollectAsJson(tmpfile, true)
+		chain.IncludeTimeRange(time.Date(2017, 4, 13, 6, 34, 30, 0, time.UTC), time.Date(2017, 4, 13, 6, 34, 31, 0, time.UTC))
+
+		err := chain.consumeMessage(message)
+
+		if err != nil {
+			t.Fatal("Failed to consume message")
+		}
+
+		assertJsonOutputNotEmpty(t, tmpfile)
+	})
+
+	t.Run("Filter time range, open range passes through", func(t *testing.T) {
+		tmpfile, _ := ioutil.TempFile("", "messages.json")
+		defer os.Remove(tmpfile.Name())
+
+		chain := NewConsumerChain()
+		chain.CollectAsJson(tmpfile, true)
+		chain.IncludeTimeRange(time.Time{}, time.Time{})
+
+		err := chain.consumeMessage(messageTwo)
+
+		if err != nil {
+			t.Fatal("Failed to consume message")
+		}
+
+		assertJsonOutputNotEmpty(t, tmpfile)
+	})
+
+	testCasesTimeRangeFilteredOut := []struct {
+		startDatetime time.Time
+		stopDatetime  time.Time
+	}{
+		{time.Date(2017, 4, 13, 6, 34, 31, 0, time.UTC), time.Time{}},                                // message before start
+		{time.Time{}, time.Date(2017, 4, 13, 6, 34, 30, 0, time.UTC)},                                // stop datetime is excluded
+		{time.Date(2017, 4, 12, 0, 0, 0, 0, time.UTC), time.Date(2017, 4, 13, 0, 0, 0, 0, time.UTC)}, // message after stop
+	}
+
+	for _, tc := range testCasesTimeRangeFilteredOut {
+		t.Run("Filter time range, filtered out", func(t *testing.T) {
+			tmpfile, _ := ioutil.TempFile("", "messages.json")
+			defer os.Remove(tmpfile.Name())
+
+			message := messages.NewQueryMessage(
+				messages.NewMessageHeader("database_name", "table_name", time.Date(2017, 4, 13, 6, 34, 30, 0, time.UTC), 100, 100, ""),
+				messages.SqlQuery("SELECT * FROM table"),
+			)
+
+			chain := NewConsumerChain()
+			chain.CollectAsJson(tmpfile, true)
+			chain.IncludeTimeRange(tc.startDatetime, tc.stopDatetime)
+
+			err := chain.consumeMessage(message)
+
+			if err != nil {
+				t.Fatal("Failed to consume message")
+			}
+
+			assertJsonOutputEmpty(t, tmpfile)
+		})
+	}
+
 	t.Run("Filter table, filtered out", func(t *testing.T) {
 		tmpfile, _ := ioutil.TempFile("", "messages.json")
 		defer os.Remove(tmpfile.Name())
@@ -115,6 +189,230 @@ func TestConsumerChain(t *testing.T) {
 	})
 }
 
+func TestConsumerChainGtidFilters(t *testing.T) {
+	gtidSet, _ := ParseGtidSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5")
+	mariadbGtidSet, _ := ParseGtidSet("0-3704-2815")
+
+	testCases := []struct {
+		name            string
+		gtid            string
+		include         mysql.GTIDSet
+		exclude         mysql.GTIDSet
+		expectCollected bool
+	}{
+		{"Include, in set", "3e11fa47-71ca-11e1-9e33-c80aa9429562:5", gtidSet, nil, true},
+		{"Include, not in set", "3e11fa47-71ca-11e1-9e33-c80aa9429562:6", gtidSet, nil, false},
+		{"Include, other server", "4e11fa47-71ca-11e1-9e33-c80aa9429562:1", gtidSet, nil, false},
+		{"Include, no GTID", "", gtidSet, nil, false},
+		{"Exclude, in set", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1", nil, gtidSet, false},
+		{"Exclude, not in set", "3e11fa47-71ca-11e1-9e33-c80aa9429562:6", nil, gtidSet, true},
+		{"Exclude, no GTID", "", nil, gtidSet, true},
+		{"Include MariaDB, in set", "0-3704-2815", mariadbGtidSet, nil, true},
+		{"Include MariaDB, not in set", "0-3704-2816", mariadbGtidSet, nil, false},
+		{"Include MariaDB, MySQL GTID", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1", mariadbGtidSet, nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpfile, _ := ioutil.TempFile("", "messages.json")
+			defer os.Remove(tmpfile.Name())
+
+			message := messages.NewQueryMessage(
+				messages.NewMessageHeader("database_name", "table_name", time.Now(), 100, 100, tc.gtid),
+				messages.SqlQuery("SELECT * FROM table"),
+			)
+
+			chain := NewConsumerChain()
+			chain.CollectAsJson(tmpfile, true)
+
+			if tc.include != nil {
+				chain.IncludeGtids(tc.include)
+			}
+
+			if tc.exclude != nil {
+				chain.ExcludeGtids(tc.exclude)
+			}
+
+			err := chain.consumeMessage(message)
+
+			if err != nil {
+				t.Fatal("Failed to consume message")
+			}
+
+			if tc.expectCollected {
+				assertJsonOutputNotEmpty(t, tmpfile)
+			} else {
+				assertJsonOutputEmpty(t, tmpfile)
+			}
+		})
+	}
+
+	t.Run("Transactions", func(t *testing.T) {
+		header := func(gtid string) messages.MessageHeader {
+			return messages.NewMessageHeader("database_name", "table_name", time.Now(), 100, 100, gtid)
+		}
+
+		// the messages of two transactions, as they are parsed from a binlog
+		var transactions []messages.Message
+
+		for _, gtid := range []string{"3e11fa47-71ca-11e1-9e33-c80aa9429562:5", "3e11fa47-71ca-11e1-9e33-c80aa9429562:6"} {
+			transactions = append(transactions,
+				messages.NewTransactionBeginMessage(header(gtid), 1, time.Now()),
+				messages.NewInsertMessage(header(gtid), nil, messages.MessageRowData{Row: messages.MessageRow{"id": 1}}),
+				messages.NewTransactionCommitMessage(header(gtid), 1, time.Now()),
+			)
+		}
+
+		for _, include := range []bool{true, false} {
+			chain := NewConsumerChain()
+			chain.IncludeTransactionBoundaries()
+
+			var collected []messages.Message
+
+			chain.collectors = append(chain.collectors, func(message messages.Message) error {
+				collected = append(collected, message)
+				return nil
+			})
+
+			if include {
+				chain.IncludeGtids(gtidSet)
+			} else {
+				chain.ExcludeGtids(gtidSet)
+			}
+
+			for _, message := range transactions {
+				if err := chain.consumeMessage(message); err != nil {
+					t.Fatal("Failed to consume message")
+				}
+			}
+
+			expected := transactions[3:]
+
+			if include {
+				expected = transactions[:3]
+			}
+
+			if !reflect.DeepEqual(collected, expected) {
+				t.Fatalf("Wrong messages collected when including %v - got %v", include, collected)
+			}
+		}
+	})
+
+	t.Run("Invalid GTID set", func(t *testing.T) {
+		_, err := ParseGtidSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:x")
+
+		if err == nil {
+			t.Fatal("Expected error for invalid GTID set")
+		}
+	})
+}
+
+func TestConsumerChainTransactionBoundaries(t *testing.T) {
+	message := messages.NewTransactionBeginMessage(
+		messages.NewMessageHeader("", "", time.Now(), 100, 100, ""),
+		1,
+		time.Now(),
+	)
+
+	testCases := []struct {
+		name                  string
+		transactionBoundaries bool
+		expectCollected       bool
+	}{
+		{"Dropped by default", false, false},
+		{"Included", true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpfile, _ := ioutil.TempFile("", "messages.json")
+			defer os.Remove(tmpfile.Name())
+
+			chain := NewConsumerChain()
+			chain.CollectAsJson(tmpfile, true)
+			chain.IncludeSchemas("database_name")
+
+			if tc.transactionBoundaries {
+				chain.IncludeTransactionBoundaries()
+			}
+
+			err := chain.consumeMessage(message)
+
+			if err != nil {
+				t.Fatal("Failed to consume message")
+			}
+
+			if tc.expectCollected {
+				assertJsonOutputNotEmpty(t, tmpfile)
+			} else {
+				assertJsonOutputEmpty(t, tmpfile)
+			}
+		})
+	}
+}
+
+func TestConsumerChainTransactionBoundariesInTimeRange(t *testing.T) {
+	header := func(messageTime time.Time) messages.MessageHeader {
+		return messages.NewMessageHeader("", "", messageTime, 100, 100, "")
+	}
+
+	beginTime := time.Unix(1492065270, 0)
+	commitTime := beginTime.Add(time.Minute)
+
+	// the commit is logged after the stop datetime, the begin and the row before it
+	transaction := []messages.Message{
+		messages.NewTransactionBeginMessage(header(beginTime), 1, commitTime),
+		messages.NewInsertMessage(
+			messages.NewMessageHeader("database_name", "table_name", beginTime, 100, 100, ""),
+			nil,
+			messages.MessageRowData{Row: messages.MessageRow{"id": 1}},
+		),
+		messages.NewTransactionCommitMessage(header(commitTime), 1, commitTime),
+	}
+
+	for _, transactionBoundaries := range []bool{false, true} {
+		chain := NewConsumerChain()
+		chain.IncludeTimeRange(time.Time{}, beginTime.Add(time.Second))
+
+		if transactionBoundaries {
+			chain.IncludeTransactionBoundaries()
+		}
+
+		var collected, transactionCollected []messages.Message
+
+		collector := func(message messages.Message) error {
+			transactionCollected = append(transactionCollected, message)
+			return nil
+		}
+
+		chain.collectors = append(chain.collectors, collector, func(message messages.Message) error {
+			collected = append(collected, message)
+			return nil
+		})
+		chain.transactionCollectors = append(chain.transactionCollectors, collector)
+
+		for _, message := range transaction {
+			if err := chain.consumeMessage(message); err != nil {
+				t.Fatal("Failed to consume message")
+			}
+		}
+
+		if !reflect.DeepEqual(transactionCollected, transaction) {
+			t.Fatalf("Wrong messages collected by transaction collector with boundaries %v - got %v", transactionBoundaries, transactionCollected)
+		}
+
+		expected := transaction[1:2]
+
+		if transactionBoundaries {
+			expected = transaction
+		}
+
+		if !reflect.DeepEqual(collected, expected) {
+			t.Fatalf("Wrong messages collected with boundaries %v - got %v", transactionBoundaries, collected)
+		}
+	}
+}
+
 func assertJsonOutputNotEmpty(t *testing.T, tmpfile *os.File) {
 	fileContent, err := ioutil.ReadFile(tmpfile.Name())
 
@@ -0,0 +1,87 @@
+package conversion
+
+import (
+	"fmt"
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// The indexes of the columns changed by an update, from its rows before and after the
+// update. With binlog_row_image=MINIMAL, the image after the update only holds the
+// columns set by the update, and the image before it only the key columns. Columns
+// missing after the update are unchanged, columns missing before it changed.
+func changedColumns(oldRow, newRow []interface{}, rowsEvent replication.RowsEvent) []int {
+	var changed []int
+
+	for i := range newRow {
+		if !isColumnPresent(rowsEvent.ColumnBitmap2, i) {
+			continue
+		}
+
+		if i < len(oldRow) && isColumnPresent(rowsEvent.ColumnBitmap1, i) && reflect.DeepEqual(oldRow[i], newRow[i]) {
+			continue
+		}
+
+		changed = append(changed, i)
+	}
+
+	return changed
+}
+
+// Reduces the rows of an update message to the changed columns and the key columns, and
+// lists the changed columns by name in table order
+func onlyChangedColumns(message messages.UpdateMessage, changed []int, row []interface{}, tableMetadata database.TableMetadata) messages.UpdateMessage {
+	detectedMismatch, _ := detectMismatch(row, tableMetadata.Fields)
+	names := make([]string, len(row))
+	kept := make([]bool, len(row))
+
+	for i := range row {
+		names[i] = tableMetadata.Fields[i]
+
+		if detectedMismatch {
+			names[i] = fmt.Sprintf("(unknown_%d)", i)
+		}
+
+		_, kept[i] = message.Key[names[i]]
+	}
+
+	message.Changes = []string{}
+
+	for _, column := range changed {
+		message.Changes = append(message.Changes, names[column])
+		kept[column] = true
+	}
+
+	message.OldData = onlyColumns(message.OldData, names, kept)
+	message.NewData = onlyColumns(message.NewData, names, kept)
+
+	return message
+}
+
+// The column types are kept for all columns, like the ones of columns not logged
+func onlyColumns(data messages.MessageRowData, names []string, kept []bool) messages.MessageRowData {
+	reduced := messages.MessageRowData{Row: make(messages.MessageRow), Columns: data.Columns, MappingNotice: data.MappingNotice}
+	isKept := make(map[string]bool)
+
+	for i, name := range names {
+		if !kept[i] {
+			continue
+		}
+
+		isKept[name] = true
+
+		if value, ok := data.Row[name]; ok {
+			reduced.Row[name] = value
+		}
+	}
+
+	for _, name := range data.NotLogged {
+		if isKept[name] {
+			reduced.NotLogged = append(reduced.NotLogged, name)
+		}
+	}
+
+	return reduced
+}
@@ -0,0 +1,76 @@
+// +build unit
+
+package conversion
+
+import (
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"testing"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestChangedColumnsOnly(t *testing.T) {
+	tableMetadata := database.TableMetadata{Fields: map[int]string{0: "id", 1: "name", 2: "level", 3: "tags"}, PrimaryKey: []int{0}}
+
+	testCases := []struct {
+		name            string
+		bitmaps         [2][]byte
+		oldRow          []interface{}
+		newRow          []interface{}
+		expectedChanges []string
+		expectedOldRow  messages.MessageRow
+		expectedNewRow  messages.MessageRow
+	}{
+		{
+			"Full row image",
+			[2][]byte{{0x0f}, {0x0f}},
+			[]interface{}{7, "Max", nil, []string{"a"}},
+			[]interface{}{7, "Moe", nil, []string{"a", "b"}},
+			[]string{"name", "tags"},
+			messages.MessageRow{"id": 7, "name": "Max", "tags": []string{"a"}},
+			messages.MessageRow{"id": 7, "name": "Moe", "tags": []string{"a", "b"}},
+		},
+		{
+			"Minimal row image",
+			[2][]byte{{0x01}, {0x06}},
+			[]interface{}{7, nil, nil, nil},
+			[]interface{}{nil, "Moe", nil, nil},
+			[]string{"name", "level"},
+			messages.MessageRow{"id": 7},
+			messages.MessageRow{"name": "Moe", "level": nil},
+		},
+		{
+			"Nothing changed",
+			[2][]byte{nil, nil},
+			[]interface{}{7, "Max", 1, nil},
+			[]interface{}{7, "Max", 1, nil},
+			[]string{},
+			messages.MessageRow{"id": 7},
+			messages.MessageRow{"id": 7},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			eventHeader := createEventHeader(100, replication.UPDATE_ROWS_EVENTv2)
+			rowsEvent := createRowsEvent(tc.oldRow, tc.newRow)
+			rowsEvent.ColumnBitmap1, rowsEvent.ColumnBitmap2 = tc.bitmaps[0], tc.bitmaps[1]
+
+			rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, tableMetadata)}
+			update := ConvertRowsEventsToMessages(1, "", rowsEventData, ConversionOptions{ChangedColumnsOnly: true})[0].(messages.UpdateMessage)
+
+			if !reflect.DeepEqual(update.Changes, tc.expectedChanges) {
+				t.Fatalf("Wrong changes - got %v", update.Changes)
+			}
+
+			if !reflect.DeepEqual(update.OldData.Row, tc.expectedOldRow) || !reflect.DeepEqual(update.NewData.Row, tc.expectedNewRow) {
+				t.Fatalf("Wrong rows - got %v and %v", update.OldData.Row, update.NewData.Row)
+			}
+
+			if !reflect.DeepEqual(update.Key, messages.MessageRow{"id": 7}) {
+				t.Fatalf("Wrong key - got %v", update.Key)
+			}
+		})
+	}
+}
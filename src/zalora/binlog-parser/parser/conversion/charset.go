@@ -0,0 +1,341 @@
+package conversion
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/siddontang/go-mysql/mysql"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+	"zalora/binlog-parser/database"
+)
+
+const (
+	BINARY_ENCODING_BASE64 = "base64"
+	BINARY_ENCODING_HEX    = "hex"
+)
+
+// The charsets decoded with golang.org/x/text, by their MySQL names. armscii8, dec8,
+// geostd8, hp8, keybcs2, macce and swe7 have no decoder.
+var charsetEncodings = map[string]encoding.Encoding{
+	"big5":     traditionalchinese.Big5,
+	"cp1250":   charmap.Windows1250,
+	"cp1251":   charmap.Windows1251,
+	"cp1256":   charmap.Windows1256,
+	"cp1257":   charmap.Windows1257,
+	"cp850":    charmap.CodePage850,
+	"cp852":    charmap.CodePage852,
+	"cp866":    charmap.CodePage866,
+	"cp932":    japanese.ShiftJIS,
+	"eucjpms":  japanese.EUCJP,
+	"euckr":    korean.EUCKR,
+	"gb18030":  simplifiedchinese.GB18030,
+	"gb2312":   simplifiedchinese.GBK,
+	"gbk":      simplifiedchinese.GBK,
+	"greek":    charmap.ISO8859_7,
+	"hebrew":   charmap.ISO8859_8,
+	"koi8r":    charmap.KOI8R,
+	"koi8u":    charmap.KOI8U,
+	"latin2":   charmap.ISO8859_2,
+	"latin5":   charmap.ISO8859_9,
+	"latin7":   charmap.ISO8859_13,
+	"macroman": charmap.Macintosh,
+	"sjis":     japanese.ShiftJIS,
+	"tis620":   charmap.Windows874,
+	"ujis":     japanese.EUCJP,
+}
+
+// Character sets of the collation ids logged in the binlog. Unknown collations are
+// treated like an unknown charset.
+func collationCharset(collationId uint64) string {
+	switch {
+	case collationId == 63:
+		return "binary"
+	case collationId == 5 || collationId == 8 || collationId == 15 || collationId == 31 || collationId >= 47 && collationId <= 49 || collationId == 94:
+		return "latin1"
+	case collationId == 11 || collationId == 65:
+		return "ascii"
+	case collationId == 33 || collationId == 76 || collationId == 83 || collationId >= 192 && collationId <= 223:
+		return "utf8"
+	case collationId == 45 || collationId == 46 || collationId >= 224 && collationId <= 247 || collationId >= 255 && collationId <= 323:
+		return "utf8mb4"
+	case collationId == 35 || collationId == 90 || collationId >= 128 && collationId <= 151 || collationId == 159:
+		return "ucs2"
+	case collationId == 54 || collationId == 55 || collationId >= 101 && collationId <= 124:
+		return "utf16"
+	case collationId == 56 || collationId == 62:
+		return "utf16le"
+	case collationId == 60 || collationId == 61 || collationId >= 160 && collationId <= 183:
+		return "utf32"
+	case collationId >= 248 && collationId <= 250:
+		return "gb18030"
+	}
+
+	switch collationId {
+	case 1, 84:
+		return "big5"
+	case 2, 9, 21, 27, 77:
+		return "latin2"
+	case 3, 69:
+		return "dec8"
+	case 4, 80:
+		return "cp850"
+	case 6, 72:
+		return "hp8"
+	case 7, 74:
+		return "koi8r"
+	case 10, 82:
+		return "swe7"
+	case 12, 91:
+		return "ujis"
+	case 13, 88:
+		return "sjis"
+	case 14, 23, 50, 51, 52:
+		return "cp1251"
+	case 16, 71:
+		return "hebrew"
+	case 18, 89:
+		return "tis620"
+	case 19, 85:
+		return "euckr"
+	case 20, 41, 42, 79:
+		return "latin7"
+	case 22, 75:
+		return "koi8u"
+	case 24, 86:
+		return "gb2312"
+	case 25, 70:
+		return "greek"
+	case 26, 34, 44, 66, 99:
+		return "cp1250"
+	case 28, 87:
+		return "gbk"
+	case 29, 58, 59:
+		return "cp1257"
+	case 30, 78:
+		return "latin5"
+	case 32, 64:
+		return "armscii8"
+	case 36, 68:
+		return "cp866"
+	case 37, 73:
+		return "keybcs2"
+	case 38, 43:
+		return "macce"
+	case 39, 53:
+		return "macroman"
+	case 40, 81:
+		return "cp852"
+	case 57, 67:
+		return "cp1256"
+	case 92, 93:
+		return "geostd8"
+	case 95, 96:
+		return "cp932"
+	case 97, 98:
+		return "eucjpms"
+	}
+
+	return ""
+}
+
+// The charset name from the schema, or from the collation id in the binlog
+func columnCharset(columnMetadata database.ColumnMetadata) string {
+	if columnMetadata.CharacterSet != "" {
+		return columnMetadata.CharacterSet
+	}
+
+	if columnMetadata.Charset != 0 {
+		return collationCharset(columnMetadata.Charset)
+	}
+
+	return ""
+}
+
+// Binary columns have no charset in information_schema, only their type tells them apart
+func isBinaryColumn(columnMetadata database.ColumnMetadata) bool {
+	switch strings.SplitN(columnMetadata.Type, "(", 2)[0] {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return true
+	}
+
+	return columnCharset(columnMetadata) == "binary"
+}
+
+// Strings and BLOBs of text columns are decoded to UTF-8, binary values are encoded with
+// the binary encoding. Without a charset, strings are kept as they are if they are valid
+// UTF-8 while BLOBs are encoded.
+func convertCharacterValue(value interface{}, columnType byte, columnMetadata database.ColumnMetadata, binaryEncoding string) interface{} {
+	var data []byte
+
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return value
+	}
+
+	charset := columnCharset(columnMetadata)
+
+	if columnType == mysql.MYSQL_TYPE_GEOMETRY || isBinaryColumn(columnMetadata) {
+		return encodeBinary(data, binaryEncoding)
+	}
+
+	if _, isBlob := value.([]byte); isBlob && charset == "" && columnMetadata.Type == "" {
+		return encodeBinary(data, binaryEncoding)
+	}
+
+	if text, ok := decodeText(data, charset); ok {
+		return text
+	}
+
+	return encodeBinary(data, binaryEncoding)
+}
+
+// Values in charsets without decoder aren't decoded, see charsetNotice
+func decodeText(value []byte, charset string) (string, bool) {
+	switch charset {
+	case "", "utf8", "utf8mb3", "utf8mb4", "ascii":
+		return string(value), utf8.Valid(value)
+	case "latin1":
+		return decodeLatin1(value), true
+	case "ucs2", "utf16":
+		return decodeUtf16(value, binary.BigEndian)
+	case "utf16le":
+		return decodeUtf16(value, binary.LittleEndian)
+	case "utf32":
+		return decodeUtf32(value)
+	}
+
+	if charsetEncoding, ok := charsetEncodings[charset]; ok {
+		return decodeWithEncoding(value, charsetEncoding)
+	}
+
+	return "", false
+}
+
+// The decoders replace invalid bytes by U+FFFD, which makes the value invalid here
+func decodeWithEncoding(value []byte, charsetEncoding encoding.Encoding) (string, bool) {
+	text, err := charsetEncoding.NewDecoder().Bytes(value)
+
+	if err != nil || bytes.ContainsRune(text, utf8.RuneError) {
+		return "", false
+	}
+
+	return string(text), true
+}
+
+func isDecodableCharset(charset string) bool {
+	switch charset {
+	case "", "binary", "utf8", "utf8mb3", "utf8mb4", "ascii", "latin1", "ucs2", "utf16", "utf16le", "utf32":
+		return true
+	}
+
+	_, ok := charsetEncodings[charset]
+
+	return ok
+}
+
+// The notice for rows of tables with text columns in a charset without decoder, whose
+// values are encoded like binary values instead
+func charsetNotice(tableMetadata database.TableMetadata) string {
+	var indexes []int
+
+	for index := range tableMetadata.Columns {
+		indexes = append(indexes, index)
+	}
+
+	sort.Ints(indexes)
+
+	var columns []string
+
+	for _, index := range indexes {
+		columnMetadata := tableMetadata.Columns[index]
+		columnType := strings.SplitN(columnMetadata.Type, "(", 2)[0]
+
+		if columnType == "enum" || columnType == "set" || isDecodableCharset(columnCharset(columnMetadata)) {
+			continue
+		}
+
+		columns = append(columns, fmt.Sprintf("%s (%s)", tableMetadata.Fields[index], columnCharset(columnMetadata)))
+	}
+
+	if len(columns) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("charset of column(s) %s can't be decoded, their values are binary encoded", strings.Join(columns, ", "))
+}
+
+// MySQL's latin1 is cp1252, with the 5 undefined bytes mapped to the control characters
+// of the same code point
+var latin1HighControls = [32]rune{
+	0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021, 0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+	0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, 0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+}
+
+func decodeLatin1(value []byte) string {
+	runes := make([]rune, len(value))
+
+	for i, b := range value {
+		if b >= 0x80 && b < 0xa0 {
+			runes[i] = latin1HighControls[b-0x80]
+		} else {
+			runes[i] = rune(b)
+		}
+	}
+
+	return string(runes)
+}
+
+func decodeUtf16(value []byte, byteOrder binary.ByteOrder) (string, bool) {
+	if len(value)%2 != 0 {
+		return "", false
+	}
+
+	units := make([]uint16, len(value)/2)
+
+	for i := range units {
+		units[i] = byteOrder.Uint16(value[2*i:])
+	}
+
+	return string(utf16.Decode(units)), true
+}
+
+func decodeUtf32(value []byte) (string, bool) {
+	if len(value)%4 != 0 {
+		return "", false
+	}
+
+	runes := make([]rune, len(value)/4)
+
+	for i := range runes {
+		runes[i] = rune(binary.BigEndian.Uint32(value[4*i:]))
+
+		if !utf8.ValidRune(runes[i]) {
+			return "", false
+		}
+	}
+
+	return string(runes), true
+}
+
+func encodeBinary(value []byte, binaryEncoding string) string {
+	if binaryEncoding == BINARY_ENCODING_HEX {
+		return hex.EncodeToString(value)
+	}
+
+	return base64.StdEncoding.EncodeToString(value)
+}
@@ -0,0 +1,126 @@
+package conversion
+
+import (
+	"fmt"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Column types as far as the table map event tells them, signedness and charsets are
+// taken from the table metadata
+func columnTypes(tableMapEvent *replication.TableMapEvent, tableMetadata database.TableMetadata) map[int]messages.MessageColumnType {
+	if tableMapEvent == nil {
+		return nil
+	}
+
+	types := make(map[int]messages.MessageColumnType)
+
+	for i, columnType := range tableMapEvent.ColumnType {
+		columnMetadata := tableMetadata.Columns[i]
+
+		charset := columnCharset(columnMetadata)
+
+		if isBinaryColumn(columnMetadata) {
+			charset = "binary"
+		}
+
+		messageColumnType := convertColumnType(columnType, tableMapEvent.ColumnMeta[i], charset)
+		messageColumnType.Position = i + 1
+		messageColumnType.Unsigned = columnMetadata.Unsigned
+		messageColumnType.Nullable = i/8 < len(tableMapEvent.NullBitmap) && tableMapEvent.NullBitmap[i/8]&(1<<uint(i%8)) != 0
+
+		types[i] = messageColumnType
+	}
+
+	return types
+}
+
+func convertColumnType(columnType byte, columnMeta uint16, charset string) messages.MessageColumnType {
+	switch columnType {
+	case mysql.MYSQL_TYPE_TINY:
+		return messages.MessageColumnType{Type: "TINYINT"}
+	case mysql.MYSQL_TYPE_SHORT:
+		return messages.MessageColumnType{Type: "SMALLINT"}
+	case mysql.MYSQL_TYPE_INT24:
+		return messages.MessageColumnType{Type: "MEDIUMINT"}
+	case mysql.MYSQL_TYPE_LONG:
+		return messages.MessageColumnType{Type: "INT"}
+	case mysql.MYSQL_TYPE_LONGLONG:
+		return messages.MessageColumnType{Type: "BIGINT"}
+	case mysql.MYSQL_TYPE_FLOAT:
+		return messages.MessageColumnType{Type: "FLOAT"}
+	case mysql.MYSQL_TYPE_DOUBLE:
+		return messages.MessageColumnType{Type: "DOUBLE"}
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		return messages.MessageColumnType{Type: "DECIMAL", Precision: int(columnMeta >> 8), Scale: int(columnMeta & 0xff)}
+	case mysql.MYSQL_TYPE_BIT:
+		return messages.MessageColumnType{Type: "BIT", Length: int(columnMeta>>8)*8 + int(columnMeta&0xff)}
+	case mysql.MYSQL_TYPE_YEAR:
+		return messages.MessageColumnType{Type: "YEAR"}
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		return messages.MessageColumnType{Type: "DATE"}
+	case mysql.MYSQL_TYPE_TIME:
+		return messages.MessageColumnType{Type: "TIME"}
+	case mysql.MYSQL_TYPE_TIME2:
+		return messages.MessageColumnType{Type: "TIME", Precision: int(columnMeta)}
+	case mysql.MYSQL_TYPE_DATETIME:
+		return messages.MessageColumnType{Type: "DATETIME"}
+	case mysql.MYSQL_TYPE_DATETIME2:
+		return messages.MessageColumnType{Type: "DATETIME", Precision: int(columnMeta)}
+	case mysql.MYSQL_TYPE_TIMESTAMP:
+		return messages.MessageColumnType{Type: "TIMESTAMP"}
+	case mysql.MYSQL_TYPE_TIMESTAMP2:
+		return messages.MessageColumnType{Type: "TIMESTAMP", Precision: int(columnMeta)}
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		return messages.MessageColumnType{Type: characterTypeName("VARCHAR", "VARBINARY", charset == "binary"), Length: int(columnMeta)}
+	case mysql.MYSQL_TYPE_STRING:
+		realType, length := StringColumnMeta(columnMeta)
+
+		switch realType {
+		case mysql.MYSQL_TYPE_ENUM:
+			return messages.MessageColumnType{Type: "ENUM"}
+		case mysql.MYSQL_TYPE_SET:
+			return messages.MessageColumnType{Type: "SET"}
+		}
+
+		return messages.MessageColumnType{Type: characterTypeName("CHAR", "BINARY", charset == "binary"), Length: length}
+	case mysql.MYSQL_TYPE_BLOB:
+		// the metadata is the size of the length prefix, TEXT columns are logged as BLOB
+		prefixes := map[uint16]string{1: "TINY", 2: "", 3: "MEDIUM", 4: "LONG"}
+		return messages.MessageColumnType{Type: prefixes[columnMeta] + characterTypeName("TEXT", "BLOB", charset == "" || charset == "binary")}
+	case mysql.MYSQL_TYPE_JSON:
+		return messages.MessageColumnType{Type: "JSON"}
+	case mysql.MYSQL_TYPE_GEOMETRY:
+		return messages.MessageColumnType{Type: "GEOMETRY"}
+	}
+
+	return messages.MessageColumnType{Type: fmt.Sprintf("(unknown_type_%d)", columnType)}
+}
+
+// The binlog doesn't tell text from binary columns, only the charset does if it is known
+func characterTypeName(textType string, binaryType string, binary bool) string {
+	if binary {
+		return binaryType
+	}
+
+	return textType
+}
+
+// The real type of a MYSQL_TYPE_STRING column is in the first byte of the metadata, with
+// bits of the length of long CHAR columns mixed in, see Field_string::do_save_field_metadata
+func StringColumnMeta(columnMeta uint16) (byte, int) {
+	if columnMeta < 256 {
+		return mysql.MYSQL_TYPE_STRING, int(columnMeta)
+	}
+
+	b0 := byte(columnMeta >> 8)
+	b1 := int(columnMeta & 0xff)
+
+	if b0&0x30 != 0x30 {
+		return b0 | 0x30, b1 | int((b0&0x30)^0x30)<<4
+	}
+
+	return b0, b1
+}
@@ -0,0 +1,69 @@
+// +build unit
+
+package conversion
+
+import (
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"testing"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestConvertColumnType(t *testing.T) {
+	testCases := []struct {
+		columnType byte
+		columnMeta uint16
+		charset    string
+		expected   messages.MessageColumnType
+	}{
+		{mysql.MYSQL_TYPE_LONG, 0, "", messages.MessageColumnType{Type: "INT"}},
+		{mysql.MYSQL_TYPE_NEWDECIMAL, 10<<8 | 2, "", messages.MessageColumnType{Type: "DECIMAL", Precision: 10, Scale: 2}},
+		{mysql.MYSQL_TYPE_BIT, 1<<8 | 2, "", messages.MessageColumnType{Type: "BIT", Length: 10}},
+		{mysql.MYSQL_TYPE_DATETIME2, 3, "", messages.MessageColumnType{Type: "DATETIME", Precision: 3}},
+		{mysql.MYSQL_TYPE_VARCHAR, 160, "utf8mb4", messages.MessageColumnType{Type: "VARCHAR", Length: 160}},
+		{mysql.MYSQL_TYPE_VARCHAR, 16, "binary", messages.MessageColumnType{Type: "VARBINARY", Length: 16}},
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_STRING)<<8 | 12, "", messages.MessageColumnType{Type: "CHAR", Length: 12}},
+		{mysql.MYSQL_TYPE_STRING, 0xde<<8 | 0xfd, "", messages.MessageColumnType{Type: "CHAR", Length: 765}}, // CHAR(255) in utf8
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, "", messages.MessageColumnType{Type: "ENUM"}},
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, "", messages.MessageColumnType{Type: "SET"}},
+		{mysql.MYSQL_TYPE_BLOB, 2, "", messages.MessageColumnType{Type: "BLOB"}},
+		{mysql.MYSQL_TYPE_BLOB, 4, "utf8", messages.MessageColumnType{Type: "LONGTEXT"}},
+		{mysql.MYSQL_TYPE_JSON, 4, "", messages.MessageColumnType{Type: "JSON"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected.Type, func(t *testing.T) {
+			columnType := convertColumnType(tc.columnType, tc.columnMeta, tc.charset)
+
+			if !reflect.DeepEqual(columnType, tc.expected) {
+				t.Fatalf("Wrong column type - expected %+v, got %+v", tc.expected, columnType)
+			}
+		})
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	tableMapEvent := &replication.TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 80},
+		NullBitmap:  []byte{0x02},
+	}
+
+	tableMetadata := database.TableMetadata{Columns: map[int]database.ColumnMetadata{0: {Unsigned: true}, 1: {Charset: 45}}}
+
+	expected := map[int]messages.MessageColumnType{
+		0: {Position: 1, Type: "INT", Unsigned: true},
+		1: {Position: 2, Type: "VARCHAR", Length: 80, Nullable: true},
+	}
+
+	if types := columnTypes(tableMapEvent, tableMetadata); !reflect.DeepEqual(types, expected) {
+		t.Fatalf("Wrong column types - got %+v", types)
+	}
+
+	if types := columnTypes(nil, tableMetadata); types != nil {
+		t.Fatalf("Expected no column types without table map event - got %+v", types)
+	}
+}
@@ -9,27 +9,36 @@ import (
 )
 
 type RowsEventData struct {
+	BinlogFile        string
 	BinlogEventHeader replication.EventHeader
 	BinlogEvent       replication.RowsEvent
 	TableMetadata     database.TableMetadata
 }
 
-func NewRowsEventData(binlogEventHeader replication.EventHeader, binlogEvent replication.RowsEvent, tableMetadata database.TableMetadata) RowsEventData {
+// With ColumnTypes, the type of each column is added to the row data. BinaryEncoding is
+// BINARY_ENCODING_BASE64 (the default if empty) or BINARY_ENCODING_HEX. With
+// RawEnumValues, ENUM and SET values are kept as their index and bitmask. DATETIME values
+// are taken to be in the DatetimeLocation, UTC if nil. With ChangedColumnsOnly, the rows
+// of update messages only hold the key columns and the columns changed by the update.
+type ConversionOptions struct {
+	ColumnTypes        bool
+	BinaryEncoding     string
+	RawEnumValues      bool
+	DatetimeLocation   *time.Location
+	ChangedColumnsOnly bool
+}
+
+func NewRowsEventData(binlogFile string, binlogEventHeader replication.EventHeader, binlogEvent replication.RowsEvent, tableMetadata database.TableMetadata) RowsEventData {
 	return RowsEventData{
+		BinlogFile:        binlogFile,
 		BinlogEventHeader: binlogEventHeader,
 		BinlogEvent:       binlogEvent,
 		TableMetadata:     tableMetadata,
 	}
 }
 
-func ConvertQueryEventToMessage(binlogEventHeader replication.EventHeader, binlogEvent replication.QueryEvent) messages.Message {
-	header := messages.NewMessageHeader(
-		string(binlogEvent.Schema),
-		"(unknown)",
-		time.Unix(int64(binlogEventHeader.Timestamp), 0),
-		binlogEventHeader.LogPos,
-		0,
-	)
+func ConvertQueryEventToMessage(binlogFile string, binlogEventHeader replication.EventHeader, binlogEvent replication.QueryEvent, gtid string) messages.Message {
+	header := messageHeader(string(binlogEvent.Schema), "(unknown)", binlogFile, binlogEventHeader, 0, gtid)
 
 	message := messages.NewQueryMessage(
 		header,
@@ -39,24 +48,36 @@ func ConvertQueryEventToMessage(binlogEventHeader replication.EventHeader, binlo
 	return messages.Message(message)
 }
 
-func ConvertRowsEventsToMessages(xId uint64, rowsEventsData []RowsEventData) []messages.Message {
+func ConvertRowsEventsToMessages(xId uint64, gtid string, rowsEventsData []RowsEventData, options ConversionOptions) []messages.Message {
 	var ret []messages.Message
 
 	for _, d := range rowsEventsData {
-		rowData := mapRowDataDataToColumnNames(d.BinlogEvent.Rows, d.TableMetadata.Fields)
+		var types map[int]messages.MessageColumnType
 
-		header := messages.NewMessageHeader(
-			d.TableMetadata.Schema,
-			d.TableMetadata.Table,
-			time.Unix(int64(d.BinlogEventHeader.Timestamp), 0),
-			d.BinlogEventHeader.LogPos,
-			xId,
-		)
+		if options.ColumnTypes {
+			types = columnTypes(d.BinlogEvent.Table, d.TableMetadata)
+		}
+
+		rows := convertRowValues(d.BinlogEvent.Rows, d.BinlogEvent.Table, d.TableMetadata, options)
+		rowData := mapRowDataDataToColumnNames(rows, d.TableMetadata.Fields, types, rowBitmaps(d.BinlogEvent))
+		keys := rowKeys(rows, d.TableMetadata)
+
+		if notice := charsetNotice(d.TableMetadata); notice != "" {
+			for i := range rowData {
+				if rowData[i].MappingNotice == "" {
+					rowData[i].MappingNotice = notice
+				} else {
+					rowData[i].MappingNotice += "; " + notice
+				}
+			}
+		}
+
+		header := messageHeader(d.TableMetadata.Schema, d.TableMetadata.Table, d.BinlogFile, d.BinlogEventHeader, xId, gtid)
 
 		switch d.BinlogEventHeader.EventType {
 		case replication.WRITE_ROWS_EVENTv1,
 			replication.WRITE_ROWS_EVENTv2:
-			for _, message := range createInsertMessagesFromRowData(header, rowData) {
+			for _, message := range createInsertMessagesFromRowData(header, rowData, keys) {
 				ret = append(ret, messages.Message(message))
 			}
 
@@ -64,7 +85,12 @@ func ConvertRowsEventsToMessages(xId uint64, rowsEventsData []RowsEventData) []m
 
 		case replication.UPDATE_ROWS_EVENTv1,
 			replication.UPDATE_ROWS_EVENTv2:
-			for _, message := range createUpdateMessagesFromRowData(header, rowData) {
+			for i, message := range createUpdateMessagesFromRowData(header, rowData, keys) {
+				if options.ChangedColumnsOnly {
+					oldRow, newRow := rows[2*i], rows[2*i+1]
+					message = onlyChangedColumns(message, changedColumns(oldRow, newRow, d.BinlogEvent), newRow, d.TableMetadata)
+				}
+
 				ret = append(ret, messages.Message(message))
 			}
 
@@ -72,7 +98,7 @@ func ConvertRowsEventsToMessages(xId uint64, rowsEventsData []RowsEventData) []m
 
 		case replication.DELETE_ROWS_EVENTv1,
 			replication.DELETE_ROWS_EVENTv2:
-			for _, message := range createDeleteMessagesFromRowData(header, rowData) {
+			for _, message := range createDeleteMessagesFromRowData(header, rowData, keys) {
 				ret = append(ret, messages.Message(message))
 			}
 
@@ -88,7 +114,32 @@ func ConvertRowsEventsToMessages(xId uint64, rowsEventsData []RowsEventData) []m
 	return ret
 }
 
-func createUpdateMessagesFromRowData(header messages.MessageHeader, rowData []messages.MessageRowData) []messages.UpdateMessage {
+// The begin header is the header of the event starting the transaction, the commit header
+// the one of the XID event or COMMIT query ending it. A transaction is always logged in a single binlog file.
+func ConvertTransactionToMessages(xId uint64, gtid string, binlogFile string, beginEventHeader replication.EventHeader, commitEventHeader replication.EventHeader, rowsEventsData []RowsEventData, options ConversionOptions) []messages.Message {
+	rowMessages := ConvertRowsEventsToMessages(xId, gtid, rowsEventsData, options)
+	commitTime := time.Unix(int64(commitEventHeader.Timestamp), 0)
+
+	begin := messages.NewTransactionBeginMessage(
+		messageHeader("", "", binlogFile, beginEventHeader, xId, gtid),
+		len(rowMessages),
+		commitTime,
+	)
+
+	commit := messages.NewTransactionCommitMessage(
+		messageHeader("", "", binlogFile, commitEventHeader, xId, gtid),
+		len(rowMessages),
+		commitTime,
+	)
+
+	ret := []messages.Message{messages.Message(begin)}
+	ret = append(ret, rowMessages...)
+
+	return append(ret, messages.Message(commit))
+}
+
+// The key of an update is the one of the row before the update
+func createUpdateMessagesFromRowData(header messages.MessageHeader, rowData []messages.MessageRowData, keys []messages.MessageRow) []messages.UpdateMessage {
 	if len(rowData)%2 != 0 {
 		panic("update rows should be old/new pairs") // should never happen as per mysql format
 	}
@@ -100,29 +151,37 @@ func createUpdateMessagesFromRowData(header messages.MessageHeader, rowData []me
 		if index%2 == 0 {
 			tmp = data
 		} else {
-			ret = append(ret, messages.NewUpdateMessage(header, tmp, data))
+			ret = append(ret, messages.NewUpdateMessage(header, keys[index-1], tmp, data))
 		}
 	}
 
 	return ret
 }
 
-func createInsertMessagesFromRowData(header messages.MessageHeader, rowData []messages.MessageRowData) []messages.InsertMessage {
+func createInsertMessagesFromRowData(header messages.MessageHeader, rowData []messages.MessageRowData, keys []messages.MessageRow) []messages.InsertMessage {
 	var ret []messages.InsertMessage
 
-	for _, data := range rowData {
-		ret = append(ret, messages.NewInsertMessage(header, data))
+	for index, data := range rowData {
+		ret = append(ret, messages.NewInsertMessage(header, keys[index], data))
 	}
 
 	return ret
 }
 
-func createDeleteMessagesFromRowData(header messages.MessageHeader, rowData []messages.MessageRowData) []messages.DeleteMessage {
+func createDeleteMessagesFromRowData(header messages.MessageHeader, rowData []messages.MessageRowData, keys []messages.MessageRow) []messages.DeleteMessage {
 	var ret []messages.DeleteMessage
 
-	for _, data := range rowData {
-		ret = append(ret, messages.NewDeleteMessage(header, data))
+	for index, data := range rowData {
+		ret = append(ret, messages.NewDeleteMessage(header, keys[index], data))
 	}
 
 	return ret
 }
+
+func messageHeader(schema, table, binlogFile string, binlogEventHeader replication.EventHeader, xId uint64, gtid string) messages.MessageHeader {
+	header := messages.NewMessageHeader(schema, table, time.Unix(int64(binlogEventHeader.Timestamp), 0), binlogEventHeader.LogPos, xId, gtid)
+	header.BinlogFile = binlogFile
+	header.ServerId = binlogEventHeader.ServerID
+
+	return header
+}
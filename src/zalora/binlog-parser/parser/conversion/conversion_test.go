@@ -15,24 +15,33 @@ import (
 func TestConvertQueryEventToMessage(t *testing.T) {
 	logPos := uint32(100)
 	query := "SELECT 1"
+	gtid := "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
 
-	eventHeader := replication.EventHeader{Timestamp: uint32(time.Now().Unix()), LogPos: logPos}
+	eventHeader := replication.EventHeader{Timestamp: uint32(time.Now().Unix()), LogPos: logPos, ServerID: 3}
 	queryEvent := replication.QueryEvent{Query: []byte(query)}
 
-	message := ConvertQueryEventToMessage(eventHeader, queryEvent)
+	message := ConvertQueryEventToMessage("mysql-bin.000001", eventHeader, queryEvent, gtid)
 
 	assertMessageHeader(t, message, logPos, messages.MESSAGE_TYPE_QUERY)
 
 	if string(message.(messages.QueryMessage).Query) != query {
 		t.Fatal("Unexpected value for query ")
 	}
+
+	if message.GetHeader().Gtid != gtid {
+		t.Fatal("Unexpected value for GTID")
+	}
+
+	if message.GetHeader().BinlogFile != "mysql-bin.000001" || message.GetHeader().ServerId != 3 {
+		t.Fatal("Unexpected value for binlog file or server id")
+	}
 }
 
 func TestConvertRowsEventsToMessages(t *testing.T) {
 	logPos := uint32(100)
 	xId := uint64(200)
 
-	tableMetadata := database.TableMetadata{"db_name", "table_name", map[int]string{0: "field_1", 1: "field_2"}}
+	tableMetadata := database.TableMetadata{Schema: "db_name", Table: "table_name", Fields: map[int]string{0: "field_1", 1: "field_2"}}
 
 	testCasesWriteRowsEvents := []struct {
 		eventType replication.EventType
@@ -45,9 +54,9 @@ func TestConvertRowsEventsToMessages(t *testing.T) {
 		t.Run("Insert message", func(t *testing.T) {
 			eventHeader := createEventHeader(logPos, tc.eventType)
 			rowsEvent := createRowsEvent([]interface{}{"value_1", "value_2"}, []interface{}{"value_3", "value_4"})
-			rowsEventData := []RowsEventData{NewRowsEventData(eventHeader, rowsEvent, tableMetadata)}
+			rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, tableMetadata)}
 
-			convertedMessages := ConvertRowsEventsToMessages(xId, rowsEventData)
+			convertedMessages := ConvertRowsEventsToMessages(xId, "", rowsEventData, ConversionOptions{})
 
 			if len(convertedMessages) != 2 {
 				t.Fatal("Expected 2 insert messages to be created")
@@ -81,9 +90,9 @@ func TestConvertRowsEventsToMessages(t *testing.T) {
 		t.Run("Delete message", func(t *testing.T) {
 			eventHeader := createEventHeader(logPos, tc.eventType)
 			rowsEvent := createRowsEvent([]interface{}{"value_1", "value_2"}, []interface{}{"value_3", "value_4"})
-			rowsEventData := []RowsEventData{NewRowsEventData(eventHeader, rowsEvent, tableMetadata)}
+			rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, tableMetadata)}
 
-			convertedMessages := ConvertRowsEventsToMessages(xId, rowsEventData)
+			convertedMessages := ConvertRowsEventsToMessages(xId, "", rowsEventData, ConversionOptions{})
 
 			if len(convertedMessages) != 2 {
 				t.Fatal("Expected 2 delete messages to be created")
@@ -117,9 +126,9 @@ func TestConvertRowsEventsToMessages(t *testing.T) {
 		t.Run("Update message", func(t *testing.T) {
 			eventHeader := createEventHeader(logPos, tc.eventType)
 			rowsEvent := createRowsEvent([]interface{}{"value_1", "value_2"}, []interface{}{"value_3", "value_4"})
-			rowsEventData := []RowsEventData{NewRowsEventData(eventHeader, rowsEvent, tableMetadata)}
+			rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, tableMetadata)}
 
-			convertedMessages := ConvertRowsEventsToMessages(xId, rowsEventData)
+			convertedMessages := ConvertRowsEventsToMessages(xId, "", rowsEventData, ConversionOptions{})
 
 			if len(convertedMessages) != 1 {
 				t.Fatal("Expected 1 update messages to be created")
@@ -139,12 +148,46 @@ func TestConvertRowsEventsToMessages(t *testing.T) {
 		})
 	}
 
+	t.Run("Key", func(t *testing.T) {
+		keyTableMetadata := tableMetadata
+		keyTableMetadata.PrimaryKey = []int{1}
+
+		eventHeader := createEventHeader(logPos, replication.UPDATE_ROWS_EVENTv2)
+		rowsEvent := createRowsEvent([]interface{}{"value_1", "value_2"}, []interface{}{"value_3", "value_4"})
+		rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, keyTableMetadata)}
+
+		updateMessage := ConvertRowsEventsToMessages(xId, "", rowsEventData, ConversionOptions{})[0].(messages.UpdateMessage)
+
+		if !reflect.DeepEqual(updateMessage.Key, messages.MessageRow{"field_2": "value_2"}) {
+			t.Fatalf("Wrong key for update message - got %v", updateMessage.Key)
+		}
+	})
+
+	t.Run("Charset without decoder", func(t *testing.T) {
+		charsetTableMetadata := tableMetadata
+		charsetTableMetadata.Columns = map[int]database.ColumnMetadata{0: {Type: "varchar(10)", CharacterSet: "utf8mb4"}, 1: {Type: "varchar(10)", CharacterSet: "armscii8"}}
+
+		eventHeader := createEventHeader(logPos, replication.WRITE_ROWS_EVENTv2)
+		rowsEvent := createRowsEvent([]interface{}{"value_1", "value_2"})
+		rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, charsetTableMetadata)}
+
+		insertMessage := ConvertRowsEventsToMessages(xId, "", rowsEventData, ConversionOptions{})[0].(messages.InsertMessage)
+
+		if insertMessage.Data.MappingNotice != "charset of column(s) field_2 (armscii8) can't be decoded, their values are binary encoded" {
+			t.Fatalf("Wrong mapping notice for insert message - got %q", insertMessage.Data.MappingNotice)
+		}
+
+		if insertMessage.Data.Row["field_2"] != "dmFsdWVfMg==" {
+			t.Fatalf("Expected binary encoded value - got %v", insertMessage.Data.Row["field_2"])
+		}
+	})
+
 	t.Run("Unknown event type", func(t *testing.T) {
 		eventHeader := createEventHeader(logPos, replication.RAND_EVENT) // can be any unkown event actually
 		rowsEvent := createRowsEvent()
-		rowsEventData := []RowsEventData{NewRowsEventData(eventHeader, rowsEvent, tableMetadata)}
+		rowsEventData := []RowsEventData{NewRowsEventData("mysql-bin.000001", eventHeader, rowsEvent, tableMetadata)}
 
-		convertedMessages := ConvertRowsEventsToMessages(xId, rowsEventData)
+		convertedMessages := ConvertRowsEventsToMessages(xId, "", rowsEventData, ConversionOptions{})
 
 		if len(convertedMessages) != 0 {
 			t.Fatal("Expected no messages to be created from unknown event")
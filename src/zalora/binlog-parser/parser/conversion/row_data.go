@@ -2,24 +2,44 @@ package conversion
 
 import (
 	"fmt"
+	"github.com/siddontang/go-mysql/replication"
+	"zalora/binlog-parser/database"
 	"zalora/binlog-parser/parser/messages"
 )
 
-func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]string) []messages.MessageRowData {
+// Column types are left out when nil. Row i holds the columns present in the bitmap
+// bitmaps[i % len(bitmaps)], the other columns are not logged and left out of the row.
+func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]string, columnTypes map[int]messages.MessageColumnType, bitmaps [][]byte) []messages.MessageRowData {
 	var mappedRows []messages.MessageRowData
 
-	for _, row := range rows {
+	for i, row := range rows {
+		var bitmap []byte
+		var notLogged []string
+
+		if len(bitmaps) > 0 {
+			bitmap = bitmaps[i%len(bitmaps)]
+		}
+
 		data := make(map[string]interface{})
 		unknownCount := 0
 
+		var types map[string]messages.MessageColumnType
+
+		if columnTypes != nil {
+			types = make(map[string]messages.MessageColumnType)
+		}
+
 		detectedMismatch, mismatchNotice := detectMismatch(row, columnNames)
 
 		for columnIndex, columnValue := range row {
+			var columnName string
+
 			if detectedMismatch {
-				data[fmt.Sprintf("(unknown_%d)", unknownCount)] = columnValue
+				columnName = fmt.Sprintf("(unknown_%d)", unknownCount)
 				unknownCount++
 			} else {
-				columnName, exists := columnNames[columnIndex]
+				var exists bool
+				columnName, exists = columnNames[columnIndex]
 
 				if !exists {
 					// This should actually never happen
@@ -27,20 +47,89 @@ func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]strin
 					panic(fmt.Sprintf("No mismatch between row and column names array detected, but column %s not found", columnName))
 				}
 
-				data[columnName] = columnValue
 			}
+
+			if columnType, ok := columnTypes[columnIndex]; ok && types != nil {
+				types[columnName] = columnType
+			}
+
+			if !isColumnPresent(bitmap, columnIndex) {
+				notLogged = append(notLogged, columnName)
+				continue
+			}
+
+			data[columnName] = columnValue
 		}
 
 		if detectedMismatch {
-			mappedRows = append(mappedRows, messages.MessageRowData{Row: data, MappingNotice: mismatchNotice})
+			mappedRows = append(mappedRows, messages.MessageRowData{Row: data, MappingNotice: mismatchNotice, Columns: types, NotLogged: notLogged})
 		} else {
-			mappedRows = append(mappedRows, messages.MessageRowData{Row: data})
+			mappedRows = append(mappedRows, messages.MessageRowData{Row: data, Columns: types, NotLogged: notLogged})
 		}
 	}
 
 	return mappedRows
 }
 
+// The column bitmaps of the rows of an event, the rows of updates alternate between the
+// image before the update and the one after it
+func rowBitmaps(rowsEvent replication.RowsEvent) [][]byte {
+	if rowsEvent.ColumnBitmap2 != nil {
+		return [][]byte{rowsEvent.ColumnBitmap1, rowsEvent.ColumnBitmap2}
+	}
+
+	return [][]byte{rowsEvent.ColumnBitmap1}
+}
+
+// A nil bitmap holds all columns
+func isColumnPresent(bitmap []byte, column int) bool {
+	if bitmap == nil {
+		return true
+	}
+
+	return column/8 < len(bitmap) && bitmap[column/8]&(1<<uint(column%8)) != 0
+}
+
+// The key of each row holds the values of the primary key, or of the first unique key
+// without NULL values in the row, as NULL values don't identify rows. Rows not matching
+// the column names have no key.
+func rowKeys(rows [][]interface{}, tableMetadata database.TableMetadata) []messages.MessageRow {
+	keys := make([]messages.MessageRow, len(rows))
+
+	for i, row := range rows {
+		if detectedMismatch, _ := detectMismatch(row, tableMetadata.Fields); detectedMismatch {
+			continue
+		}
+
+		for _, columns := range append([][]int{tableMetadata.PrimaryKey}, tableMetadata.UniqueKeys...) {
+			if key := rowKey(row, columns, tableMetadata.Fields); key != nil {
+				keys[i] = key
+				break
+			}
+		}
+	}
+
+	return keys
+}
+
+func rowKey(row []interface{}, columns []int, columnNames map[int]string) messages.MessageRow {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	key := make(messages.MessageRow)
+
+	for _, column := range columns {
+		if column >= len(row) || row[column] == nil {
+			return nil
+		}
+
+		key[columnNames[column]] = row[column]
+	}
+
+	return key
+}
+
 func detectMismatch(row []interface{}, columnNames map[int]string) (bool, string) {
 	if len(row) > len(columnNames) {
 		return true, fmt.Sprintf("column names array is missing field(s), will map them as unknown_*")
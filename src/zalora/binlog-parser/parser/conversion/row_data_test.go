@@ -1,8 +1,11 @@
 package conversion
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
 )
 
 func TestDetectMismatch(t *testing.T) {
@@ -58,3 +61,76 @@ func TestDetectMismatch(t *testing.T) {
 		}
 	})
 }
+
+func TestMapRowDataColumnTypes(t *testing.T) {
+	rows := [][]interface{}{{int32(1), "value"}}
+	columnTypes := map[int]messages.MessageColumnType{0: {Type: "INT"}, 1: {Type: "VARCHAR", Length: 80}}
+
+	t.Run("Column types by field name", func(t *testing.T) {
+		rowData := mapRowDataDataToColumnNames(rows, map[int]string{0: "id", 1: "name"}, columnTypes, nil)
+		expected := map[string]messages.MessageColumnType{"id": {Type: "INT"}, "name": {Type: "VARCHAR", Length: 80}}
+
+		if !reflect.DeepEqual(rowData[0].Columns, expected) {
+			t.Fatalf("Wrong column types - got %v", rowData[0].Columns)
+		}
+	})
+
+	t.Run("Column types of unknown fields", func(t *testing.T) {
+		rowData := mapRowDataDataToColumnNames(rows, map[int]string{0: "id"}, columnTypes, nil)
+
+		if rowData[0].Columns["(unknown_1)"].Type != "VARCHAR" {
+			t.Fatalf("Wrong column types - got %v", rowData[0].Columns)
+		}
+	})
+
+	t.Run("No column types", func(t *testing.T) {
+		rowData := mapRowDataDataToColumnNames(rows, map[int]string{0: "id", 1: "name"}, nil, nil)
+
+		if rowData[0].Columns != nil {
+			t.Fatalf("Expected no column types - got %v", rowData[0].Columns)
+		}
+	})
+}
+
+func TestRowKeys(t *testing.T) {
+	tableMetadata := database.TableMetadata{Fields: map[int]string{0: "id", 1: "code", 2: "name"}, UniqueKeys: [][]int{{1}, {2, 0}}}
+
+	testCases := []struct {
+		name        string
+		primaryKey  []int
+		row         []interface{}
+		expectedKey messages.MessageRow
+	}{
+		{"Primary key", []int{0}, []interface{}{1, nil, "a"}, messages.MessageRow{"id": 1}},
+		{"Unique key", nil, []interface{}{1, "x", "a"}, messages.MessageRow{"code": "x"}},
+		{"Unique key with NULL values", nil, []interface{}{1, nil, "a"}, messages.MessageRow{"name": "a", "id": 1}},
+		{"No key without NULL values", nil, []interface{}{1, nil, nil}, nil},
+		{"Mismatch", []int{0}, []interface{}{1, nil}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tableMetadata.PrimaryKey = tc.primaryKey
+
+			if keys := rowKeys([][]interface{}{tc.row}, tableMetadata); !reflect.DeepEqual(keys[0], tc.expectedKey) {
+				t.Fatalf("Wrong key - got %v", keys[0])
+			}
+		})
+	}
+}
+
+func TestMapRowDataNotLogged(t *testing.T) {
+	rows := [][]interface{}{{int32(1), nil, nil}, {nil, nil, "value"}}
+	bitmaps := [][]byte{{0x03}, {0x06}}
+
+	rowData := mapRowDataDataToColumnNames(rows, map[int]string{0: "id", 1: "name", 2: "blob"}, nil, bitmaps)
+
+	expected := []messages.MessageRowData{
+		{Row: messages.MessageRow{"id": int32(1), "name": nil}, NotLogged: []string{"blob"}},
+		{Row: messages.MessageRow{"name": nil, "blob": "value"}, NotLogged: []string{"id"}},
+	}
+
+	if !reflect.DeepEqual(rowData, expected) {
+		t.Fatalf("Wrong row data - got %v", rowData)
+	}
+}
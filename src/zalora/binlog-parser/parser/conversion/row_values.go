@@ -0,0 +1,215 @@
+package conversion
+
+import (
+	"encoding/json"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"strings"
+	"zalora/binlog-parser/database"
+)
+
+// Converts the values of rows as decoded by go-mysql according to the table metadata
+func convertRowValues(rows [][]interface{}, tableMapEvent *replication.TableMapEvent, tableMetadata database.TableMetadata, options ConversionOptions) [][]interface{} {
+	converted := make([][]interface{}, len(rows))
+
+	for i, row := range rows {
+		converted[i] = make([]interface{}, len(row))
+
+		for columnIndex, value := range row {
+			converted[i][columnIndex] = convertValue(value, tableMapEvent, columnIndex, tableMetadata.Columns[columnIndex], options)
+		}
+	}
+
+	return converted
+}
+
+// Values are output as follows, NULL as null:
+//
+//	integers, FLOAT, DOUBLE, BIT  numbers, unsigned if the column is known to be unsigned
+//	DECIMAL                       exact strings with the scale of the column, e.g. "1234.50"
+//	DATETIME                      ISO-8601 in the DatetimeLocation, e.g. "2017-04-13T06:34:30.123Z"
+//	TIMESTAMP                     ISO-8601 in UTC, e.g. "2017-04-13T06:34:30.123Z"
+//	DATE                          ISO-8601, e.g. "2017-04-13"
+//	TIME                          ISO-8601 durations, e.g. "PT12H30M5S" or "-PT1H0M0.500S"
+//	YEAR                          numbers
+//	ENUM, SET                     labels, see enumOrSetLabels
+//	strings, TEXT                 strings, binary values base64 or hex encoded, see charset.go
+//	JSON                          nested JSON
+//
+// Fractional seconds have as many digits as the precision of the column. Zero dates and
+// dates with a zero month or day are null, as ISO-8601 has no such dates.
+func convertValue(value interface{}, tableMapEvent *replication.TableMapEvent, columnIndex int, columnMetadata database.ColumnMetadata, options ConversionOptions) interface{} {
+	columnType := columnType(tableMapEvent, columnIndex)
+
+	if columnMetadata.Unsigned {
+		value = unsignedValue(value, columnType)
+	}
+
+	if realType, packLength := enumOrSetType(tableMapEvent, columnIndex, columnMetadata); realType != mysql.MYSQL_TYPE_NULL {
+		value = littleEndianValue(value, packLength)
+
+		if options.RawEnumValues {
+			return value
+		}
+
+		return enumOrSetLabels(value, realType, columnMetadata.Values)
+	}
+
+	if value == nil {
+		return nil
+	}
+
+	var columnMeta uint16
+
+	if tableMapEvent != nil && columnIndex < len(tableMapEvent.ColumnMeta) {
+		columnMeta = tableMapEvent.ColumnMeta[columnIndex]
+	}
+
+	switch columnType {
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		return decimalValue(value, int(columnMeta&0xff))
+	case mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+		return datetimeValue(value, int(columnMeta), options.DatetimeLocation)
+	case mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2:
+		return timestampValue(value, int(columnMeta))
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		return dateValue(value)
+	case mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		return timeValue(value, int(columnMeta))
+	case mysql.MYSQL_TYPE_YEAR:
+		return yearValue(value)
+	case mysql.MYSQL_TYPE_JSON:
+		return jsonValue(value)
+	}
+
+	if isCharacterColumnType(columnType) {
+		return convertCharacterValue(value, columnType, columnMetadata, options.BinaryEncoding)
+	}
+
+	return value
+}
+
+func columnType(tableMapEvent *replication.TableMapEvent, columnIndex int) byte {
+	if tableMapEvent == nil || columnIndex >= len(tableMapEvent.ColumnType) {
+		return mysql.MYSQL_TYPE_NULL
+	}
+
+	return tableMapEvent.ColumnType[columnIndex]
+}
+
+// ENUM and SET columns are logged as MYSQL_TYPE_STRING too, but decoded to integers. The
+// column type is unknown without the table map event.
+func isCharacterColumnType(columnType byte) bool {
+	switch columnType {
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_NULL:
+		return true
+	}
+
+	return false
+}
+
+// ENUM and SET columns are told apart by the metadata of the table map event, which also
+// has the number of bytes of their values, or by the column type from the schema
+func enumOrSetType(tableMapEvent *replication.TableMapEvent, columnIndex int, columnMetadata database.ColumnMetadata) (byte, int) {
+	if columnType(tableMapEvent, columnIndex) == mysql.MYSQL_TYPE_STRING && columnIndex < len(tableMapEvent.ColumnMeta) {
+		if realType, packLength := StringColumnMeta(tableMapEvent.ColumnMeta[columnIndex]); realType == mysql.MYSQL_TYPE_ENUM || realType == mysql.MYSQL_TYPE_SET {
+			return realType, packLength
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(columnMetadata.Type, "enum("):
+		return mysql.MYSQL_TYPE_ENUM, 0
+	case strings.HasPrefix(columnMetadata.Type, "set("):
+		return mysql.MYSQL_TYPE_SET, 0
+	}
+
+	return mysql.MYSQL_TYPE_NULL, 0
+}
+
+// go-mysql decodes ENUM values of more than 255 labels and SET values of more than 8
+// labels big-endian, while they are stored little-endian
+func littleEndianValue(value interface{}, packLength int) interface{} {
+	v, ok := value.(int64)
+
+	if !ok || packLength < 2 || packLength > 8 {
+		return value
+	}
+
+	var swapped int64
+
+	for i := 0; i < packLength; i++ {
+		swapped = swapped<<8 | v>>uint(8*i)&0xff
+	}
+
+	return swapped
+}
+
+// ENUM values are stored as the index of the label starting at 1, with 0 for the empty
+// string of invalid values, and SET values as a bitmask of the labels. Values are kept as
+// they are if the labels aren't known.
+func enumOrSetLabels(value interface{}, realType byte, labels []string) interface{} {
+	index, ok := value.(int64)
+
+	if !ok || labels == nil {
+		return value
+	}
+
+	if realType == mysql.MYSQL_TYPE_ENUM {
+		if index == 0 {
+			return ""
+		}
+
+		if index > int64(len(labels)) {
+			return value
+		}
+
+		return labels[index-1]
+	}
+
+	setLabels := []string{}
+
+	for i, label := range labels {
+		if uint64(index)&(1<<uint(i)) != 0 {
+			setLabels = append(setLabels, label)
+		}
+	}
+
+	return setLabels
+}
+
+// JSON values are decoded by go-mysql to JSON text, which is embedded as is. A JSON column
+// can hold an empty value instead of NULL.
+func jsonValue(value interface{}) interface{} {
+	data, ok := value.([]byte)
+
+	if !ok {
+		return value
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.RawMessage(data)
+}
+
+// Integers are decoded as signed, MEDIUMINT is sign extended to an int32
+func unsignedValue(value interface{}, columnType byte) interface{} {
+	switch v := value.(type) {
+	case int8:
+		return uint8(v)
+	case int16:
+		return uint16(v)
+	case int32:
+		if columnType == mysql.MYSQL_TYPE_INT24 {
+			return uint32(v) & 0xffffff
+		}
+
+		return uint32(v)
+	case int64:
+		return uint64(v)
+	}
+
+	return value
+}
@@ -0,0 +1,165 @@
+// +build unit
+
+package conversion
+
+import (
+	"encoding/json"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"testing"
+	"time"
+	"zalora/binlog-parser/database"
+)
+
+func TestConvertRowValues(t *testing.T) {
+	tableMapEvent := &replication.TableMapEvent{
+		ColumnType: []byte{mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+	}
+
+	unsigned := database.ColumnMetadata{Unsigned: true}
+	tableMetadata := database.TableMetadata{Columns: map[int]database.ColumnMetadata{0: unsigned, 1: unsigned, 2: unsigned, 3: unsigned, 4: unsigned, 6: unsigned}}
+
+	rows := [][]interface{}{
+		{int8(-1), int16(-2), int32(-3), int32(-2147483648), int64(-1), int32(-5), "text"},
+		{int8(1), nil, int32(3), int32(4), int64(5), int32(6), nil},
+	}
+
+	expected := [][]interface{}{
+		{uint8(255), uint16(65534), uint32(16777213), uint32(2147483648), uint64(18446744073709551615), int32(-5), "text"},
+		{uint8(1), nil, uint32(3), uint32(4), uint64(5), int32(6), nil},
+	}
+
+	if converted := convertRowValues(rows, tableMapEvent, tableMetadata, ConversionOptions{}); !reflect.DeepEqual(converted, expected) {
+		t.Fatalf("Wrong unsigned values - got %v", converted)
+	}
+
+	if rows[0][0] != int8(-1) {
+		t.Fatal("Expected rows of the event to be left unchanged")
+	}
+}
+
+func TestConvertRowValuesCharsets(t *testing.T) {
+	testCases := []struct {
+		name           string
+		columnType     byte
+		columnMetadata database.ColumnMetadata
+		binaryEncoding string
+		value          interface{}
+		expected       interface{}
+	}{
+		{"utf8mb4", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{Charset: 255}, "", "Grüße", "Grüße"},
+		{"latin1", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "latin1"}, "", "Gr\xfc\xdfe \x80", "Grüße €"},
+		{"latin1 collation id", mysql.MYSQL_TYPE_STRING, database.ColumnMetadata{Charset: 8}, "", "caf\xe9", "café"},
+		{"utf16", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "utf16"}, "", "\x00h\x00\xe9", "hé"},
+		{"utf16le", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "utf16le"}, "", "h\x00\xe9\x00", "hé"},
+		{"utf32", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "utf32"}, "", "\x00\x00\x00h", "h"},
+		{"cp1251", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "cp1251"}, "", "\xcf\xf0\xe8\xe2\xe5\xf2", "Привет"},
+		{"gbk collation id", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{Charset: 28}, "", "\xc4\xe3\xba\xc3", "你好"},
+		{"sjis", mysql.MYSQL_TYPE_STRING, database.ColumnMetadata{CharacterSet: "sjis"}, "", "\x82\xb1\x82\xf1", "こん"},
+		{"big5", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "big5"}, "", "\xa7\x41", "你"},
+		{"Invalid gbk", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "gbk"}, BINARY_ENCODING_HEX, "\x81", "81"},
+		{"Charset without decoder", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "armscii8"}, BINARY_ENCODING_HEX, "ab", "6162"},
+		{"TEXT", mysql.MYSQL_TYPE_BLOB, database.ColumnMetadata{Type: "text", CharacterSet: "utf8"}, "", []byte("text"), "text"},
+		{"BLOB base64", mysql.MYSQL_TYPE_BLOB, database.ColumnMetadata{Type: "blob"}, "", []byte{0xff, 0x00}, "/wA="},
+		{"BLOB hex", mysql.MYSQL_TYPE_BLOB, database.ColumnMetadata{Type: "blob"}, BINARY_ENCODING_HEX, []byte{0xff, 0x00}, "ff00"},
+		{"BLOB without schema", mysql.MYSQL_TYPE_BLOB, database.ColumnMetadata{}, "", []byte("text"), "dGV4dA=="},
+		{"VARBINARY", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{Charset: 63}, BINARY_ENCODING_HEX, "ab", "6162"},
+		{"Invalid utf8", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{}, "", "\xff", "/w=="},
+		{"NULL", mysql.MYSQL_TYPE_VARCHAR, database.ColumnMetadata{CharacterSet: "latin1"}, "", nil, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tableMapEvent := &replication.TableMapEvent{ColumnType: []byte{tc.columnType}}
+			tableMetadata := database.TableMetadata{Columns: map[int]database.ColumnMetadata{0: tc.columnMetadata}}
+
+			converted := convertRowValues([][]interface{}{{tc.value}}, tableMapEvent, tableMetadata, ConversionOptions{BinaryEncoding: tc.binaryEncoding})
+
+			if !reflect.DeepEqual(converted[0][0], tc.expected) {
+				t.Fatalf("Wrong value - expected %#v, got %#v", tc.expected, converted[0][0])
+			}
+		})
+	}
+}
+
+func TestConvertRowValuesEnumsAndSets(t *testing.T) {
+	tableMapEvent := &replication.TableMapEvent{
+		ColumnType: []byte{mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+		ColumnMeta: []uint16{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1},
+	}
+
+	tableMetadata := database.TableMetadata{Columns: map[int]database.ColumnMetadata{
+		0: {Type: "enum('new','shipped')", CharacterSet: "latin1", Values: []string{"new", "shipped"}},
+		1: {Type: "set('a','b','c')", Values: []string{"a", "b", "c"}},
+	}}
+
+	rows := [][]interface{}{
+		{int64(2), int64(5), int64(1)},
+		{int64(0), int64(0), nil},
+		{int64(3), int64(8), int64(2)},
+		{nil, nil, int64(1)},
+	}
+
+	testCases := []struct {
+		name     string
+		options  ConversionOptions
+		expected [][]interface{}
+	}{
+		{
+			"Labels",
+			ConversionOptions{},
+			[][]interface{}{
+				{"shipped", []string{"a", "c"}, int64(1)},
+				{"", []string{}, nil},
+				{int64(3), []string{}, int64(2)},
+				{nil, nil, int64(1)},
+			},
+		},
+		{"Raw values", ConversionOptions{RawEnumValues: true}, rows},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if converted := convertRowValues(rows, tableMapEvent, tableMetadata, tc.options); !reflect.DeepEqual(converted, tc.expected) {
+				t.Fatalf("Wrong ENUM and SET values - got %v", converted)
+			}
+		})
+	}
+
+	t.Run("Without table map event", func(t *testing.T) {
+		converted := convertRowValues([][]interface{}{{int64(1), int64(3)}}, nil, tableMetadata, ConversionOptions{})
+
+		if expected := []interface{}{"new", []string{"a", "b"}}; !reflect.DeepEqual(converted[0], expected) {
+			t.Fatalf("Wrong ENUM and SET values - got %v", converted[0])
+		}
+	})
+}
+
+func TestConvertRowValuesJson(t *testing.T) {
+	tableMapEvent := &replication.TableMapEvent{ColumnType: []byte{mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_JSON}}
+	rows := [][]interface{}{{[]byte(`{"a":[1,"b"]}`), []byte{}, nil}}
+
+	expected := []interface{}{json.RawMessage(`{"a":[1,"b"]}`), nil, nil}
+
+	if converted := convertRowValues(rows, tableMapEvent, database.TableMetadata{}, ConversionOptions{}); !reflect.DeepEqual(converted[0], expected) {
+		t.Fatalf("Wrong JSON values - got %v", converted[0])
+	}
+}
+
+func TestConvertRowValuesDatetimeLocation(t *testing.T) {
+	tableMapEvent := &replication.TableMapEvent{
+		ColumnType: []byte{mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_TIMESTAMP2},
+		ColumnMeta: []uint16{3, 0},
+	}
+
+	location := time.FixedZone("UTC+8", 8*60*60)
+	datetime := time.Date(2017, 4, 13, 6, 34, 30, 5000000, time.UTC)
+	rows := [][]interface{}{{datetime, datetime.In(location)}}
+
+	expected := []interface{}{"2017-04-13T06:34:30.005+08:00", "2017-04-13T06:34:30Z"}
+
+	if converted := convertRowValues(rows, tableMapEvent, database.TableMetadata{}, ConversionOptions{DatetimeLocation: location}); !reflect.DeepEqual(converted[0], expected) {
+		t.Fatalf("Wrong datetime values - got %v", converted[0])
+	}
+}
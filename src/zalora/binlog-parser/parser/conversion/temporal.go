@@ -0,0 +1,138 @@
+package conversion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decimals are decoded by go-mysql as shopspring/decimal values
+type fixedDecimal interface {
+	StringFixed(places int32) string
+}
+
+func decimalValue(value interface{}, scale int) interface{} {
+	switch v := value.(type) {
+	case fixedDecimal:
+		return v.StringFixed(int32(scale))
+	case float64:
+		return strconv.FormatFloat(v, 'f', scale, 64)
+	}
+
+	return value
+}
+
+// DATETIMEs are decoded as wall clock times in UTC, the binlog doesn't say in which time
+// zone they are meant.
+func datetimeValue(value interface{}, fsp int, location *time.Location) interface{} {
+	t, ok := value.(time.Time)
+
+	if !ok {
+		return zeroDateValue(value)
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	return formatTime(time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), location), fsp)
+}
+
+// TIMESTAMPs are decoded as times in the local time zone
+func timestampValue(value interface{}, fsp int) interface{} {
+	t, ok := value.(time.Time)
+
+	if !ok {
+		return zeroDateValue(value)
+	}
+
+	return formatTime(t.UTC(), fsp)
+}
+
+func formatTime(t time.Time, fsp int) string {
+	layout := "2006-01-02T15:04:05"
+
+	if fsp > 0 {
+		layout += "." + strings.Repeat("0", fsp)
+	}
+
+	return t.Format(layout + "Z07:00")
+}
+
+// Zero dates and dates with a zero month or day are decoded as strings like
+// 2017-04-00 10:00:00
+func zeroDateValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok && len(s) >= 10 && (s[5:7] == "00" || s[8:10] == "00") {
+		return nil
+	}
+
+	return value
+}
+
+// DATEs are decoded as strings like 2017-04-13
+func dateValue(value interface{}) interface{} {
+	s, ok := value.(string)
+
+	if !ok {
+		return value
+	}
+
+	if parts := strings.Split(s, "-"); len(parts) == 3 && (parts[1] == "00" || parts[2] == "00") {
+		return nil
+	}
+
+	return s
+}
+
+// TIME(fsp) values are decoded as durations by the event decoder, the TIME values of
+// MySQL before 5.6.4 by go-mysql as strings like 12:30:05
+func timeValue(value interface{}, fsp int) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return formatDuration(v, fsp)
+	case string:
+		var hours, minutes, seconds int
+
+		if _, err := fmt.Sscanf(strings.TrimPrefix(v, "-"), "%d:%d:%d", &hours, &minutes, &seconds); err != nil {
+			return value
+		}
+
+		duration := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+
+		if strings.HasPrefix(v, "-") {
+			duration = -duration
+		}
+
+		return formatDuration(duration, fsp)
+	}
+
+	return value
+}
+
+func formatDuration(duration time.Duration, fsp int) string {
+	sign := ""
+
+	if duration < 0 {
+		sign = "-"
+		duration = -duration
+	}
+
+	seconds := strconv.Itoa(int(duration / time.Second % 60))
+
+	if fsp > 0 {
+		seconds += fmt.Sprintf(".%06d", int(duration%time.Second/time.Microsecond))[:fsp+1]
+	}
+
+	return fmt.Sprintf("%sPT%dH%dM%sS", sign, int(duration/time.Hour), int(duration/time.Minute%60), seconds)
+}
+
+// The year 0000 is stored as 0, which go-mysql decodes as 1900 like the other years
+// stored as the offset from 1900
+func yearValue(value interface{}) interface{} {
+	if year, ok := value.(int); ok && year == 1900 {
+		return 0
+	}
+
+	return value
+}
@@ -0,0 +1,215 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Columns written before the columns of the table. _not_logged lists the columns missing
+// from the row, which are written as NULL.
+var csvMetaColumns = []string{"_operation", "_binlog_position", "_timestamp", "_xid", "_not_logged"}
+
+type csvTable struct {
+	columns []string
+	file    *os.File
+}
+
+type csvCollector struct {
+	dir    string
+	tsv    bool
+	tables map[string]*csvTable
+}
+
+// Each row goes to the file of its table, a new version of which starts whenever the
+// columns of the table change, e.g. after an ALTER TABLE. Updates are written as the row
+// after the update, deletes as the row before the delete.
+func (c *csvCollector) collect(message messages.Message) error {
+	data := rowMessageData(message)
+
+	if data == nil {
+		return nil
+	}
+
+	header := message.GetHeader()
+
+	if data.Columns == nil {
+		return fmt.Errorf("Can't write rows of table %s.%s without column types", header.Schema, header.Table)
+	}
+
+	name := header.Schema + "." + header.Table
+	columns := csvColumns(data.Columns)
+	table, ok := c.tables[name]
+
+	if !ok || !reflect.DeepEqual(table.columns, columns) {
+		if ok {
+			table.file.Close()
+		}
+
+		var err error
+
+		if table, err = c.newTable(header.Schema, header.Table, columns); err != nil {
+			glog.Errorf("Failed to start file version of table %s: %s", name, err)
+			return err
+		}
+
+		c.tables[name] = table
+	}
+
+	var values []interface{}
+	var notLogged []string
+
+	for _, column := range columns {
+		value, ok := data.Row[column]
+
+		if !ok {
+			notLogged = append(notLogged, column)
+		}
+
+		values = append(values, value)
+	}
+
+	fields := []interface{}{string(message.GetType()), header.BinlogPosition, header.BinlogMessageTime, header.XId, nil}
+
+	if notLogged != nil {
+		fields[4] = notLogged
+	}
+
+	fields = append(fields, values...)
+
+	if _, err := table.file.WriteString(c.line(fields)); err != nil {
+		glog.Errorf("Failed to write row to file %s: %s", table.file.Name(), err)
+		return err
+	}
+
+	return nil
+}
+
+// The files of a table are named schema.table.n.csv (or .tsv) with the first n not taken
+// yet, and start with a header line naming the columns
+func (c *csvCollector) newTable(schema, table string, columns []string) (*csvTable, error) {
+	extension := "csv"
+
+	if c.tsv {
+		extension = "tsv"
+	}
+
+	for n := 1; ; n++ {
+		filename := filepath.Join(c.dir, fmt.Sprintf("%s.%s.%d.%s", schema, table, n, extension))
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+		if os.IsExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		glog.V(1).Infof("Writing rows of table %s.%s to %s", schema, table, filename)
+
+		var names []interface{}
+
+		for _, column := range append(csvMetaColumns, columns...) {
+			names = append(names, column)
+		}
+
+		if _, err := file.WriteString(c.line(names)); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return &csvTable{columns, file}, nil
+	}
+}
+
+func (c *csvCollector) close() error {
+	var err error
+
+	for _, table := range c.tables {
+		if closeErr := table.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	c.tables = make(map[string]*csvTable)
+
+	return err
+}
+
+// NULL is written like COPY reads it, as an unquoted empty field in CSV files and as \N in
+// TSV files. Fields of TSV files are escaped like COPY's text format, and like the default
+// format of LOAD DATA.
+func (c *csvCollector) line(fields []interface{}) string {
+	var line strings.Builder
+
+	for i, field := range fields {
+		if i > 0 && c.tsv {
+			line.WriteString("\t")
+		} else if i > 0 {
+			line.WriteString(",")
+		}
+
+		value, null := csvValue(field)
+
+		switch {
+		case null && c.tsv:
+			line.WriteString(`\N`)
+		case null:
+		case c.tsv:
+			line.WriteString(tsvEscaper.Replace(value))
+		case value == "" || strings.ContainsAny(value, ",\"\r\n") || value == `\.`:
+			line.WriteString(`"` + strings.Replace(value, `"`, `""`, -1) + `"`)
+		default:
+			line.WriteString(value)
+		}
+	}
+
+	line.WriteString("\n")
+
+	return line.String()
+}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// Values are written in their output format, SET values as comma-separated lists
+func csvValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, false
+	case []string:
+		return strings.Join(v, ","), false
+	case json.RawMessage:
+		return string(v), false
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), false
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), false
+	}
+
+	return fmt.Sprint(value), false
+}
+
+// The columns of a table in table order, including the ones not logged
+func csvColumns(columnTypes map[string]messages.MessageColumnType) []string {
+	var columns []string
+
+	for column := range columnTypes {
+		columns = append(columns, column)
+	}
+
+	sort.Slice(columns, func(i, j int) bool {
+		return columnTypes[columns[i]].Position < columnTypes[columns[j]].Position
+	})
+
+	return columns
+}
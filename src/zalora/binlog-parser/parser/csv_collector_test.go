@@ -0,0 +1,102 @@
+// +build unit
+
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestConsumerChainCollectAsCsv(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "t", time.Unix(1492065270, 0), 100, 9, "")
+	columns := map[string]messages.MessageColumnType{"id": {Position: 1, Type: "INT"}, "name": {Position: 2, Type: "VARCHAR"}}
+	altered := map[string]messages.MessageColumnType{"id": {Position: 1, Type: "INT"}, "tags": {Position: 2, Type: "SET"}, "name": {Position: 3, Type: "VARCHAR"}}
+
+	row := messages.MessageRowData{Row: messages.MessageRow{"id": int32(1), "name": "Say \"hi\",\tthen\nleave"}, Columns: columns}
+	updated := messages.MessageRowData{Row: messages.MessageRow{"id": int32(1), "name": ""}, Columns: columns}
+	nullName := messages.MessageRowData{Row: messages.MessageRow{"id": int32(1), "name": nil}, Columns: columns}
+	notLogged := messages.MessageRowData{Row: messages.MessageRow{"id": int32(2), "tags": []string{"a", "b"}}, Columns: altered, NotLogged: []string{"name"}}
+
+	rowMessages := []messages.Message{
+		messages.NewInsertMessage(header, nil, row),
+		messages.NewUpdateMessage(header, nil, row, updated),
+		messages.NewQueryMessage(header, "ALTER TABLE t ADD tags SET('a', 'b') AFTER id"),
+		messages.NewDeleteMessage(header, nil, nullName),
+		messages.NewInsertMessage(header, nil, notLogged),
+	}
+
+	testCases := []struct {
+		name     string
+		tsv      bool
+		expected map[string]string
+	}{
+		{
+			"CSV",
+			false,
+			map[string]string{
+				"test_db.t.2.csv": "_operation,_binlog_position,_timestamp,_xid,_not_logged,id,name\n" +
+					"Insert,100,2017-04-13T06:34:30Z,9,,1,\"Say \"\"hi\"\",\tthen\nleave\"\n" +
+					"Update,100,2017-04-13T06:34:30Z,9,,1,\"\"\n" +
+					"Delete,100,2017-04-13T06:34:30Z,9,,1,\n",
+				"test_db.t.3.csv": "_operation,_binlog_position,_timestamp,_xid,_not_logged,id,tags,name\n" +
+					"Insert,100,2017-04-13T06:34:30Z,9,name,2,\"a,b\",\n",
+			},
+		},
+		{
+			"TSV",
+			true,
+			map[string]string{
+				"test_db.t.2.tsv": "_operation\t_binlog_position\t_timestamp\t_xid\t_not_logged\tid\tname\n" +
+					"Insert\t100\t2017-04-13T06:34:30Z\t9\t\\N\t1\tSay \"hi\",\\tthen\\nleave\n" +
+					"Update\t100\t2017-04-13T06:34:30Z\t9\t\\N\t1\t\n" +
+					"Delete\t100\t2017-04-13T06:34:30Z\t9\t\\N\t1\t\\N\n",
+				"test_db.t.3.tsv": "_operation\t_binlog_position\t_timestamp\t_xid\t_not_logged\tid\ttags\tname\n" +
+					"Insert\t100\t2017-04-13T06:34:30Z\t9\tname\t2\ta,b\t\\N\n",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "csv")
+			defer os.RemoveAll(dir)
+
+			ioutil.WriteFile(filepath.Join(dir, "test_db.t.1.csv"), nil, 0644)
+			ioutil.WriteFile(filepath.Join(dir, "test_db.t.1.tsv"), nil, 0644)
+
+			chain := NewConsumerChain()
+			chain.CollectAsCsv(dir, tc.tsv)
+
+			for _, message := range rowMessages {
+				if err := chain.consumeMessage(message); err != nil {
+					t.Fatalf("Expected no error when consuming message, got %s", err)
+				}
+			}
+
+			if err := chain.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for filename, expected := range tc.expected {
+				data, err := ioutil.ReadFile(filepath.Join(dir, filename))
+
+				if err != nil || string(data) != expected {
+					t.Fatalf("Expected %s to be %q, got %q (%v)", filename, expected, data, err)
+				}
+			}
+		})
+	}
+
+	t.Run("Without column types", func(t *testing.T) {
+		chain := NewConsumerChain()
+		chain.CollectAsCsv(os.TempDir(), false)
+
+		if err := chain.consumeMessage(messages.NewInsertMessage(header, nil, messages.MessageRowData{Row: messages.MessageRow{"id": 1}})); err == nil {
+			t.Fatal("Expected error without column types")
+		}
+	})
+}
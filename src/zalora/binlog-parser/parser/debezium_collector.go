@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// The payload of a Debezium change event of the MySQL connector, see
+// https://debezium.io/documentation/reference/connectors/mysql.html#mysql-events
+type debeziumEvent struct {
+	Before messages.MessageRow `json:"before"`
+	After  messages.MessageRow `json:"after"`
+	Source debeziumSource      `json:"source"`
+	Op     string              `json:"op"`
+	TsMs   int64               `json:"ts_ms"`
+}
+
+// Gtid is null for binlogs written without GTIDs
+type debeziumSource struct {
+	Connector string  `json:"connector"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	Db        string  `json:"db"`
+	Table     string  `json:"table"`
+	ServerId  uint32  `json:"server_id"`
+	Gtid      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Xid       uint64  `json:"xid"`
+}
+
+var debeziumOps = map[messages.MessageType]string{
+	messages.MESSAGE_TYPE_INSERT: "c",
+	messages.MESSAGE_TYPE_UPDATE: "u",
+	messages.MESSAGE_TYPE_DELETE: "d",
+}
+
+func debeziumCollector(stream io.Writer, prettyPrint bool) collector {
+	return func(message messages.Message) error {
+		event, ok := newDebeziumEvent(message, time.Now())
+
+		if !ok {
+			return nil
+		}
+
+		var data []byte
+		var err error
+
+		if prettyPrint {
+			data, err = json.MarshalIndent(event, "", "    ")
+		} else {
+			data, err = json.Marshal(event)
+		}
+
+		if err != nil {
+			glog.Errorf("Failed to convert message to Debezium JSON: %s", err)
+			return err
+		}
+
+		_, err = stream.Write([]byte(fmt.Sprintf("%s\n", data)))
+
+		if err != nil {
+			glog.Errorf("Failed to write Debezium JSON to file %s", err)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// The time of the event in the source is the binlog message time, the time of the event
+// itself the time it was converted at. Messages other than row messages have no event.
+func newDebeziumEvent(message messages.Message, now time.Time) (debeziumEvent, bool) {
+	header := message.GetHeader()
+	event := debeziumEvent{Op: debeziumOps[message.GetType()], TsMs: now.UnixNano() / int64(time.Millisecond)}
+
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		event.After = m.Data.Row
+	case messages.UpdateMessage:
+		event.Before, event.After = m.OldData.Row, m.NewData.Row
+	case messages.DeleteMessage:
+		event.Before = m.Data.Row
+	default:
+		return event, false
+	}
+
+	messageTime, _ := time.Parse(time.RFC3339, header.BinlogMessageTime)
+
+	event.Source = debeziumSource{
+		Connector: "mysql",
+		TsMs:      messageTime.UnixNano() / int64(time.Millisecond),
+		Snapshot:  "false",
+		Db:        header.Schema,
+		Table:     header.Table,
+		ServerId:  header.ServerId,
+		File:      header.BinlogFile,
+		Pos:       header.BinlogPosition,
+		Xid:       header.XId,
+	}
+
+	if header.Gtid != "" {
+		event.Source.Gtid = &header.Gtid
+	}
+
+	return event, true
+}
@@ -0,0 +1,80 @@
+// +build unit
+
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestNewDebeziumEvent(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "buildings", time.Unix(1492065270, 0), 397, 9, "")
+	header.BinlogFile = "mysql-bin.000001"
+	header.ServerId = 1
+
+	gtidHeader := header
+	gtidHeader.Gtid = "0-3704-2816"
+
+	row := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1}}
+	updated := messages.MessageRowData{Row: messages.MessageRow{"building_no": 2}}
+	now := time.Unix(1500000000, 0)
+
+	testCases := []struct {
+		name     string
+		message  messages.Message
+		expected string
+	}{
+		{
+			"Insert",
+			messages.NewInsertMessage(header, nil, row),
+			`{"before":null,"after":{"building_no":1},"source":{"connector":"mysql","ts_ms":1492065270000,"snapshot":"false","db":"test_db","table":"buildings","server_id":1,"gtid":null,"file":"mysql-bin.000001","pos":397,"xid":9},"op":"c","ts_ms":1500000000000}`,
+		},
+		{
+			"Update",
+			messages.NewUpdateMessage(gtidHeader, nil, row, updated),
+			`{"before":{"building_no":1},"after":{"building_no":2},"source":{"connector":"mysql","ts_ms":1492065270000,"snapshot":"false","db":"test_db","table":"buildings","server_id":1,"gtid":"0-3704-2816","file":"mysql-bin.000001","pos":397,"xid":9},"op":"u","ts_ms":1500000000000}`,
+		},
+		{
+			"Delete",
+			messages.NewDeleteMessage(header, nil, row),
+			`{"before":{"building_no":1},"after":null,"source":{"connector":"mysql","ts_ms":1492065270000,"snapshot":"false","db":"test_db","table":"buildings","server_id":1,"gtid":null,"file":"mysql-bin.000001","pos":397,"xid":9},"op":"d","ts_ms":1500000000000}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, ok := newDebeziumEvent(tc.message, now)
+			output, _ := json.Marshal(event)
+
+			if !ok || string(output) != tc.expected {
+				t.Fatalf("Wrong Debezium event - expected %s, got %s", tc.expected, output)
+			}
+		})
+	}
+
+	t.Run("Query", func(t *testing.T) {
+		if _, ok := newDebeziumEvent(messages.NewQueryMessage(header, "DROP TABLE buildings"), now); ok {
+			t.Fatal("Expected no Debezium event for query message")
+		}
+	})
+
+	t.Run("Collect as Debezium", func(t *testing.T) {
+		var stream bytes.Buffer
+
+		chain := NewConsumerChain()
+		chain.CollectAsDebezium(&stream, false)
+
+		for _, message := range []messages.Message{messages.NewQueryMessage(header, "BEGIN"), messages.NewInsertMessage(header, nil, row)} {
+			if err := chain.consumeMessage(message); err != nil {
+				t.Fatalf("Expected no error when consuming message, got %s", err)
+			}
+		}
+
+		if lines := bytes.Split(bytes.TrimSpace(stream.Bytes()), []byte("\n")); len(lines) != 1 || !bytes.HasPrefix(lines[0], []byte(`{"before":null,"after":{"building_no":1}`)) {
+			t.Fatalf("Expected one Debezium event, got %s", stream.Bytes())
+		}
+	})
+}
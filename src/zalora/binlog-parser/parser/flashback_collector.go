@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Collects the statements undoing the row messages of each transaction, and writes them
+// on close, the transactions and their statements in reverse order
+type flashback struct {
+	stream       io.Writer
+	options      SqlReplayOptions
+	transactions [][]string
+	current      []string
+}
+
+func (f *flashback) collect(message messages.Message) error {
+	if message.GetType() == messages.MESSAGE_TYPE_TRANSACTION_COMMIT {
+		f.endTransaction()
+		return nil
+	}
+
+	inverse, ok := inverseMessage(message)
+
+	if !ok {
+		return nil
+	}
+
+	if notLogged := rowNotLogged(message); len(notLogged) > 0 {
+		return fmt.Errorf("Can't undo %s message at position %d, columns %v not logged, needs binlog_row_image=FULL", message.GetType(), message.GetHeader().BinlogPosition, notLogged)
+	}
+
+	statement, ok, err := sqlTextForMessage(inverse, f.options)
+
+	if err != nil {
+		glog.Errorf("Failed to convert %s message at position %d to flashback SQL: %s", message.GetType(), message.GetHeader().BinlogPosition, err)
+		return err
+	}
+
+	if ok {
+		f.current = append(f.current, statement)
+	}
+
+	return nil
+}
+
+func (f *flashback) endTransaction() {
+	if len(f.current) > 0 {
+		f.transactions = append(f.transactions, f.current)
+		f.current = nil
+	}
+}
+
+// TIMESTAMP values are written in UTC, the session time zone is set to match
+const sqlUtcTimeZone = "SET time_zone = '+00:00';\n"
+
+func (f *flashback) write() error {
+	f.endTransaction()
+
+	if _, err := io.WriteString(f.stream, sqlUtcTimeZone); err != nil {
+		return err
+	}
+
+	for i := len(f.transactions) - 1; i >= 0; i-- {
+		statements := f.transactions[i]
+
+		if _, err := io.WriteString(f.stream, "BEGIN;\n"); err != nil {
+			return err
+		}
+
+		for j := len(statements) - 1; j >= 0; j-- {
+			if _, err := fmt.Fprintf(f.stream, "%s;\n", statements[j]); err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(f.stream, "COMMIT;\n"); err != nil {
+			return err
+		}
+	}
+
+	f.transactions = nil
+
+	return nil
+}
+
+// Inserts are undone by deletes, deletes by inserts and updates by updates from the row
+// after the update back to the row before it. The row after an update is found by the
+// key columns after the update, or by all its columns for tables without key.
+func inverseMessage(message messages.Message) (messages.Message, bool) {
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		return messages.NewDeleteMessage(m.Header, m.Key, m.Data), true
+	case messages.DeleteMessage:
+		return messages.NewInsertMessage(m.Header, m.Key, m.Data), true
+	case messages.UpdateMessage:
+		var key messages.MessageRow
+
+		if m.Key != nil {
+			key = make(messages.MessageRow)
+
+			for column, value := range m.Key {
+				if newValue, ok := m.NewData.Row[column]; ok {
+					value = newValue
+				}
+
+				key[column] = value
+			}
+		}
+
+		return messages.NewUpdateMessage(m.Header, key, m.NewData, m.OldData), true
+	}
+
+	return nil, false
+}
+
+// The columns missing from the row to restore, or from the row to find by all its columns
+// for tables without key
+func rowNotLogged(message messages.Message) []string {
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		if len(m.Key) == 0 {
+			return m.Data.NotLogged
+		}
+	case messages.DeleteMessage:
+		return m.Data.NotLogged
+	case messages.UpdateMessage:
+		if len(m.Key) == 0 {
+			return append(append([]string{}, m.OldData.NotLogged...), m.NewData.NotLogged...)
+		}
+
+		return m.OldData.NotLogged
+	}
+
+	return nil
+}
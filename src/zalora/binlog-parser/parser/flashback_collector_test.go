@@ -0,0 +1,109 @@
+// +build unit
+
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestFlashback(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "buildings", time.Unix(1492065270, 0), 397, 9, "")
+	commitTime := time.Unix(1492065270, 0)
+
+	row := func(no int, name interface{}) messages.MessageRowData {
+		return messages.MessageRowData{Row: messages.MessageRow{"building_no": no, "building_name": name}}
+	}
+
+	key := func(no int) messages.MessageRow {
+		return messages.MessageRow{"building_no": no}
+	}
+
+	t.Run("Transactions", func(t *testing.T) {
+		var buffer bytes.Buffer
+
+		chain := NewConsumerChain()
+		chain.IncludeTables("buildings")
+		chain.CollectAsFlashback(&buffer, "")
+
+		roomsHeader := header
+		roomsHeader.Table = "rooms"
+
+		for _, message := range []messages.Message{
+			messages.NewTransactionBeginMessage(header, 2, commitTime),
+			messages.NewInsertMessage(header, key(1), row(1, "ACME")),
+			messages.NewInsertMessage(header, key(2), row(2, "Annex")),
+			messages.NewTransactionCommitMessage(header, 2, commitTime),
+			messages.NewTransactionBeginMessage(header, 2, commitTime),
+			messages.NewUpdateMessage(header, key(1), row(1, "ACME"), row(3, "ACME's Headquaters")),
+			messages.NewInsertMessage(roomsHeader, key(1), row(1, "Lobby")),
+			messages.NewDeleteMessage(header, nil, row(2, nil)),
+			messages.NewTransactionCommitMessage(header, 2, commitTime),
+			messages.NewTransactionBeginMessage(header, 1, commitTime),
+			messages.NewInsertMessage(roomsHeader, key(2), row(2, "Kitchen")),
+			messages.NewTransactionCommitMessage(header, 1, commitTime),
+			messages.NewTransactionBeginMessage(header, 1, commitTime),
+			messages.NewInsertMessage(header, nil, row(4, "Annex")),
+			messages.NewTransactionCommitMessage(header, 1, commitTime),
+		} {
+			if err := chain.consumeMessage(message); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if buffer.Len() != 0 {
+			t.Fatal("Expected nothing to be written before closing")
+		}
+
+		if err := chain.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := strings.Join([]string{
+			"SET time_zone = '+00:00';",
+			"BEGIN;",
+			"DELETE FROM `test_db`.`buildings` WHERE `building_name` = 'Annex' AND `building_no` = 4 LIMIT 1;",
+			"COMMIT;",
+			"BEGIN;",
+			"INSERT INTO `test_db`.`buildings` (`building_name`, `building_no`) VALUES (NULL, 2);",
+			"UPDATE `test_db`.`buildings` SET `building_name` = 'ACME', `building_no` = 1 WHERE `building_no` = 3;",
+			"COMMIT;",
+			"BEGIN;",
+			"DELETE FROM `test_db`.`buildings` WHERE `building_no` = 2;",
+			"DELETE FROM `test_db`.`buildings` WHERE `building_no` = 1;",
+			"COMMIT;",
+		}, "\n") + "\n"
+
+		if buffer.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, buffer.String())
+		}
+	})
+
+	t.Run("Rows not logged", func(t *testing.T) {
+		notLogged := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1}, NotLogged: []string{"building_name"}}
+
+		testCases := []struct {
+			name          string
+			message       messages.Message
+			expectedError bool
+		}{
+			{"Row before update", messages.NewUpdateMessage(header, key(1), notLogged, row(1, "ACME")), true},
+			{"Row after update without key", messages.NewUpdateMessage(header, nil, row(1, "ACME"), notLogged), true},
+			{"Row after update with key", messages.NewUpdateMessage(header, key(1), row(1, "ACME"), notLogged), false},
+			{"Inserted row without key", messages.NewInsertMessage(header, nil, notLogged), true},
+			{"Inserted row with key", messages.NewInsertMessage(header, key(1), notLogged), false},
+		}
+
+		for _, tc := range testCases {
+			chain := NewConsumerChain()
+			chain.CollectAsFlashback(&bytes.Buffer{}, "")
+
+			if err := chain.consumeMessage(tc.message); (err != nil) != tc.expectedError {
+				t.Fatalf("Expected error %v for %s, got %v", tc.expectedError, tc.name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Messages are sent in batches of up to BatchSize messages, or after the first of them
+// waited for BatchTimeout. RequiredAcks is the number of replicas which have to
+// acknowledge a batch, -1 for all in-sync replicas and 0 to not wait for any.
+type ProducerConfig struct {
+	Brokers      []string
+	ClientId     string
+	RequiredAcks int16
+	Timeout      time.Duration
+	BatchSize    int
+	BatchTimeout time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// Produces messages to Kafka. The partition of a message is chosen by its key like the
+// Java client does, so that messages with the same key keep their order. Messages without
+// key go to the first partition of their topic.
+//
+// A failed delivery sticks, every following call returns its error.
+type Producer struct {
+	config        ProducerConfig
+	mutex         sync.Mutex
+	conns         map[string]net.Conn
+	brokers       map[int32]string
+	topics        map[string][]partitionMetadata
+	pending       map[topicPartition][]record
+	pendingCount  int
+	timer         *time.Timer
+	err           error
+	correlationId int32
+}
+
+func NewProducer(config ProducerConfig) *Producer {
+	if config.ClientId == "" {
+		config.ClientId = "binlog-parser"
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+
+	return &Producer{
+		config:  config,
+		conns:   make(map[string]net.Conn),
+		brokers: make(map[int32]string),
+		topics:  make(map[string][]partitionMetadata),
+		pending: make(map[topicPartition][]record),
+	}
+}
+
+func (p *Producer) Produce(topic string, key, value []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	partitions, err := p.partitions(topic)
+
+	if err != nil {
+		p.err = err
+		return err
+	}
+
+	tp := topicPartition{topic, 0}
+
+	if key != nil {
+		tp.partition = partitionForKey(key, len(partitions))
+	}
+
+	p.pending[tp] = append(p.pending[tp], record{key, value, time.Now().UnixNano() / int64(time.Millisecond)})
+	p.pendingCount++
+
+	if p.pendingCount >= p.config.BatchSize {
+		p.err = p.flush()
+	} else if p.timer == nil && p.config.BatchTimeout > 0 {
+		p.timer = time.AfterFunc(p.config.BatchTimeout, p.flushPending)
+	}
+
+	return p.err
+}
+
+// Sends the pending messages and waits for them to be acknowledged
+func (p *Producer) Flush() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err == nil {
+		p.err = p.flush()
+	}
+
+	return p.err
+}
+
+func (p *Producer) Close() error {
+	err := p.Flush()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+
+	return err
+}
+
+func (p *Producer) flushPending() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err == nil {
+		p.err = p.flush()
+	}
+}
+
+// Sends the pending batches to the leaders of their partitions. Batches failing with a
+// retriable error are sent again after refreshing the metadata of their topics, the
+// acknowledged ones are not.
+func (p *Producer) flush() error {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	for attempt := 0; len(p.pending) > 0; attempt++ {
+		err := p.send()
+
+		if err == nil || len(p.pending) == 0 {
+			continue
+		}
+
+		if kafkaErr, ok := err.(Error); ok && !kafkaErr.retriable() || attempt >= p.config.Retries {
+			return err
+		}
+
+		glog.Warningf("Failed to produce to Kafka, retrying: %s", err)
+
+		time.Sleep(p.config.RetryBackoff)
+
+		for tp := range p.pending {
+			delete(p.topics, tp.topic)
+		}
+
+		for tp := range p.pending {
+			if _, err := p.partitions(tp.topic); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.pendingCount = 0
+
+	return nil
+}
+
+// Sends one produce request per leader, returns the last error of any of them
+func (p *Producer) send() error {
+	leaders := make(map[string]map[topicPartition][]byte)
+	var lastErr error
+
+	for tp, records := range p.pending {
+		addr, err := p.leader(tp)
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if leaders[addr] == nil {
+			leaders[addr] = make(map[topicPartition][]byte)
+		}
+
+		leaders[addr][tp] = encodeRecordBatch(records)
+	}
+
+	for addr, batches := range leaders {
+		body := encodeProduceRequest(p.config.RequiredAcks, int32(p.config.Timeout/time.Millisecond), batches)
+		response, err := p.request(addr, apiKeyProduce, produceVersion, body, p.config.RequiredAcks != 0)
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var errs map[topicPartition]Error
+
+		if response != nil {
+			if errs, err = decodeProduceResponse(response); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		for tp := range batches {
+			if err, ok := errs[tp]; ok && err != 0 {
+				lastErr = err
+				continue
+			}
+
+			p.pendingCount -= len(p.pending[tp])
+			delete(p.pending, tp)
+		}
+	}
+
+	return lastErr
+}
+
+func (p *Producer) leader(tp topicPartition) (string, error) {
+	partitions := p.topics[tp.topic]
+
+	if int(tp.partition) >= len(partitions) {
+		return "", errUnknownTopicOrPartition
+	}
+
+	addr, ok := p.brokers[partitions[tp.partition].leader]
+
+	if !ok {
+		return "", errLeaderNotAvailable
+	}
+
+	return addr, nil
+}
+
+// The partitions of a topic, indexed by their id. Metadata is fetched the first time a
+// topic is produced to, and again after retriable errors.
+func (p *Producer) partitions(topic string) ([]partitionMetadata, error) {
+	if partitions, ok := p.topics[topic]; ok {
+		return partitions, nil
+	}
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if err = p.metadata(topic); err == nil {
+			return p.topics[topic], nil
+		}
+
+		if kafkaErr, ok := err.(Error); ok && !kafkaErr.retriable() || attempt >= p.config.Retries {
+			return nil, fmt.Errorf("Failed to get metadata of Kafka topic %s: %s", topic, err)
+		}
+
+		time.Sleep(p.config.RetryBackoff)
+	}
+}
+
+// Asks the bootstrap brokers for the metadata of a topic, one after the other until one
+// of them answers
+func (p *Producer) metadata(topic string) error {
+	var err error
+
+	for _, addr := range p.config.Brokers {
+		var response []byte
+
+		response, err = p.request(addr, apiKeyMetadata, metadataVersion, encodeMetadataRequest([]string{topic}), true)
+
+		if err != nil {
+			continue
+		}
+
+		brokers, topics, err := decodeMetadataResponse(response)
+
+		if err != nil {
+			return err
+		}
+
+		for _, broker := range brokers {
+			p.brokers[broker.id] = broker.addr()
+		}
+
+		for _, metadata := range topics {
+			if metadata.name != topic {
+				continue
+			}
+
+			if metadata.err != 0 {
+				return metadata.err
+			}
+
+			partitions := make([]partitionMetadata, len(metadata.partitions))
+
+			for _, partition := range metadata.partitions {
+				if partition.err != 0 && partition.err != errLeaderNotAvailable {
+					return partition.err
+				}
+
+				if int(partition.id) >= len(partitions) || partition.id < 0 {
+					return fmt.Errorf("Invalid partition %d of Kafka topic %s", partition.id, topic)
+				}
+
+				partitions[partition.id] = partition
+			}
+
+			if len(partitions) == 0 {
+				return errUnknownTopicOrPartition
+			}
+
+			p.topics[topic] = partitions
+
+			return nil
+		}
+
+		return errUnknownTopicOrPartition
+	}
+
+	return err
+}
+
+// Sends a request and reads its response, if one is expected. Connections are kept open
+// per broker, and closed after any failure.
+func (p *Producer) request(addr string, apiKey, apiVersion int16, body []byte, expectResponse bool) ([]byte, error) {
+	conn, ok := p.conns[addr]
+
+	if !ok {
+		var err error
+
+		if conn, err = net.DialTimeout("tcp", addr, p.config.Timeout); err != nil {
+			return nil, err
+		}
+
+		p.conns[addr] = conn
+	}
+
+	p.correlationId++
+
+	response, err := p.roundTrip(conn, encodeRequest(apiKey, apiVersion, p.correlationId, p.config.ClientId, body), expectResponse)
+
+	if err != nil {
+		conn.Close()
+		delete(p.conns, addr)
+
+		return nil, fmt.Errorf("Kafka request to %s failed: %s", addr, err)
+	}
+
+	return response, nil
+}
+
+func (p *Producer) roundTrip(conn net.Conn, request []byte, expectResponse bool) ([]byte, error) {
+	// Produce requests wait up to the timeout for acknowledgements on the broker
+	conn.SetDeadline(time.Now().Add(2 * p.config.Timeout))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	if !expectResponse {
+		return nil, nil
+	}
+
+	header := make([]byte, 8)
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+
+	if size < 4 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+
+	if correlationId := int32(binary.BigEndian.Uint32(header[4:])); correlationId != p.correlationId {
+		return nil, fmt.Errorf("unexpected correlation id %d", correlationId)
+	}
+
+	response := make([]byte, size-4)
+
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
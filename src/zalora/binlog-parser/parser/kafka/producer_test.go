@@ -0,0 +1,359 @@
+// +build unit
+
+package kafka
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An in-process broker answering metadata and produce requests. Every topic exists with
+// the given number of partitions, errors to answer produce requests with can be queued
+// per partition.
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	partitions int
+	mutex      sync.Mutex
+	records    map[topicPartition][]record
+	errors     map[topicPartition][]Error
+	requests   map[int16]int
+}
+
+func newFakeBroker(t *testing.T, partitions int) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBroker{
+		t:          t,
+		listener:   listener,
+		partitions: partitions,
+		records:    make(map[topicPartition][]record),
+		errors:     make(map[topicPartition][]Error),
+		requests:   make(map[int16]int),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		size := make([]byte, 4)
+
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+
+		request := make([]byte, binary.BigEndian.Uint32(size))
+
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+
+		d := &decoder{buf: request}
+		apiKey := d.int16()
+		d.int16()
+		correlationId := d.int32()
+		d.string()
+
+		var body []byte
+
+		switch apiKey {
+		case apiKeyMetadata:
+			body = b.metadata(d)
+		case apiKeyProduce:
+			body = b.produce(d)
+		default:
+			b.t.Errorf("Unexpected request %d", apiKey)
+			return
+		}
+
+		if d.err != nil {
+			b.t.Errorf("Invalid request: %s", d.err)
+			return
+		}
+
+		if body == nil {
+			continue
+		}
+
+		response := &encoder{}
+		response.int32(int32(4 + len(body)))
+		response.int32(correlationId)
+		response.buf = append(response.buf, body...)
+
+		if _, err := conn.Write(response.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(d *decoder) []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.requests[apiKeyMetadata]++
+
+	host, port, _ := net.SplitHostPort(b.addr())
+	portNumber, _ := strconv.Atoi(port)
+
+	e := &encoder{}
+	e.int32(1)
+	e.int32(1)
+	e.string(host)
+	e.int32(int32(portNumber))
+
+	topics := d.arrayLength()
+	e.int32(int32(topics))
+
+	for ; topics > 0; topics-- {
+		e.int16(0)
+		e.string(d.string())
+		e.int32(int32(b.partitions))
+
+		for partition := 0; partition < b.partitions; partition++ {
+			e.int16(0)
+			e.int32(int32(partition))
+			e.int32(1)
+			e.int32(1)
+			e.int32(1)
+			e.int32(1)
+			e.int32(1)
+		}
+	}
+
+	return e.buf
+}
+
+func (b *fakeBroker) produce(d *decoder) []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.requests[apiKeyProduce]++
+
+	d.string()
+	acks := d.int16()
+	d.int32()
+
+	e := &encoder{}
+	topics := d.arrayLength()
+	e.int32(int32(topics))
+
+	for ; topics > 0; topics-- {
+		topic := d.string()
+		partitions := d.arrayLength()
+
+		e.string(topic)
+		e.int32(int32(partitions))
+
+		for ; partitions > 0; partitions-- {
+			tp := topicPartition{topic, d.int32()}
+			records, err := decodeRecordBatch(d.bytes())
+
+			if err != nil {
+				b.t.Errorf("Invalid record batch: %s", err)
+			}
+
+			var code Error
+
+			if errors := b.errors[tp]; len(errors) > 0 {
+				code, b.errors[tp] = errors[0], errors[1:]
+			} else {
+				b.records[tp] = append(b.records[tp], records...)
+			}
+
+			e.int32(tp.partition)
+			e.int16(int16(code))
+			e.int64(0)
+			e.int64(-1)
+		}
+	}
+
+	e.int32(0)
+
+	if acks == 0 {
+		return nil
+	}
+
+	return e.buf
+}
+
+func (b *fakeBroker) values(tp topicPartition) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var values []string
+
+	for _, record := range b.records[tp] {
+		values = append(values, string(record.value))
+	}
+
+	return values
+}
+
+func (b *fakeBroker) requestCount(apiKey int16) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.requests[apiKey]
+}
+
+func assertValues(t *testing.T, b *fakeBroker, tp topicPartition, expected ...string) {
+	values := b.values(tp)
+
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %v in %v, got %v", expected, tp, values)
+	}
+
+	for i := range values {
+		if values[i] != expected[i] {
+			t.Fatalf("Expected %v in %v, got %v", expected, tp, values)
+		}
+	}
+}
+
+func TestProducer(t *testing.T) {
+	key := []byte(`{"id":1}`)
+	keyPartition := partitionForKey(key, 4)
+
+	t.Run("Batches", func(t *testing.T) {
+		broker := newFakeBroker(t, 4)
+		producer := NewProducer(ProducerConfig{Brokers: []string{broker.addr()}, RequiredAcks: -1, BatchSize: 3, BatchTimeout: time.Hour})
+		defer producer.Close()
+
+		for _, value := range []string{"1", "2", "3", "4"} {
+			if err := producer.Produce("test_db.buildings", key, []byte(value)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := producer.Produce("test_db.rooms", nil, []byte("5")); err != nil {
+			t.Fatal(err)
+		}
+
+		if count := broker.requestCount(apiKeyProduce); count != 1 {
+			t.Fatalf("Expected 1 produce request before flushing, got %d", count)
+		}
+
+		if err := producer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		assertValues(t, broker, topicPartition{"test_db.buildings", keyPartition}, "1", "2", "3", "4")
+		assertValues(t, broker, topicPartition{"test_db.rooms", 0}, "5")
+
+		if count := broker.requestCount(apiKeyMetadata); count != 2 {
+			t.Fatalf("Expected 2 metadata requests, got %d", count)
+		}
+	})
+
+	t.Run("Batch timeout", func(t *testing.T) {
+		broker := newFakeBroker(t, 4)
+		producer := NewProducer(ProducerConfig{Brokers: []string{broker.addr()}, RequiredAcks: -1, BatchSize: 100, BatchTimeout: 10 * time.Millisecond})
+		defer producer.Close()
+
+		if err := producer.Produce("test_db.buildings", key, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100 && len(broker.values(topicPartition{"test_db.buildings", keyPartition})) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assertValues(t, broker, topicPartition{"test_db.buildings", keyPartition}, "1")
+	})
+
+	t.Run("Retriable error", func(t *testing.T) {
+		broker := newFakeBroker(t, 4)
+		broker.errors[topicPartition{"test_db.buildings", keyPartition}] = []Error{errNotLeaderForPartition}
+
+		producer := NewProducer(ProducerConfig{Brokers: []string{broker.addr()}, RequiredAcks: -1, BatchSize: 100, Retries: 1, RetryBackoff: time.Millisecond})
+		defer producer.Close()
+
+		producer.Produce("test_db.buildings", key, []byte("1"))
+		producer.Produce("test_db.rooms", nil, []byte("2"))
+
+		if err := producer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		assertValues(t, broker, topicPartition{"test_db.buildings", keyPartition}, "1")
+		assertValues(t, broker, topicPartition{"test_db.rooms", 0}, "2")
+
+		if count := broker.requestCount(apiKeyProduce); count != 2 {
+			t.Fatalf("Expected 2 produce requests, got %d", count)
+		}
+	})
+
+	t.Run("Fatal error", func(t *testing.T) {
+		broker := newFakeBroker(t, 4)
+		broker.errors[topicPartition{"test_db.buildings", keyPartition}] = []Error{errMessageTooLarge}
+
+		producer := NewProducer(ProducerConfig{Brokers: []string{broker.addr()}, RequiredAcks: -1, BatchSize: 1, Retries: 3})
+		defer producer.Close()
+
+		if err := producer.Produce("test_db.buildings", key, []byte("1")); err != errMessageTooLarge {
+			t.Fatalf("Expected %s, got %v", errMessageTooLarge, err)
+		}
+
+		if err := producer.Produce("test_db.buildings", key, []byte("2")); err != errMessageTooLarge {
+			t.Fatalf("Expected error to stick, got %v", err)
+		}
+
+		assertValues(t, broker, topicPartition{"test_db.buildings", keyPartition})
+	})
+
+	t.Run("Without acks", func(t *testing.T) {
+		broker := newFakeBroker(t, 4)
+		producer := NewProducer(ProducerConfig{Brokers: []string{broker.addr()}, BatchSize: 1})
+		defer producer.Close()
+
+		for _, value := range []string{"1", "2"} {
+			if err := producer.Produce("test_db.buildings", key, []byte(value)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 100 && len(broker.values(topicPartition{"test_db.buildings", keyPartition})) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assertValues(t, broker, topicPartition{"test_db.buildings", keyPartition}, "1", "2")
+	})
+
+	t.Run("Unreachable broker", func(t *testing.T) {
+		producer := NewProducer(ProducerConfig{Brokers: []string{"127.0.0.1:1"}, RequiredAcks: -1, RetryBackoff: time.Millisecond})
+
+		if err := producer.Produce("test_db.buildings", key, []byte("1")); err == nil {
+			t.Fatal("Expected error")
+		}
+	})
+}
@@ -0,0 +1,515 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// The subset of the Kafka protocol needed to produce messages, see
+// https://kafka.apache.org/protocol. Requests and responses are prefixed with their size.
+const (
+	apiKeyProduce  int16 = 0
+	apiKeyMetadata int16 = 3
+
+	produceVersion  int16 = 3 // the first version with record batches
+	metadataVersion int16 = 0
+)
+
+// Error codes of responses, see https://kafka.apache.org/protocol#protocol_error_codes
+type Error int16
+
+const (
+	errCorruptMessage               Error = 2
+	errUnknownTopicOrPartition      Error = 3
+	errLeaderNotAvailable           Error = 5
+	errNotLeaderForPartition        Error = 6
+	errRequestTimedOut              Error = 7
+	errMessageTooLarge              Error = 10
+	errNetworkException             Error = 13
+	errInvalidTopic                 Error = 17
+	errNotEnoughReplicas            Error = 19
+	errNotEnoughReplicasAfterAppend Error = 20
+	errTopicAuthorizationFailed     Error = 29
+)
+
+var errorNames = map[Error]string{
+	errCorruptMessage:               "CORRUPT_MESSAGE",
+	errUnknownTopicOrPartition:      "UNKNOWN_TOPIC_OR_PARTITION",
+	errLeaderNotAvailable:           "LEADER_NOT_AVAILABLE",
+	errNotLeaderForPartition:        "NOT_LEADER_FOR_PARTITION",
+	errRequestTimedOut:              "REQUEST_TIMED_OUT",
+	errMessageTooLarge:              "MESSAGE_TOO_LARGE",
+	errNetworkException:             "NETWORK_EXCEPTION",
+	errInvalidTopic:                 "INVALID_TOPIC_EXCEPTION",
+	errNotEnoughReplicas:            "NOT_ENOUGH_REPLICAS",
+	errNotEnoughReplicasAfterAppend: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	errTopicAuthorizationFailed:     "TOPIC_AUTHORIZATION_FAILED",
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e]; ok {
+		return fmt.Sprintf("Kafka error %s", name)
+	}
+
+	return fmt.Sprintf("Kafka error %d", int16(e))
+}
+
+// Errors after which producing is retried with fresh metadata
+func (e Error) retriable() bool {
+	switch e {
+	case errCorruptMessage, errUnknownTopicOrPartition, errLeaderNotAvailable, errNotLeaderForPartition, errRequestTimedOut, errNetworkException, errNotEnoughReplicas, errNotEnoughReplicasAfterAppend:
+		return true
+	}
+
+	return false
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(i int8) {
+	e.buf = append(e.buf, byte(i))
+}
+
+func (e *encoder) int16(i int16) {
+	e.buf = append(e.buf, byte(i>>8), byte(i))
+}
+
+func (e *encoder) int32(i int32) {
+	e.buf = append(e.buf, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+}
+
+func (e *encoder) int64(i int64) {
+	e.int32(int32(i >> 32))
+	e.int32(int32(i))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+
+	e.string(*s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// Zig-zag varints, used in records
+func (e *encoder) varint(i int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf = append(e.buf, b[:binary.PutVarint(b, i)]...)
+}
+
+func (e *encoder) varintBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+
+	e.varint(int64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// Reads a response, the first error sticks and makes all following reads return zero
+// values
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err == nil && (n < 0 || n > len(d.buf)) {
+		d.err = fmt.Errorf("Truncated Kafka response")
+	}
+
+	if d.err != nil {
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+
+	if n < 0 {
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+
+	if n < 0 {
+		return nil
+	}
+
+	return d.next(int(n))
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	i, n := binary.Varint(d.buf)
+
+	if n <= 0 {
+		d.err = fmt.Errorf("Invalid varint in Kafka response")
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+
+	return i
+}
+
+func (d *decoder) varintBytes() []byte {
+	n := d.varint()
+
+	if n < 0 {
+		return nil
+	}
+
+	return d.next(int(n))
+}
+
+// Array lengths are checked against the remaining data, so that a corrupt length doesn't
+// make the reader allocate or loop for long
+func (d *decoder) arrayLength() int {
+	n := int(d.int32())
+
+	if n > len(d.buf) {
+		d.err = fmt.Errorf("Invalid array length %d in Kafka response", n)
+	}
+
+	if d.err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// A request with header v1: api key, api version, correlation id and client id
+func encodeRequest(apiKey, apiVersion int16, correlationId int32, clientId string, body []byte) []byte {
+	e := &encoder{buf: make([]byte, 4, 4+10+len(clientId)+len(body))}
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(correlationId)
+	e.string(clientId)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	return e.buf
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type record struct {
+	key   []byte
+	value []byte
+	time  int64 // milliseconds since the epoch
+}
+
+// A record batch of message format v2 without compression, see
+// https://kafka.apache.org/documentation/#recordbatch
+func encodeRecordBatch(records []record) []byte {
+	e := &encoder{}
+	e.int64(0)  // base offset, assigned by the broker
+	e.int32(0)  // batch length, set below
+	e.int32(-1) // partition leader epoch
+	e.int8(2)   // magic
+	e.int32(0)  // CRC, set below
+
+	crcStart := len(e.buf)
+
+	var maxTime int64
+
+	for _, record := range records {
+		if record.time > maxTime {
+			maxTime = record.time
+		}
+	}
+
+	e.int16(0) // attributes
+	e.int32(int32(len(records) - 1))
+	e.int64(records[0].time)
+	e.int64(maxTime)
+	e.int64(-1) // producer id
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.int32(int32(len(records)))
+
+	for i, record := range records {
+		r := &encoder{}
+		r.int8(0) // attributes
+		r.varint(record.time - records[0].time)
+		r.varint(int64(i))
+		r.varintBytes(record.key)
+		r.varintBytes(record.value)
+		r.varint(0) // headers
+
+		e.varint(int64(len(r.buf)))
+		e.buf = append(e.buf, r.buf...)
+	}
+
+	binary.BigEndian.PutUint32(e.buf[8:12], uint32(len(e.buf)-12))
+	binary.BigEndian.PutUint32(e.buf[crcStart-4:crcStart], crc32.Checksum(e.buf[crcStart:], castagnoli))
+
+	return e.buf
+}
+
+func decodeRecordBatch(data []byte) ([]record, error) {
+	d := &decoder{buf: data}
+	d.int64()
+	d.int32()
+	d.int32()
+
+	if magic := d.int8(); magic != 2 {
+		return nil, fmt.Errorf("Unsupported record batch magic %d", magic)
+	}
+
+	crc := uint32(d.int32())
+
+	if d.err == nil && crc != crc32.Checksum(d.buf, castagnoli) {
+		return nil, fmt.Errorf("Wrong record batch CRC")
+	}
+
+	d.int16()
+	d.int32()
+	baseTime := d.int64()
+	d.int64()
+	d.int64()
+	d.int16()
+	d.int32()
+
+	records := make([]record, d.arrayLength())
+
+	for i := range records {
+		d.varint()
+		d.int8()
+		records[i].time = baseTime + d.varint()
+		d.varint()
+		records[i].key = d.varintBytes()
+		records[i].value = d.varintBytes()
+
+		for headers := d.varint(); headers > 0; headers-- {
+			d.varintBytes()
+			d.varintBytes()
+		}
+	}
+
+	return records, d.err
+}
+
+type broker struct {
+	id   int32
+	host string
+	port int32
+}
+
+func (b broker) addr() string {
+	return fmt.Sprintf("%s:%d", b.host, b.port)
+}
+
+type partitionMetadata struct {
+	err    Error
+	id     int32
+	leader int32
+}
+
+type topicMetadata struct {
+	err        Error
+	name       string
+	partitions []partitionMetadata
+}
+
+func encodeMetadataRequest(topics []string) []byte {
+	e := &encoder{}
+	e.int32(int32(len(topics)))
+
+	for _, topic := range topics {
+		e.string(topic)
+	}
+
+	return e.buf
+}
+
+func decodeMetadataResponse(data []byte) ([]broker, []topicMetadata, error) {
+	d := &decoder{buf: data}
+
+	brokers := make([]broker, d.arrayLength())
+
+	for i := range brokers {
+		brokers[i] = broker{d.int32(), d.string(), d.int32()}
+	}
+
+	topics := make([]topicMetadata, d.arrayLength())
+
+	for i := range topics {
+		topics[i].err = Error(d.int16())
+		topics[i].name = d.string()
+		topics[i].partitions = make([]partitionMetadata, d.arrayLength())
+
+		for j := range topics[i].partitions {
+			topics[i].partitions[j] = partitionMetadata{Error(d.int16()), d.int32(), d.int32()}
+
+			for replicas := d.arrayLength(); replicas > 0; replicas-- {
+				d.int32()
+			}
+
+			for isr := d.arrayLength(); isr > 0; isr-- {
+				d.int32()
+			}
+		}
+	}
+
+	return brokers, topics, d.err
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func encodeProduceRequest(acks int16, timeoutMs int32, batches map[topicPartition][]byte) []byte {
+	topics := make(map[string][]int32)
+	var names []string
+
+	for tp := range batches {
+		if _, ok := topics[tp.topic]; !ok {
+			names = append(names, tp.topic)
+		}
+
+		topics[tp.topic] = append(topics[tp.topic], tp.partition)
+	}
+
+	e := &encoder{}
+	e.nullableString(nil) // transactional id
+	e.int16(acks)
+	e.int32(timeoutMs)
+	e.int32(int32(len(names)))
+
+	for _, name := range names {
+		e.string(name)
+		e.int32(int32(len(topics[name])))
+
+		for _, partition := range topics[name] {
+			e.int32(partition)
+			e.bytes(batches[topicPartition{name, partition}])
+		}
+	}
+
+	return e.buf
+}
+
+func decodeProduceResponse(data []byte) (map[topicPartition]Error, error) {
+	d := &decoder{buf: data}
+	errs := make(map[topicPartition]Error)
+
+	for topics := d.arrayLength(); topics > 0; topics-- {
+		name := d.string()
+
+		for partitions := d.arrayLength(); partitions > 0; partitions-- {
+			partition := d.int32()
+			errs[topicPartition{name, partition}] = Error(d.int16())
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+
+	d.int32() // throttle time
+
+	return errs, d.err
+}
+
+// The partitioner of the Java client, so that a key maps to the same partition whichever
+// client produced it
+func partitionForKey(key []byte, partitions int) int32 {
+	return int32(murmur2(key)&0x7fffffff) % int32(partitions)
+}
+
+func murmur2(data []byte) int32 {
+	const m = 0x5bd1e995
+	const r = 24
+
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
@@ -0,0 +1,68 @@
+// +build unit
+
+package kafka
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Hashes of the Java client
+	testCases := []struct {
+		data     string
+		expected int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.data, func(t *testing.T) {
+			if hash := murmur2([]byte(tc.data)); hash != tc.expected {
+				t.Fatalf("Expected %d, got %d", tc.expected, hash)
+			}
+		})
+	}
+}
+
+func TestPartitionForKey(t *testing.T) {
+	if partition := partitionForKey([]byte("foobar"), 7); partition != (-790332482&0x7fffffff)%7 {
+		t.Fatalf("Unexpected partition %d", partition)
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	records := []record{
+		{[]byte("key"), []byte("first"), 1500000000000},
+		{nil, []byte("second"), 1500000000042},
+		{[]byte("key"), nil, 1500000000001},
+	}
+
+	batch := encodeRecordBatch(records)
+
+	t.Run("Round trip", func(t *testing.T) {
+		decoded, err := decodeRecordBatch(batch)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(decoded, records) {
+			t.Fatalf("Expected %v, got %v", records, decoded)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		corrupt := append([]byte{}, batch...)
+		corrupt[len(corrupt)-1] ^= 1
+
+		if _, err := decodeRecordBatch(corrupt); err == nil {
+			t.Fatal("Expected CRC error")
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/golang/glog"
+	"regexp"
+	"strings"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Implemented by kafka.Producer. Close waits until the produced messages are acknowledged
+// and closes the connections to the brokers.
+type KafkaProducer interface {
+	Produce(topic string, key, value []byte) error
+	Close() error
+}
+
+// Characters not allowed in Kafka topic names
+var invalidTopicChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// The key of a message is the JSON of the primary or unique key values of its row, so that
+// all changes of a row end up in the same partition, in order. Messages of tables without
+// such a key have no key.
+func kafkaCollector(producer KafkaProducer, topicTemplate string, debezium bool) collector {
+	return func(message messages.Message) error {
+		key, ok := kafkaKey(message)
+
+		if !ok {
+			return nil
+		}
+
+		header := message.GetHeader()
+		topic := kafkaTopic(topicTemplate, header.Schema, header.Table)
+
+		var value []byte
+		var err error
+
+		if debezium {
+			event, _ := newDebeziumEvent(message, time.Now())
+			value, err = json.Marshal(event)
+		} else {
+			value, err = marshalMessage(message, false)
+		}
+
+		if err != nil {
+			glog.Errorf("Failed to convert message to JSON: %s", err)
+			return err
+		}
+
+		if len(key) > 0 {
+			var keyJson []byte
+
+			if keyJson, err = json.Marshal(key); err != nil {
+				glog.Errorf("Failed to convert message key to JSON: %s", err)
+				return err
+			}
+
+			err = producer.Produce(topic, keyJson, value)
+		} else {
+			err = producer.Produce(topic, nil, value)
+		}
+
+		if err != nil {
+			glog.Errorf("Failed to produce message to Kafka topic %s: %s", topic, err)
+			return err
+		}
+
+		return nil
+	}
+}
+
+func kafkaKey(message messages.Message) (messages.MessageRow, bool) {
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		return m.Key, true
+	case messages.UpdateMessage:
+		return m.Key, true
+	case messages.DeleteMessage:
+		return m.Key, true
+	}
+
+	return nil, false
+}
+
+func kafkaTopic(topicTemplate, schema, table string) string {
+	topic := strings.NewReplacer("{schema}", schema, "{table}", table).Replace(topicTemplate)
+
+	return invalidTopicChars.ReplaceAllString(topic, "_")
+}
@@ -0,0 +1,121 @@
+// +build unit
+
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+type producedMessage struct {
+	topic string
+	key   string
+	value string
+}
+
+type fakeKafkaProducer struct {
+	messages []producedMessage
+	closes   int
+	err      error
+}
+
+func (p *fakeKafkaProducer) Produce(topic string, key, value []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.messages = append(p.messages, producedMessage{topic, string(key), string(value)})
+
+	return nil
+}
+
+func (p *fakeKafkaProducer) Close() error {
+	p.closes++
+
+	return p.err
+}
+
+func TestKafkaCollector(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "buildings", time.Unix(1492065270, 0), 397, 9, "")
+	key := messages.MessageRow{"building_no": 1}
+	row := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1, "building_name": "ACME Headquaters"}}
+
+	t.Run("JSON", func(t *testing.T) {
+		producer := &fakeKafkaProducer{}
+		chain := NewConsumerChain()
+		chain.IncludeTransactionBoundaries()
+		chain.CollectAsKafka(producer, "binlog.{schema}.{table}", false)
+
+		insert := messages.NewInsertMessage(header, key, row)
+		noKeyHeader := messages.NewMessageHeader("test_db", "log$entries", time.Unix(1492065270, 0), 500, 9, "")
+		deleteMessage := messages.NewDeleteMessage(noKeyHeader, nil, row)
+
+		for _, message := range []messages.Message{
+			messages.NewTransactionBeginMessage(header, 2, time.Unix(1492065270, 0)),
+			insert,
+			deleteMessage,
+			messages.NewQueryMessage(header, messages.SqlQuery("ALTER TABLE buildings")),
+		} {
+			if err := chain.consumeMessage(message); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := chain.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		insertJson, _ := json.Marshal(insert)
+		deleteJson, _ := json.Marshal(deleteMessage)
+
+		expected := []producedMessage{
+			{"binlog.test_db.buildings", `{"building_no":1}`, string(insertJson)},
+			{"binlog.test_db.log_entries", "", string(deleteJson)},
+		}
+
+		if fmt.Sprint(producer.messages) != fmt.Sprint(expected) {
+			t.Fatalf("Expected %v, got %v", expected, producer.messages)
+		}
+
+		if producer.closes != 1 {
+			t.Fatalf("Expected producer to be closed once, got %d", producer.closes)
+		}
+	})
+
+	t.Run("Debezium", func(t *testing.T) {
+		producer := &fakeKafkaProducer{}
+		chain := NewConsumerChain()
+		chain.CollectAsKafka(producer, "{table}", true)
+
+		if err := chain.consumeMessage(messages.NewInsertMessage(header, key, row)); err != nil {
+			t.Fatal(err)
+		}
+
+		var event debeziumEvent
+
+		if len(producer.messages) != 1 || json.Unmarshal([]byte(producer.messages[0].value), &event) != nil {
+			t.Fatalf("Expected one Debezium event, got %v", producer.messages)
+		}
+
+		if producer.messages[0].topic != "buildings" || event.Op != "c" || event.Source.Table != "buildings" {
+			t.Fatalf("Unexpected Debezium event %v", producer.messages[0])
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		producer := &fakeKafkaProducer{err: fmt.Errorf("Broker down")}
+		chain := NewConsumerChain()
+		chain.CollectAsKafka(producer, "{schema}.{table}", false)
+
+		if err := chain.consumeMessage(messages.NewInsertMessage(header, key, row)); err != producer.err {
+			t.Fatalf("Expected %s, got %v", producer.err, err)
+		}
+
+		if err := chain.Close(); err != producer.err {
+			t.Fatalf("Expected %s, got %v", producer.err, err)
+		}
+	})
+}
@@ -11,6 +11,9 @@ const (
 	MESSAGE_TYPE_UPDATE MessageType = "Update"
 	MESSAGE_TYPE_DELETE MessageType = "Delete"
 	MESSAGE_TYPE_QUERY  MessageType = "Query"
+
+	MESSAGE_TYPE_TRANSACTION_BEGIN  MessageType = "TransactionBegin"
+	MESSAGE_TYPE_TRANSACTION_COMMIT MessageType = "TransactionCommit"
 )
 
 type MessageHeader struct {
@@ -19,15 +22,21 @@ type MessageHeader struct {
 	BinlogMessageTime string
 	BinlogPosition    uint32
 	XId               uint64
+	Gtid              string `json:",omitempty"`
+	BinlogFile        string `json:",omitempty"`
+	ServerId          uint32 `json:",omitempty"`
 }
 
-func NewMessageHeader(schema string, table string, binlogMessageTime time.Time, binlogPosition uint32, xId uint64) MessageHeader {
+// The GTID is empty for binlogs written without GTIDs. The binlog file and the id of the
+// server which logged the event are set by the conversion of binlog events to messages.
+func NewMessageHeader(schema string, table string, binlogMessageTime time.Time, binlogPosition uint32, xId uint64, gtid string) MessageHeader {
 	return MessageHeader{
 		Schema:            schema,
 		Table:             table,
 		BinlogMessageTime: binlogMessageTime.UTC().Format(time.RFC3339),
 		BinlogPosition:    binlogPosition,
 		XId:               xId,
+		Gtid:              gtid,
 	}
 }
 
@@ -51,9 +60,29 @@ func (b baseMessage) GetType() MessageType {
 
 type MessageRow map[string]interface{}
 
+// Column types are only included on request, keyed by the field names of the row.
+// NotLogged lists the columns left out of the row image in the binlog, see
+// binlog_row_image=MINIMAL and NOBLOB, which are missing from the row. NULL values are
+// in the row as nil.
 type MessageRowData struct {
 	Row           MessageRow
 	MappingNotice string
+	Columns       map[string]MessageColumnType `json:",omitempty"`
+	NotLogged     []string                     `json:",omitempty"`
+}
+
+// Position is the position of the column in the table, starting at 1. Length is the
+// maximum length in bytes of character and binary columns, or the number of bits of BIT
+// columns. Precision is the one of DECIMAL columns, or the fractional seconds precision of
+// temporal columns.
+type MessageColumnType struct {
+	Position  int `json:",omitempty"`
+	Type      string
+	Length    int `json:",omitempty"`
+	Precision int `json:",omitempty"`
+	Scale     int `json:",omitempty"`
+	Unsigned  bool
+	Nullable  bool
 }
 
 type SqlQuery string
@@ -67,30 +96,62 @@ func NewQueryMessage(header MessageHeader, query SqlQuery) QueryMessage {
 	return QueryMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_QUERY}, Query: query}
 }
 
+// The key holds the values of the primary key columns of the row, or of a unique key
+// when the table has no primary key. It is nil when the table has no key usable for the
+// row, and holds the values before the update for updates. Changes lists the columns
+// changed by an update, only when the rows are reduced to the changed columns.
 type UpdateMessage struct {
 	baseMessage
+	Key     MessageRow `json:",omitempty"`
+	Changes []string   `json:",omitempty"`
 	OldData MessageRowData
 	NewData MessageRowData
 }
 
-func NewUpdateMessage(header MessageHeader, oldData MessageRowData, newData MessageRowData) UpdateMessage {
-	return UpdateMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_UPDATE}, OldData: oldData, NewData: newData}
+func NewUpdateMessage(header MessageHeader, key MessageRow, oldData MessageRowData, newData MessageRowData) UpdateMessage {
+	return UpdateMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_UPDATE}, Key: key, OldData: oldData, NewData: newData}
 }
 
 type InsertMessage struct {
 	baseMessage
+	Key  MessageRow `json:",omitempty"`
 	Data MessageRowData
 }
 
-func NewInsertMessage(header MessageHeader, data MessageRowData) InsertMessage {
-	return InsertMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_INSERT}, Data: data}
+func NewInsertMessage(header MessageHeader, key MessageRow, data MessageRowData) InsertMessage {
+	return InsertMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_INSERT}, Key: key, Data: data}
 }
 
 type DeleteMessage struct {
 	baseMessage
+	Key  MessageRow `json:",omitempty"`
 	Data MessageRowData
 }
 
-func NewDeleteMessage(header MessageHeader, data MessageRowData) DeleteMessage {
-	return DeleteMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_DELETE}, Data: data}
+func NewDeleteMessage(header MessageHeader, key MessageRow, data MessageRowData) DeleteMessage {
+	return DeleteMessage{baseMessage: baseMessage{Header: header, Type: MESSAGE_TYPE_DELETE}, Key: key, Data: data}
+}
+
+// Surrounds the row messages of a transaction. The event count is the number of row
+// messages in the transaction before any filter, the commit time the time of its XID event.
+type TransactionMessage struct {
+	baseMessage
+	EventCount int
+	CommitTime string
+}
+
+func NewTransactionBeginMessage(header MessageHeader, eventCount int, commitTime time.Time) TransactionMessage {
+	return newTransactionMessage(header, MESSAGE_TYPE_TRANSACTION_BEGIN, eventCount, commitTime)
+}
+
+func NewTransactionCommitMessage(header MessageHeader, eventCount int, commitTime time.Time) TransactionMessage {
+	return newTransactionMessage(header, MESSAGE_TYPE_TRANSACTION_COMMIT, eventCount, commitTime)
+}
+
+func newTransactionMessage(header MessageHeader, messageType MessageType, eventCount int, commitTime time.Time) TransactionMessage {
+	return TransactionMessage{
+		baseMessage: baseMessage{Header: header, Type: messageType},
+		EventCount:  eventCount,
+		CommitTime:  commitTime.UTC().Format(time.RFC3339),
+	}
 }
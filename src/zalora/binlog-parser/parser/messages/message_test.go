@@ -11,8 +11,9 @@ func TestNewMessageHeader(t *testing.T) {
 	now := time.Now()
 	binlogPosition := uint32(1)
 	xid := uint64(2)
+	gtid := "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
 
-	messageHeader := NewMessageHeader("schema", "table", now, binlogPosition, xid)
+	messageHeader := NewMessageHeader("schema", "table", now, binlogPosition, xid, gtid)
 
 	if messageHeader.Schema != "schema" {
 		t.Fatal("Wrong schema in message header")
@@ -29,4 +30,8 @@ func TestNewMessageHeader(t *testing.T) {
 	if messageHeader.XId != xid {
 		t.Fatal("Wrong Xid in message header")
 	}
+
+	if messageHeader.Gtid != gtid {
+		t.Fatal("Wrong Gtid in message header")
+	}
 }
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/siddontang/go-mysql/replication"
+	"io"
+	"os"
+	"time"
+)
+
+const binlogFileHeaderSize = 4
+
+func eventStartPosition(header *replication.EventHeader) uint32 {
+	if header.LogPos < header.EventSize {
+		return 0 // artificial event, e.g. a fake rotate event
+	}
+
+	return header.LogPos - header.EventSize
+}
+
+func eventTime(header *replication.EventHeader) time.Time {
+	return time.Unix(int64(header.Timestamp), 0)
+}
+
+// Walks the event headers from the start of the file, as seeking to an offset which
+// is not an event boundary would make the parser decode garbage
+func checkEventBoundary(binlogFilename string, position uint32) error {
+	if position < binlogFileHeaderSize {
+		return fmt.Errorf("Start position %d is not at an event boundary in binlog file %s, first event is at %d", position, binlogFilename, binlogFileHeaderSize)
+	}
+
+	f, err := os.Open(binlogFilename)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	header := make([]byte, replication.EventHeaderSize)
+	previousOffset := int64(binlogFileHeaderSize)
+	offset := int64(binlogFileHeaderSize)
+
+	for offset < int64(position) {
+		_, err := f.ReadAt(header, offset)
+
+		if err == io.EOF {
+			return fmt.Errorf("Start position %d is beyond the last event in binlog file %s, last event is at %d", position, binlogFilename, offset)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		eventSize := binary.LittleEndian.Uint32(header[9:13])
+
+		if eventSize < uint32(replication.EventHeaderSize) {
+			return fmt.Errorf("Invalid event size %d at position %d in binlog file %s", eventSize, offset, binlogFilename)
+		}
+
+		previousOffset = offset
+		offset += int64(eventSize)
+	}
+
+	if offset != int64(position) {
+		return fmt.Errorf(
+			"Start position %d is not at an event boundary in binlog file %s, closest events are at %d and %d",
+			position,
+			binlogFilename,
+			previousOffset,
+			offset,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+// +build unit
+
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckEventBoundary(t *testing.T) {
+	binlogFilename := filepath.Join(os.Getenv("DATA_DIR"), "fixtures/mysql-bin.01")
+
+	for _, position := range []uint32{4, 120, 197, 1226, 1257} {
+		t.Run("Event boundary", func(t *testing.T) {
+			err := checkEventBoundary(binlogFilename, position)
+
+			if err != nil {
+				t.Fatalf("Expected position %d to be an event boundary, got %s", position, err)
+			}
+		})
+	}
+
+	testCases := []struct {
+		position      uint32
+		expectedError string
+	}{
+		{2, "not at an event boundary"},
+		{150, "closest events are at 120 and 197"},
+		{1300, "beyond the last event"},
+	}
+
+	for _, tc := range testCases {
+		t.Run("Not an event boundary", func(t *testing.T) {
+			err := checkEventBoundary(binlogFilename, tc.position)
+
+			if err == nil {
+				t.Fatalf("Expected error for position %d", tc.position)
+			}
+
+			if !strings.Contains(err.Error(), tc.expectedError) {
+				t.Fatalf("Wrong error for position %d - got %s", tc.position, err)
+			}
+		})
+	}
+
+	t.Run("Binlog file not found", func(t *testing.T) {
+		err := checkEventBoundary("/not/there", 120)
+
+		if err == nil {
+			t.Fatal("Expected error when checking non-existing file")
+		}
+	})
+}
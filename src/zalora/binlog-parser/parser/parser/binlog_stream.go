@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"zalora/binlog-parser/database"
+)
+
+// Streaming starts at StartFile and the start position of the parse options, or at the
+// StartGtidSet if given. The server id has to be unique among all replicas of the server.
+type StreamOptions struct {
+	ServerId             uint32
+	Flavor               string
+	Host                 string
+	Port                 uint16
+	User                 string
+	Password             string
+	StartFile            string
+	StartGtidSet         string
+	MaxReconnectAttempts int
+}
+
+// Streams until the context is cancelled or the stop datetime is reached. Dropped
+// connections are re-established by the binlog syncer, continuing after the last
+// received event.
+func StreamBinlogToMessages(ctx context.Context, streamOptions StreamOptions, tableMap database.TableMap, consumer ConsumerFunc, options ParseOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:             streamOptions.ServerId,
+		Flavor:               streamOptions.Flavor,
+		Host:                 streamOptions.Host,
+		Port:                 streamOptions.Port,
+		User:                 streamOptions.User,
+		Password:             streamOptions.Password,
+		MaxReconnectAttempts: streamOptions.MaxReconnectAttempts,
+		RawModeEnabled:       true, // events are decoded by the event handler
+	})
+
+	defer syncer.Close()
+
+	gtidSet, err := parseStartGtidSet(streamOptions)
+
+	if err != nil {
+		return err
+	}
+
+	streamer, err := startSync(syncer, streamOptions, options, gtidSet)
+
+	if err != nil {
+		return err
+	}
+
+	handleEvent := createEventHandler(tableMap, consumer, options, cancel)
+
+	if gtidSet != nil {
+		handleEvent = trackGtidSet(gtidSet, handleEvent)
+	}
+
+	for {
+		e, err := streamer.GetEvent(ctx)
+
+		if ctx.Err() != nil {
+			glog.V(2).Info("Stopped streaming")
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = handleEvent(e.RawData)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func parseStartGtidSet(streamOptions StreamOptions) (*syncedGtidSet, error) {
+	if streamOptions.StartGtidSet == "" {
+		return nil, nil
+	}
+
+	flavor := streamOptions.Flavor
+
+	if flavor == "" {
+		flavor = mysql.MySQLFlavor
+	}
+
+	gtidSet, err := mysql.ParseGTIDSet(flavor, streamOptions.StartGtidSet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncedGtidSet{gtidSet: gtidSet, flavor: flavor}, nil
+}
+
+func startSync(syncer *replication.BinlogSyncer, streamOptions StreamOptions, options ParseOptions, gtidSet *syncedGtidSet) (*replication.BinlogStreamer, error) {
+	if gtidSet != nil {
+		glog.V(2).Infof("Streaming from GTID set %s", gtidSet)
+
+		return syncer.StartSyncGTID(gtidSet)
+	}
+
+	position := mysql.Position{Name: streamOptions.StartFile, Pos: options.StartPosition}
+
+	glog.V(2).Infof("Streaming from position %s", position)
+
+	return syncer.StartSync(position)
+}
@@ -0,0 +1,327 @@
+// +build unit
+
+package parser
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"github.com/siddontang/go-mysql/server"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestStreamBinlogToMessages(t *testing.T) {
+	testCases := []struct {
+		name              string
+		dropAfterEvents   int
+		options           ParseOptions
+		expectedPositions []uint32
+	}{
+		{"Stream", 0, ParseOptions{}, []uint32{220, 345, 470}},
+		{"Start position", 0, ParseOptions{StartPosition: 220}, []uint32{345, 470}},
+		{"Stop datetime", 0, ParseOptions{StopDatetime: time.Date(2017, 4, 24, 4, 32, 46, 0, time.UTC)}, []uint32{220, 345}},
+		{"Reconnect after dropped connection", 1, ParseOptions{}, []uint32{220, 345, 470}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeServer := startFakeReplicationServer(t, tc.dropAfterEvents)
+			defer fakeServer.close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var positions []uint32
+
+			consumer := func(message messages.Message) error {
+				positions = append(positions, message.GetHeader().BinlogPosition)
+
+				// the fake server sends the checksums of the fixture events, but not one of the
+				// rotate event, which is cut short when it follows the events of a dropped dump
+				if binlogFile := message.GetHeader().BinlogFile; binlogFile != fakeBinlogFilename && tc.dropAfterEvents == 0 {
+					t.Errorf("Wrong binlog file %s of message", binlogFile)
+				}
+
+				if len(positions) == len(tc.expectedPositions) && tc.options.StopDatetime.IsZero() {
+					cancel() // a server never ends the stream by itself
+				}
+
+				return nil
+			}
+
+			err := StreamBinlogToMessages(ctx, fakeServer.streamOptions(), database.NewTableMap(nil), consumer, tc.options)
+
+			if err != nil {
+				t.Fatalf("Expected no error when streaming, got %s", err)
+			}
+
+			if !reflect.DeepEqual(positions, tc.expectedPositions) {
+				t.Fatalf("Wrong messages streamed - got positions %v", positions)
+			}
+
+			if tc.dropAfterEvents > 0 && fakeServer.dumpCount() < 2 {
+				t.Fatal("Expected the client to reconnect after the connection was dropped")
+			}
+		})
+	}
+
+	t.Run("Start GTID set", func(t *testing.T) {
+		const sid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+		tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "id", 1: "name", 2: "level"}})
+
+		fakeServer := startFakeReplicationServerWithEvents(t, [][]byte{
+			fixtureFormatDescription(t),
+			testGtidEvent(sid, 2), testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent(), // in the start set
+			testGtidEvent(sid, 3), testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent(),
+			testGtidEvent(sid, 4), testQueryEvent("FLUSH TABLES"),
+		}, 0)
+		defer fakeServer.close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		streamOptions := fakeServer.streamOptions()
+		streamOptions.StartGtidSet = sid + ":1-2"
+
+		var types []messages.MessageType
+		var gtids []string
+
+		consumer := func(message messages.Message) error {
+			types = append(types, message.GetType())
+			gtids = append(gtids, message.GetHeader().Gtid)
+
+			if len(gtids) == 4 {
+				cancel()
+			}
+
+			return nil
+		}
+
+		err := StreamBinlogToMessages(ctx, streamOptions, tableMap, consumer, ParseOptions{})
+
+		if err != nil {
+			t.Fatalf("Expected no error when streaming, got %s", err)
+		}
+
+		expectedTypes := []messages.MessageType{
+			messages.MESSAGE_TYPE_TRANSACTION_BEGIN, messages.MESSAGE_TYPE_INSERT, messages.MESSAGE_TYPE_TRANSACTION_COMMIT, messages.MESSAGE_TYPE_QUERY,
+		}
+
+		if !reflect.DeepEqual(types, expectedTypes) {
+			t.Fatalf("Wrong messages streamed - got %v", types)
+		}
+
+		if expectedGtids := []string{sid + ":3", sid + ":3", sid + ":3", sid + ":4"}; !reflect.DeepEqual(gtids, expectedGtids) {
+			t.Fatalf("Wrong GTIDs in message headers - got %v", gtids)
+		}
+	})
+
+	t.Run("Invalid GTID set", func(t *testing.T) {
+		streamOptions := StreamOptions{ServerId: 1, StartGtidSet: "not a gtid set"}
+
+		err := StreamBinlogToMessages(context.Background(), streamOptions, database.NewTableMap(nil), func(messages.Message) error { return nil }, ParseOptions{})
+
+		if err == nil {
+			t.Fatal("Expected error when streaming from an invalid GTID set")
+		}
+	})
+}
+
+const fakeBinlogFilename = "mysql-bin.000005"
+
+// Serves the events of a fixture binlog file to replication clients, like a master
+// would. Dumps can be cut short to simulate a dropped connection.
+type fakeReplicationServer struct {
+	t               *testing.T
+	listener        net.Listener
+	events          [][]byte
+	dropAfterEvents int
+	done            chan struct{}
+	m               sync.Mutex
+	dumps           int
+}
+
+func startFakeReplicationServer(t *testing.T, dropAfterEvents int) *fakeReplicationServer {
+	return startFakeReplicationServerWithEvents(t, readFixtureEvents(t, filepath.Join(os.Getenv("DATA_DIR"), "fixtures/mysql-bin.05")), dropAfterEvents)
+}
+
+// The first event has to be the format description event
+func startFakeReplicationServerWithEvents(t *testing.T, events [][]byte, dropAfterEvents int) *fakeReplicationServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	s := &fakeReplicationServer{
+		t:               t,
+		listener:        listener,
+		events:          events,
+		dropAfterEvents: dropAfterEvents,
+		done:            make(chan struct{}),
+	}
+
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeReplicationServer) streamOptions() StreamOptions {
+	addr := s.listener.Addr().(*net.TCPAddr)
+
+	return StreamOptions{
+		ServerId:  100,
+		Flavor:    mysql.MySQLFlavor,
+		Host:      addr.IP.String(),
+		Port:      uint16(addr.Port),
+		User:      "repl",
+		Password:  "secret",
+		StartFile: fakeBinlogFilename,
+	}
+}
+
+func (s *fakeReplicationServer) dumpCount() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.dumps
+}
+
+func (s *fakeReplicationServer) close() {
+	close(s.done)
+	s.listener.Close()
+}
+
+func (s *fakeReplicationServer) serve() {
+	for {
+		netConn, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go func() {
+			handler := &fakeReplicationHandler{server: s, netConn: netConn}
+
+			conn, err := server.NewConn(netConn, "repl", "secret", handler)
+
+			if err != nil {
+				return
+			}
+
+			handler.conn = conn
+
+			for conn.HandleCommand() == nil {
+			}
+		}()
+	}
+}
+
+type fakeReplicationHandler struct {
+	server.EmptyHandler
+	server  *fakeReplicationServer
+	netConn net.Conn
+	conn    *server.Conn
+}
+
+func (h *fakeReplicationHandler) HandleQuery(query string) (*mysql.Result, error) {
+	if strings.HasPrefix(query, "SHOW GLOBAL VARIABLES LIKE 'BINLOG_CHECKSUM'") {
+		resultset, err := mysql.BuildSimpleTextResultset([]string{"Variable_name", "Value"}, [][]interface{}{{"binlog_checksum", "CRC32"}})
+
+		return &mysql.Result{Resultset: resultset}, err
+	}
+
+	return nil, nil // SET and KILL statements
+}
+
+func (h *fakeReplicationHandler) HandleOtherCommand(cmd byte, data []byte) error {
+	switch cmd {
+	case mysql.COM_REGISTER_SLAVE:
+		return nil
+	case mysql.COM_BINLOG_DUMP:
+		return h.dump(binary.LittleEndian.Uint32(data[0:4]), string(data[10:]))
+	case mysql.COM_BINLOG_DUMP_GTID:
+		return h.dump(4, fakeBinlogFilename) // all events, transactions in the GTID set are left to the client
+	}
+
+	return fmt.Errorf("Command %d not supported", cmd)
+}
+
+func (h *fakeReplicationHandler) dump(position uint32, binlogFilename string) error {
+	h.server.m.Lock()
+	h.server.dumps++
+	drop := h.server.dumps == 1 && h.server.dropAfterEvents > 0
+	h.server.m.Unlock()
+
+	if binlogFilename != fakeBinlogFilename {
+		return fmt.Errorf("Unknown binlog file %s", binlogFilename)
+	}
+
+	events := [][]byte{artificialRotateEvent(binlogFilename, position, false), artificialEvent(h.server.events[0])}
+	sent := 0
+
+	for _, event := range h.server.events[1:] {
+		logPos := binary.LittleEndian.Uint32(event[13:17])
+
+		if logPos-uint32(len(event)) >= position {
+			events = append(events, event)
+		}
+	}
+
+	for _, event := range events {
+		if drop && sent == h.server.dropAfterEvents {
+			h.netConn.Close()
+			return nil
+		}
+
+		if err := h.conn.WritePacket(append(make([]byte, 5), event...)); err != nil {
+			return err
+		}
+
+		if eventType := replication.EventType(event[4]); eventType != replication.ROTATE_EVENT && eventType != replication.FORMAT_DESCRIPTION_EVENT {
+			sent++
+		}
+	}
+
+	<-h.server.done
+
+	return fmt.Errorf("Server shut down")
+}
+
+func readFixtureEvents(t *testing.T, binlogFilename string) [][]byte {
+	data, err := ioutil.ReadFile(binlogFilename)
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %s", err)
+	}
+
+	var events [][]byte
+
+	for offset := binlogFileHeaderSize; offset < len(data); {
+		eventSize := int(binary.LittleEndian.Uint32(data[offset+9 : offset+13]))
+		events = append(events, data[offset:offset+eventSize])
+		offset += eventSize
+	}
+
+	return events
+}
+
+func artificialEvent(event []byte) []byte {
+	event = append([]byte(nil), event...)
+	binary.LittleEndian.PutUint32(event[13:17], 0)
+
+	return event
+}
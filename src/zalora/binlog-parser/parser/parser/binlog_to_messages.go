@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"fmt"
 	"github.com/golang/glog"
 	"github.com/siddontang/go-mysql/replication"
+	"path/filepath"
 	"strings"
+	"time"
 	"zalora/binlog-parser/database"
 	"zalora/binlog-parser/parser/conversion"
 	"zalora/binlog-parser/parser/messages"
@@ -11,29 +14,300 @@ import (
 
 type ConsumerFunc func(messages.Message) error
 
-func ParseBinlogToMessages(binlogFilename string, tableMap database.TableMap, consumer ConsumerFunc) error {
-	rowRowsEventBuffer := NewRowsEventBuffer()
+// Positions are byte offsets of events in a binlog file, 0 means no limit. The start
+// position applies to the first parsed file, the stop position to the last one.
+// Parsing ends at the first transaction starting at or after the stop datetime, a zero
+// stop datetime means no limit. With ColumnTypes, the type of each column is added to
+// the rows of row messages. Values of binary columns are encoded with the BinaryEncoding,
+// base64 if empty. With RawEnumValues, ENUM and SET values aren't replaced by their labels.
+// DATETIME values are taken to be in the DatetimeLocation, UTC if nil. With
+// ChangedColumnsOnly, update messages only hold the key columns and the changed columns.
+type ParseOptions struct {
+	StartPosition      uint32
+	StopPosition       uint32
+	StopDatetime       time.Time
+	ColumnTypes        bool
+	BinaryEncoding     string
+	RawEnumValues      bool
+	DatetimeLocation   *time.Location
+	ChangedColumnsOnly bool
+}
+
+func ParseBinlogToMessages(binlogFilename string, tableMap database.TableMap, consumer ConsumerFunc, options ParseOptions) error {
+	return ParseBinlogFilesToMessages([]string{binlogFilename}, tableMap, consumer, options)
+}
+
+// The rows event buffer is shared between all files, so that a transaction spanning
+// a rotate boundary is still converted to messages in one piece
+func ParseBinlogFilesToMessages(binlogFilenames []string, tableMap database.TableMap, consumer ConsumerFunc, options ParseOptions) error {
+	if len(binlogFilenames) == 1 && options.StopPosition != 0 && options.StopPosition <= options.StartPosition {
+		return fmt.Errorf("Stop position %d must be greater than start position %d", options.StopPosition, options.StartPosition)
+	}
+
+	if len(binlogFilenames) > 0 && options.StartPosition != 0 {
+		err := checkEventBoundary(binlogFilenames[0], options.StartPosition)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := undoSchemaChanges(binlogFilenames, tableMap, options)
+
+	if err != nil {
+		return err
+	}
+
+	p := replication.NewBinlogParser()
+	p.SetRawMode(true) // events are decoded by the event handler
+
+	handleEvent := createEventHandler(tableMap, consumer, options, p.Stop)
+
+	var binlogFilename string
+	var stopPosition uint32
+
+	f := func(e *replication.BinlogEvent) error {
+		if stopPosition != 0 && eventStartPosition(e.Header) >= stopPosition {
+			glog.V(2).Infof("Reached stop position %d", stopPosition)
+			p.Stop()
+
+			return nil
+		}
+
+		err := handleEvent(encodeEvent(e))
 
+		if err != nil {
+			return err
+		}
+
+		// files don't start with a rotate event naming them like a dump from a master does
+		if formatDescriptionEvent, ok := e.Event.(*replication.FormatDescriptionEvent); ok {
+			checksum := formatDescriptionEvent.ChecksumAlgorithm == replication.BINLOG_CHECKSUM_ALG_CRC32
+			return handleEvent(artificialRotateEvent(filepath.Base(binlogFilename), binlogFileHeaderSize, checksum))
+		}
+
+		return nil
+	}
+
+	for i := range binlogFilenames {
+		binlogFilename = binlogFilenames[i]
+
+		var startPosition uint32
+
+		if i == 0 {
+			startPosition = options.StartPosition
+		}
+
+		if i == len(binlogFilenames)-1 {
+			stopPosition = options.StopPosition
+		}
+
+		glog.V(2).Infof("Parsing binlog file %s from position %d", binlogFilename, startPosition)
+
+		// the format description event is always read, even when starting at a later position
+		err := p.ParseFile(binlogFilename, int64(startPosition), f)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The schema provider has the schemas after all DDL statements in the binlog files, they
+// are undone from the start position on so that tables start with the schema valid there.
+// Statements from the stop position or datetime on are taken to be made after the schema
+// provider's schemas too, like the ones in binlog files not parsed.
+func undoSchemaChanges(binlogFilenames []string, tableMap database.TableMap, options ParseOptions) error {
 	p := replication.NewBinlogParser()
+	p.SetRawMode(true)
+
+	decoder := newEventDecoder()
+
+	var queries []database.SchemaQuery
+	var stopPosition uint32
+	var stopped bool
 
 	f := func(e *replication.BinlogEvent) error {
+		if stopPosition != 0 && eventStartPosition(e.Header) >= stopPosition {
+			stopped = true
+			p.Stop()
+
+			return nil
+		}
+
+		if e.Header.EventType != replication.QUERY_EVENT && e.Header.EventType != replication.FORMAT_DESCRIPTION_EVENT {
+			return nil
+		}
+
+		if e.Header.EventType == replication.QUERY_EVENT && !options.StopDatetime.IsZero() && !eventTime(e.Header).Before(options.StopDatetime) {
+			stopped = true
+			p.Stop()
+
+			return nil
+		}
+
+		decoded, _, err := decoder.decode(encodeEvent(e))
+
+		if err != nil {
+			return err
+		}
+
+		if queryEvent, ok := decoded.Event.(*replication.QueryEvent); ok {
+			queries = append(queries, database.SchemaQuery{DefaultSchema: string(queryEvent.Schema), Query: string(queryEvent.Query)})
+		}
+
+		return nil
+	}
+
+	for i := 0; i < len(binlogFilenames) && !stopped; i++ {
+		var offset int64
+
+		if i == 0 {
+			offset = int64(options.StartPosition)
+		}
+
+		if i == len(binlogFilenames)-1 {
+			stopPosition = options.StopPosition
+		}
+
+		err := p.ParseFile(binlogFilenames[i], offset, f)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	glog.V(2).Infof("Undoing %d queries to get the schemas at the start position", len(queries))
+
+	return tableMap.UndoQueries(queries)
+}
+
+type rawEventFunc func(data []byte) error
+
+// Converts raw binlog events, header included, to messages, shared by parsing files and
+// streaming from a server. The stop func is called once the stop datetime has been reached.
+func createEventHandler(tableMap database.TableMap, consumer ConsumerFunc, options ParseOptions, stop func()) rawEventFunc {
+	rowRowsEventBuffer := NewRowsEventBuffer()
+	decoder := newEventDecoder()
+	conversionOptions := conversion.ConversionOptions{
+		ColumnTypes:        options.ColumnTypes,
+		BinaryEncoding:     options.BinaryEncoding,
+		RawEnumValues:      options.RawEnumValues,
+		DatetimeLocation:   options.DatetimeLocation,
+		ChangedColumnsOnly: options.ChangedColumnsOnly,
+	}
+
+	// set by the GTID event starting each transaction, empty for binlogs without GTIDs
+	var gtid string
+
+	// the GTID event or BEGIN query starting the current transaction, MariaDB doesn't
+	// log a BEGIN query after its GTID event
+	var beginEventHeader *replication.EventHeader
+
+	// set by the rotate events starting each binlog file
+	var binlogFile string
+
+	endTransaction := func(xId uint64, commitEventHeader *replication.EventHeader) error {
+		if beginEventHeader == nil { // parsing started within the transaction
+			beginEventHeader = commitEventHeader
+		}
+
+		for _, message := range conversion.ConvertTransactionToMessages(xId, gtid, binlogFile, *beginEventHeader, *commitEventHeader, rowRowsEventBuffer.Drain(), conversionOptions) {
+			err := consumer(message)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		gtid = ""
+		beginEventHeader = nil
+
+		return nil
+	}
+
+	return func(data []byte) error {
+		e, tableMapMetadata, err := decoder.decode(data)
+
+		if err != nil {
+			return err
+		}
+
 		switch e.Header.EventType {
+		case replication.ROTATE_EVENT:
+			binlogFile = string(e.Event.(*replication.RotateEvent).NextLogName)
+			glog.V(3).Infof("Rotating to binlog file %s", binlogFile)
+
+			break
+
+		case replication.GTID_EVENT,
+			replication.MARIADB_GTID_EVENT:
+			gtid, err = decodeGtid(data)
+
+			if err != nil {
+				return err
+			}
+
+			glog.V(3).Infof("Starting transaction GTID %s", gtid)
+			beginEventHeader = e.Header
+
+			break
+
+		case replication.ANONYMOUS_GTID_EVENT:
+			gtid = ""
+			beginEventHeader = e.Header
+
+			break
+
 		case replication.QUERY_EVENT:
 			queryEvent := e.Event.(*replication.QueryEvent)
 			query := string(queryEvent.Query)
 
+			// transactions of non-transactional tables, and MariaDB transactions, can end
+			// with a COMMIT query instead of a XID event
+			if strings.ToUpper(strings.Trim(query, " ")) == "COMMIT" {
+				glog.V(3).Info("Ending transaction without xID")
+
+				return endTransaction(0, e.Header)
+			}
+
+			// any other query event is either the start of a transaction or a statement on
+			// its own, so no events belonging to a transaction before the stop datetime follow
+			if !options.StopDatetime.IsZero() && !eventTime(e.Header).Before(options.StopDatetime) {
+				glog.V(2).Infof("Reached stop datetime %s", options.StopDatetime)
+				stop()
+
+				return nil
+			}
+
 			if strings.ToUpper(strings.Trim(query, " ")) == "BEGIN" {
 				glog.V(3).Info("Starting transaction")
+
+				if beginEventHeader == nil {
+					beginEventHeader = e.Header
+				}
 			} else if strings.HasPrefix(strings.ToUpper(strings.Trim(query, " ")), "SAVEPOINT") {
 				glog.V(3).Info("Skipping transaction savepoint")
 			} else {
 				glog.V(3).Info("Query event")
 
-				err := consumer(conversion.ConvertQueryEventToMessage(*e.Header, *queryEvent))
+				err := tableMap.ApplyQuery(string(queryEvent.Schema), query, e.Header.LogPos)
+
+				if err != nil {
+					glog.Errorf("Failed to apply query to table schemas: %s", query)
+					return err
+				}
+
+				err = consumer(conversion.ConvertQueryEventToMessage(binlogFile, *e.Header, *queryEvent, gtid))
 
 				if err != nil {
 					return err
 				}
+
+				gtid = ""
+				beginEventHeader = nil
 			}
 
 			break
@@ -44,15 +318,7 @@ func ParseBinlogToMessages(binlogFilename string, tableMap database.TableMap, co
 
 			glog.V(3).Infof("Ending transaction xID %d", xId)
 
-			for _, message := range conversion.ConvertRowsEventsToMessages(xId, rowRowsEventBuffer.Drain()) {
-				err := consumer(message)
-
-				if err != nil {
-					return err
-				}
-			}
-
-			break
+			return endTransaction(xId, e.Header)
 
 		case replication.TABLE_MAP_EVENT:
 			tableMapEvent := e.Event.(*replication.TableMapEvent)
@@ -61,7 +327,7 @@ func ParseBinlogToMessages(binlogFilename string, tableMap database.TableMap, co
 			table := string(tableMapEvent.Table)
 			tableId := uint64(tableMapEvent.TableID)
 
-			err := tableMap.Add(tableId, schema, table)
+			err := addTableMapEvent(tableMap, tableMapEvent, tableMapMetadata)
 
 			if err != nil {
 				glog.Errorf("Failed to add table information for table %s.%s (id %d)", schema, table, tableId)
@@ -87,7 +353,7 @@ func ParseBinlogToMessages(binlogFilename string, tableMap database.TableMap, co
 			}
 
 			rowRowsEventBuffer.BufferRowsEventData(
-				conversion.NewRowsEventData(*e.Header, *rowsEvent, tableMetadata),
+				conversion.NewRowsEventData(binlogFile, *e.Header, *rowsEvent, tableMetadata),
 			)
 
 			break
@@ -98,6 +364,108 @@ func ParseBinlogToMessages(binlogFilename string, tableMap database.TableMap, co
 
 		return nil
 	}
+}
+
+// Column names from the optional metadata of the event take precedence over the table
+// map's schema, as does the other metadata over the column metadata from the schema. The
+// schema still adds the column types, character sets and unique keys not in the metadata.
+func addTableMapEvent(tableMap database.TableMap, tableMapEvent *replication.TableMapEvent, metadata *tableMapMetadata) error {
+	schema := string(tableMapEvent.Schema)
+	table := string(tableMapEvent.Table)
+	tableId := uint64(tableMapEvent.TableID)
+
+	err := tableMap.Add(tableId, schema, table)
+
+	if err != nil || metadata == nil {
+		return err
+	}
+
+	tableMetadata, _ := tableMap.LookupTableMetadata(tableId)
+
+	if metadata.hasColumnNames(tableMapEvent.ColumnCount) {
+		tableMetadata = withColumnNames(tableMetadata, metadata.columnNames)
+	}
+
+	for i, binlogColumnMetadata := range metadata.columns() {
+		columnMetadata := tableMetadata.Columns[i]
+
+		if binlogColumnMetadata.Unsigned {
+			columnMetadata.Unsigned = true
+		}
+
+		if binlogColumnMetadata.Charset != 0 {
+			columnMetadata.Charset = binlogColumnMetadata.Charset
+		}
+
+		if binlogColumnMetadata.Values != nil {
+			columnMetadata.Values = binlogColumnMetadata.Values
+		}
+
+		tableMetadata.Columns[i] = columnMetadata
+	}
+
+	if metadata.primaryKey != nil {
+		tableMetadata.PrimaryKey = metadata.primaryKey
+	}
+
+	tableMap.AddTableMetadata(tableId, tableMetadata)
+
+	return nil
+}
+
+// Columns of the schema are matched with the column names of the binlog by name, as the
+// schema may have changed since. Keys with columns missing from the binlog are dropped.
+func withColumnNames(schemaMetadata database.TableMetadata, columnNames []string) database.TableMetadata {
+	tableMetadata := database.TableMetadata{
+		Schema:  schemaMetadata.Schema,
+		Table:   schemaMetadata.Table,
+		Fields:  make(map[int]string),
+		Columns: make(map[int]database.ColumnMetadata),
+	}
+
+	indexes := make(map[int]int) // index in the schema to index in the binlog
+
+	for i, columnName := range columnNames {
+		tableMetadata.Fields[i] = columnName
+
+		for j, field := range schemaMetadata.Fields {
+			if !strings.EqualFold(field, columnName) {
+				continue
+			}
+
+			indexes[j] = i
+
+			if columnMetadata, ok := schemaMetadata.Columns[j]; ok {
+				tableMetadata.Columns[i] = columnMetadata
+			}
+		}
+	}
+
+	binlogKey := func(key []int) ([]int, bool) {
+		var binlogIndexes []int
+
+		for _, j := range key {
+			i, ok := indexes[j]
+
+			if !ok {
+				return nil, false
+			}
+
+			binlogIndexes = append(binlogIndexes, i)
+		}
+
+		return binlogIndexes, true
+	}
+
+	if key, ok := binlogKey(schemaMetadata.PrimaryKey); ok {
+		tableMetadata.PrimaryKey = key
+	}
+
+	for _, uniqueKey := range schemaMetadata.UniqueKeys {
+		if key, ok := binlogKey(uniqueKey); ok {
+			tableMetadata.UniqueKeys = append(tableMetadata.UniqueKeys, key)
+		}
+	}
 
-	return p.ParseFile(binlogFilename, 0, f)
+	return tableMetadata
 }
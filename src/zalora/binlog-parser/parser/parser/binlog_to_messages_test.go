@@ -0,0 +1,355 @@
+// +build unit
+
+package parser
+
+import (
+	"github.com/siddontang/go-mysql/replication"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestParseBinlogToMessages(t *testing.T) {
+	binlogFilename := filepath.Join(os.Getenv("DATA_DIR"), "fixtures/mysql-bin.05") // queries only, no table map needed
+
+	testCases := []struct {
+		options           ParseOptions
+		expectedPositions []uint32
+	}{
+		{ParseOptions{}, []uint32{220, 345, 470}},
+		{ParseOptions{StartPosition: 220}, []uint32{345, 470}},
+		{ParseOptions{StopPosition: 345}, []uint32{220, 345}},
+		{ParseOptions{StartPosition: 220, StopPosition: 345}, []uint32{345}},
+		{ParseOptions{StartPosition: 120, StopPosition: 121}, []uint32{220}},
+		{ParseOptions{StopDatetime: time.Date(2017, 4, 24, 4, 32, 45, 0, time.UTC)}, []uint32{220}},
+		{ParseOptions{StopDatetime: time.Date(2017, 4, 24, 4, 32, 46, 0, time.UTC)}, []uint32{220, 345}},
+	}
+
+	for _, tc := range testCases {
+		t.Run("Start and stop", func(t *testing.T) {
+			var positions []uint32
+
+			consumer := func(message messages.Message) error {
+				positions = append(positions, message.GetHeader().BinlogPosition)
+				return nil
+			}
+
+			err := ParseBinlogToMessages(binlogFilename, database.NewTableMap(nil), consumer, tc.options)
+
+			if err != nil {
+				t.Fatalf("Expected no error when parsing file, got %s", err)
+			}
+
+			if !reflect.DeepEqual(positions, tc.expectedPositions) {
+				t.Fatalf("Wrong messages parsed for options %+v - got positions %v", tc.options, positions)
+			}
+		})
+	}
+
+	t.Run("Binlog files", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "binlogs")
+		defer os.RemoveAll(dir)
+
+		copied := filepath.Join(dir, "mysql-bin.000002")
+		os.Symlink(binlogFilename, copied)
+
+		var binlogFiles []string
+
+		consumer := func(message messages.Message) error {
+			binlogFiles = append(binlogFiles, message.GetHeader().BinlogFile)
+			return nil
+		}
+
+		err := ParseBinlogFilesToMessages([]string{binlogFilename, copied}, database.NewTableMap(nil), consumer, ParseOptions{StopPosition: 345})
+
+		if err != nil {
+			t.Fatalf("Expected no error when parsing files, got %s", err)
+		}
+
+		expected := []string{"mysql-bin.05", "mysql-bin.05", "mysql-bin.05", "mysql-bin.000002", "mysql-bin.000002"}
+
+		if !reflect.DeepEqual(binlogFiles, expected) {
+			t.Fatalf("Wrong binlog files of messages - got %v", binlogFiles)
+		}
+	})
+
+	t.Run("Start position not at event boundary", func(t *testing.T) {
+		err := ParseBinlogToMessages(binlogFilename, database.NewTableMap(nil), func(messages.Message) error { return nil }, ParseOptions{StartPosition: 221})
+
+		if err == nil {
+			t.Fatal("Expected error when starting in the middle of an event")
+		}
+	})
+
+	t.Run("Stop position before start position", func(t *testing.T) {
+		err := ParseBinlogToMessages(binlogFilename, database.NewTableMap(nil), func(messages.Message) error { return nil }, ParseOptions{StartPosition: 345, StopPosition: 220})
+
+		if err == nil {
+			t.Fatal("Expected error when stop position is before start position")
+		}
+	})
+}
+
+func TestEventHandlerGtids(t *testing.T) {
+	const sid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+	tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "id", 1: "name", 2: "level"}})
+
+	var gtids []string
+
+	handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+		gtids = append(gtids, message.GetHeader().Gtid)
+		return nil
+	}, ParseOptions{}, func() {})
+
+	events := [][]byte{
+		fixtureFormatDescription(t),
+		testGtidEvent(sid, 3), testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent(),
+		testGtidEvent(sid, 4), testQueryEvent("FLUSH TABLES"),
+		testEvent(replication.ANONYMOUS_GTID_EVENT, 800, make([]byte, 25)), testQueryEvent("FLUSH TABLES"),
+		testQueryEvent("FLUSH TABLES"), // no GTID event at all
+	}
+
+	for _, event := range events {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	expectedGtids := []string{sid + ":3", sid + ":3", sid + ":3", sid + ":4", "", ""} // transaction begin, insert, commit
+
+	if !reflect.DeepEqual(gtids, expectedGtids) {
+		t.Fatalf("Wrong GTIDs in message headers - got %v", gtids)
+	}
+}
+
+func TestEventHandlerTransactions(t *testing.T) {
+	tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "id", 1: "name", 2: "level"}})
+
+	testCases := []struct {
+		name          string
+		events        [][]byte
+		beginPosition uint32
+	}{
+		{"Transaction with GTID", [][]byte{testGtidEvent("3e11fa47-71ca-11e1-9e33-c80aa9429562", 3), testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent()}, 100},
+		{"Transaction without GTID", [][]byte{testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent()}, 200},
+		{"Parsing started within transaction", [][]byte{testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent()}, 700},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var parsed []messages.Message
+
+			handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+				parsed = append(parsed, message)
+				return nil
+			}, ParseOptions{}, func() {})
+
+			for _, event := range append([][]byte{fixtureFormatDescription(t)}, tc.events...) {
+				if err := handleEvent(event); err != nil {
+					t.Fatalf("Expected no error when handling event, got %s", err)
+				}
+			}
+
+			var types []messages.MessageType
+
+			for _, message := range parsed {
+				types = append(types, message.GetType())
+			}
+
+			expectedTypes := []messages.MessageType{messages.MESSAGE_TYPE_TRANSACTION_BEGIN, messages.MESSAGE_TYPE_INSERT, messages.MESSAGE_TYPE_TRANSACTION_COMMIT}
+
+			if !reflect.DeepEqual(types, expectedTypes) {
+				t.Fatalf("Wrong messages parsed - got %v", types)
+			}
+
+			begin := parsed[0].(messages.TransactionMessage)
+			commit := parsed[2].(messages.TransactionMessage)
+
+			if begin.Header.BinlogPosition != tc.beginPosition || commit.Header.BinlogPosition != 700 {
+				t.Fatalf("Wrong positions of transaction boundaries - got %d and %d", begin.Header.BinlogPosition, commit.Header.BinlogPosition)
+			}
+
+			for _, transaction := range []messages.TransactionMessage{begin, commit} {
+				if transaction.Header.XId != 9 || transaction.EventCount != 1 || transaction.CommitTime != "2017-04-24T04:32:20Z" {
+					t.Fatalf("Wrong transaction message - got %+v", transaction)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHandlerCommitQuery(t *testing.T) {
+	tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "id", 1: "name", 2: "level"}})
+
+	var parsed []messages.Message
+
+	handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+		parsed = append(parsed, message)
+		return nil
+	}, ParseOptions{}, func() {})
+
+	// a transaction of a non-transactional table ending with a COMMIT query, then one
+	// ending with a XID event
+	events := [][]byte{
+		fixtureFormatDescription(t),
+		testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testQueryEvent("COMMIT"),
+		testQueryEvent("BEGIN"), testTableMapEvent(nil), testWriteRowsEvent(), testXidEvent(),
+	}
+
+	for _, event := range events {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	var types []messages.MessageType
+
+	for _, message := range parsed {
+		types = append(types, message.GetType())
+	}
+
+	transaction := []messages.MessageType{messages.MESSAGE_TYPE_TRANSACTION_BEGIN, messages.MESSAGE_TYPE_INSERT, messages.MESSAGE_TYPE_TRANSACTION_COMMIT}
+
+	if !reflect.DeepEqual(types, append(transaction, transaction...)) {
+		t.Fatalf("Wrong messages parsed - got %v", types)
+	}
+
+	for i, expectedXId := range map[int]uint64{2: 0, 5: 9} {
+		if commit := parsed[i].(messages.TransactionMessage); commit.Header.XId != expectedXId || commit.EventCount != 1 {
+			t.Fatalf("Wrong transaction commit message - got %+v", commit)
+		}
+	}
+}
+
+func TestEventHandlerColumnTypes(t *testing.T) {
+	tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{})
+
+	var parsed []messages.Message
+
+	handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+		parsed = append(parsed, message)
+		return nil
+	}, ParseOptions{ColumnTypes: true}, func() {})
+
+	for _, event := range [][]byte{fixtureFormatDescription(t), testTableMapEvent(fullTableMapMetadata()), testWriteRowsEvent(), testXidEvent()} {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	expected := map[string]messages.MessageColumnType{
+		"id":    {Position: 1, Type: "INT", Unsigned: true},
+		"name":  {Position: 2, Type: "VARCHAR", Length: 80, Nullable: true},
+		"level": {Position: 3, Type: "TINYINT"},
+	}
+
+	if columns := parsed[1].(messages.InsertMessage).Data.Columns; !reflect.DeepEqual(columns, expected) {
+		t.Fatalf("Wrong column types - got %+v", columns)
+	}
+}
+
+func TestEventHandlerChangedColumnsOnly(t *testing.T) {
+	tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "id", 1: "name", 2: "level"}, keys: []database.Key{{Name: "PRIMARY", Columns: []string{"id"}}}})
+
+	var parsed []messages.Message
+
+	handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+		parsed = append(parsed, message)
+		return nil
+	}, ParseOptions{ChangedColumnsOnly: true}, func() {})
+
+	for _, event := range [][]byte{fixtureFormatDescription(t), testTableMapEvent(nil), testMinimalUpdateRowsEvent(), testXidEvent()} {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	update := parsed[1].(messages.UpdateMessage)
+
+	if update.OldData.MappingNotice != "" || update.NewData.MappingNotice != "" {
+		t.Fatalf("Expected no mapping notice - got %s", update.NewData.MappingNotice)
+	}
+
+	if !reflect.DeepEqual(update.Changes, []string{"name"}) || !reflect.DeepEqual(update.NewData.Row, messages.MessageRow{"name": "Moe"}) {
+		t.Fatalf("Wrong changes - got %v with row %v", update.Changes, update.NewData.Row)
+	}
+}
+
+func TestEventHandlerMinimalRowImage(t *testing.T) {
+	tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "id", 1: "name", 2: "level"}, keys: []database.Key{{Name: "PRIMARY", Columns: []string{"id"}}}})
+
+	var parsed []messages.Message
+
+	handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+		parsed = append(parsed, message)
+		return nil
+	}, ParseOptions{}, func() {})
+
+	for _, event := range [][]byte{fixtureFormatDescription(t), testTableMapEvent(nil), testMinimalUpdateRowsEvent(), testXidEvent()} {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	update := parsed[1].(messages.UpdateMessage)
+
+	expectedOldData := messages.MessageRowData{Row: messages.MessageRow{"id": int32(7)}, NotLogged: []string{"name", "level"}}
+	expectedNewData := messages.MessageRowData{Row: messages.MessageRow{"name": "Moe"}, NotLogged: []string{"id", "level"}}
+
+	if !reflect.DeepEqual(update.OldData, expectedOldData) || !reflect.DeepEqual(update.NewData, expectedNewData) {
+		t.Fatalf("Wrong row data - got %+v and %+v", update.OldData, update.NewData)
+	}
+
+	if !reflect.DeepEqual(update.Key, messages.MessageRow{"id": int32(7)}) {
+		t.Fatalf("Wrong key - got %v", update.Key)
+	}
+}
+
+// Sets the name of user 7 to Moe, as logged with binlog_row_image=MINIMAL
+func testMinimalUpdateRowsEvent() []byte {
+	body := []byte{42, 0, 0, 0, 0, 0, 1, 0} // table id, STMT_END_F
+	body = append(body, 2, 0)               // extra data length
+	body = append(body, 3, 0x01, 0x02)      // column count, columns before and after the update
+	body = append(body, 0x00, 7, 0, 0, 0)
+	body = append(body, 0x00, 3, 'M', 'o', 'e')
+
+	return testEvent(replication.UPDATE_ROWS_EVENTv2, 600, body)
+}
+
+func TestUndoSchemaChanges(t *testing.T) {
+	binlogFilename := filepath.Join(os.Getenv("DATA_DIR"), "fixtures/mysql-bin.06") // ALTER TABLE language at 802
+
+	testCases := []struct {
+		name           string
+		options        ParseOptions
+		expectedFields int
+	}{
+		{"Until the end of the file", ParseOptions{StartPosition: 589}, 3},
+		{"Until the stop position", ParseOptions{StartPosition: 589, StopPosition: 802}, 4},
+		{"Until the stop datetime", ParseOptions{StartPosition: 589, StopDatetime: time.Unix(1493012732, 0)}, 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "language_id", 1: "name", 2: "last_update", 3: "some_field"}})
+
+			if err := undoSchemaChanges([]string{binlogFilename}, tableMap, tc.options); err != nil {
+				t.Fatalf("Expected no error when undoing schema changes, got %s", err)
+			}
+
+			if err := tableMap.Add(1, "test_db", "language"); err != nil {
+				t.Fatal(err)
+			}
+
+			if tableMetadata, _ := tableMap.LookupTableMetadata(1); len(tableMetadata.Fields) != tc.expectedFields {
+				t.Fatalf("Expected %d fields at the start position, got %v", tc.expectedFields, tableMetadata.Fields)
+			}
+		})
+	}
+}
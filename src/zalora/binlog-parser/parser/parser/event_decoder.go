@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/siddontang/go-mysql/replication"
+	"hash/crc32"
+)
+
+// Decodes raw events, header included. The binlog parser fails on TABLE_MAP_EVENTs with
+// optional metadata (binlog_row_metadata in MySQL 8), so the metadata is cut off before
+// the event is passed to the parser and decoded separately. Partial updates of JSON
+// values are decoded as updates of the whole values, see partial_json.go.
+type eventDecoder struct {
+	parser       *replication.BinlogParser
+	tableIdSize  int
+	checksumSize int
+	tables       map[uint64]*replication.TableMapEvent
+}
+
+// Decimals are decoded exactly and temporal values as times, see conversion.convertValue
+func newEventDecoder() *eventDecoder {
+	p := replication.NewBinlogParser()
+	p.SetUseDecimal(true)
+	p.SetParseTime(true)
+
+	return &eventDecoder{parser: p, tableIdSize: 6, tables: make(map[uint64]*replication.TableMapEvent)}
+}
+
+func (d *eventDecoder) decode(data []byte) (*replication.BinlogEvent, *tableMapMetadata, error) {
+	var rawMetadata []byte
+
+	if len(data) > replication.EventHeaderSize && replication.EventType(data[4]) == replication.TABLE_MAP_EVENT {
+		var err error
+
+		data, rawMetadata, err = d.splitTableMapMetadata(data)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(data) > replication.EventHeaderSize && replication.EventType(data[4]) == partialUpdateRowsEvent {
+		e, err := d.decodePartialUpdateRowsEvent(data)
+		return e, nil, err
+	}
+
+	e, err := d.parser.Parse(data)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch event := e.Event.(type) {
+	case *replication.FormatDescriptionEvent:
+		d.tableIdSize = 6
+
+		if event.EventTypeHeaderLengths[replication.TABLE_MAP_EVENT-1] == 6 {
+			d.tableIdSize = 4
+		}
+
+		d.checksumSize = 0
+
+		if event.ChecksumAlgorithm == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+			d.checksumSize = 4
+		}
+
+	case *replication.RowsEvent:
+		if err := d.decodeTemporalValues(data, event); err != nil {
+			return nil, nil, fmt.Errorf("Invalid rows event at position %d: %s", e.Header.LogPos, err)
+		}
+
+	case *replication.TableMapEvent:
+		d.tables[event.TableID] = event
+
+		if rawMetadata != nil {
+			metadata, err := decodeTableMapMetadata(event, rawMetadata)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid optional metadata in table map event for table %s.%s: %s", event.Schema, event.Table, err)
+			}
+
+			return e, &metadata, nil
+		}
+	}
+
+	return e, nil, nil
+}
+
+// Walks the fixed part of the table map event body to find where the optional metadata
+// starts, and returns the event without it
+func (d *eventDecoder) splitTableMapMetadata(data []byte) ([]byte, []byte, error) {
+	if len(data) < replication.EventHeaderSize+d.checksumSize {
+		return data, nil, nil // left to the parser to fail on
+	}
+
+	body := data[replication.EventHeaderSize : len(data)-d.checksumSize]
+	truncated := fmt.Errorf("Truncated table map event at position %d", binary.LittleEndian.Uint32(data[13:17]))
+
+	pos := d.tableIdSize + 2
+
+	for i := 0; i < 2; i++ { // schema and table name, each followed by 0x00
+		if pos >= len(body) {
+			return nil, nil, truncated
+		}
+
+		pos += 1 + int(body[pos]) + 1
+	}
+
+	if pos >= len(body) {
+		return nil, nil, truncated
+	}
+
+	columnCount, n, ok := lengthEncodedInt(body[pos:])
+	pos += n + int(columnCount)
+
+	if !ok || pos >= len(body) {
+		return nil, nil, truncated
+	}
+
+	metaLength, n, ok := lengthEncodedInt(body[pos:])
+	pos += n + int(metaLength) + int(columnCount+7)/8
+
+	if !ok {
+		return nil, nil, truncated
+	}
+
+	if pos >= len(body) {
+		return data, nil, nil
+	}
+
+	stripped := make([]byte, 0, replication.EventHeaderSize+pos+d.checksumSize)
+	stripped = append(stripped, data[:replication.EventHeaderSize]...)
+	stripped = append(stripped, body[:pos]...)
+	stripped = append(stripped, data[len(data)-d.checksumSize:]...)
+	binary.LittleEndian.PutUint32(stripped[9:13], uint32(len(stripped)))
+
+	return stripped, body[pos:], nil
+}
+
+// Events from ParseFile only carry the event body as raw data
+func encodeEvent(e *replication.BinlogEvent) []byte {
+	data := make([]byte, replication.EventHeaderSize, replication.EventHeaderSize+len(e.RawData))
+
+	binary.LittleEndian.PutUint32(data[0:4], e.Header.Timestamp)
+	data[4] = byte(e.Header.EventType)
+	binary.LittleEndian.PutUint32(data[5:9], e.Header.ServerID)
+	binary.LittleEndian.PutUint32(data[9:13], e.Header.EventSize)
+	binary.LittleEndian.PutUint32(data[13:17], e.Header.LogPos)
+	binary.LittleEndian.PutUint16(data[17:19], e.Header.Flags)
+
+	return append(data, e.RawData...)
+}
+
+// A master starts each dump with a rotate event naming the binlog file, flagged as
+// artificial and without a position in the binlog
+func artificialRotateEvent(binlogFilename string, position uint32, checksum bool) []byte {
+	event := make([]byte, replication.EventHeaderSize+8)
+	event[4] = byte(replication.ROTATE_EVENT)
+	binary.LittleEndian.PutUint64(event[replication.EventHeaderSize:], uint64(position))
+	event = append(event, binlogFilename...)
+	binary.LittleEndian.PutUint32(event[9:13], uint32(len(event)))
+	binary.LittleEndian.PutUint16(event[17:19], 0x20)
+
+	if checksum {
+		binary.LittleEndian.PutUint32(event[9:13], uint32(len(event)+4))
+		event = append(event, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(event[len(event)-4:], crc32.ChecksumIEEE(event[:len(event)-4]))
+	}
+
+	return event
+}
@@ -0,0 +1,251 @@
+// +build unit
+
+package parser
+
+import (
+	"encoding/binary"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestEventDecoder(t *testing.T) {
+	t.Run("Table map event with optional metadata", func(t *testing.T) {
+		decoder := newEventDecoder()
+		decodeFixtureFormatDescription(t, decoder)
+
+		e, metadata, err := decoder.decode(testTableMapEvent(fullTableMapMetadata()))
+
+		if err != nil {
+			t.Fatalf("Expected no error when decoding table map event, got %s", err)
+		}
+
+		tableMapEvent := e.Event.(*replication.TableMapEvent)
+
+		if string(tableMapEvent.Table) != "users" || tableMapEvent.ColumnCount != 3 || tableMapEvent.TableID != 42 {
+			t.Fatalf("Wrong table map event decoded - got %+v", tableMapEvent)
+		}
+
+		if metadata == nil || !reflect.DeepEqual(metadata.columnNames, []string{"id", "name", "level"}) {
+			t.Fatalf("Wrong optional metadata decoded - got %+v", metadata)
+		}
+	})
+
+	t.Run("Table map event without optional metadata", func(t *testing.T) {
+		decoder := newEventDecoder()
+		decodeFixtureFormatDescription(t, decoder)
+
+		_, metadata, err := decoder.decode(testTableMapEvent(nil))
+
+		if err != nil {
+			t.Fatalf("Expected no error when decoding table map event, got %s", err)
+		}
+
+		if metadata != nil {
+			t.Fatalf("Expected no optional metadata - got %+v", metadata)
+		}
+	})
+}
+
+func TestParseEventsWithTableMapMetadata(t *testing.T) {
+	testCases := []struct {
+		name            string
+		metadata        []byte
+		expectedRow     messages.MessageRow
+		expectedCharset uint64
+		expectedKeys    []int
+		expectedLookups int
+	}{
+		{
+			"Column names from metadata",
+			fullTableMapMetadata(),
+			messages.MessageRow{"id": uint32(7), "name": "Max", "level": int8(-1)},
+			255,
+			[]int{0},
+			1,
+		},
+		{
+			"Column names from schema provider",
+			[]byte{metadataSignedness, 1, 0x80},
+			messages.MessageRow{"user_id": uint32(7), "user_name": "Max", "user_level": int8(-1)},
+			0,
+			[]int{1},
+			1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &testSchemaProvider{fields: map[int]string{0: "user_id", 1: "user_name", 2: "user_level"}, keys: []database.Key{{Name: "PRIMARY", Columns: []string{"user_name"}}}}
+			tableMap := database.NewTableMapFromSchemaProvider(provider)
+
+			var parsed []messages.Message
+
+			handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+				parsed = append(parsed, message)
+				return nil
+			}, ParseOptions{}, func() {})
+
+			events := [][]byte{fixtureFormatDescription(t), testTableMapEvent(tc.metadata), testWriteRowsEvent(), testXidEvent()}
+
+			for _, event := range events {
+				if err := handleEvent(event); err != nil {
+					t.Fatalf("Expected no error when handling event, got %s", err)
+				}
+			}
+
+			if len(parsed) != 3 {
+				t.Fatalf("Expected one message between transaction boundaries - got %v", parsed)
+			}
+
+			insert := parsed[1].(messages.InsertMessage)
+
+			if !reflect.DeepEqual(insert.Data.Row, tc.expectedRow) {
+				t.Fatalf("Wrong row - got %v", insert.Data.Row)
+			}
+
+			tableMetadata, _ := tableMap.LookupTableMetadata(42)
+
+			if !tableMetadata.Columns[0].Unsigned || tableMetadata.Columns[2].Unsigned || tableMetadata.Columns[1].Charset != tc.expectedCharset {
+				t.Fatalf("Wrong column metadata - got %v", tableMetadata.Columns)
+			}
+
+			if !reflect.DeepEqual(tableMetadata.PrimaryKey, tc.expectedKeys) {
+				t.Fatalf("Wrong primary key in table metadata - got %v", tableMetadata.PrimaryKey)
+			}
+
+			if provider.lookups != tc.expectedLookups {
+				t.Fatalf("Expected %d schema lookups - got %d", tc.expectedLookups, provider.lookups)
+			}
+		})
+	}
+}
+
+func TestTableMapMetadataWithSchema(t *testing.T) {
+	// the schema has the columns of the binlog in another order, with types and a unique key
+	provider := &testSchemaProvider{
+		fields: map[int]string{0: "level", 1: "id", 2: "name"},
+		types:  map[int]string{0: "tinyint(4)", 1: "int(10) unsigned", 2: "varchar(20)"},
+		keys:   []database.Key{{Name: "name_unique", Columns: []string{"name"}}},
+	}
+	tableMap := database.NewTableMapFromSchemaProvider(provider)
+
+	decoder := newEventDecoder()
+	decodeFixtureFormatDescription(t, decoder)
+
+	e, metadata, err := decoder.decode(testTableMapEvent(fullTableMapMetadata()))
+
+	if err != nil {
+		t.Fatalf("Expected no error when decoding table map event, got %s", err)
+	}
+
+	if err := addTableMapEvent(tableMap, e.Event.(*replication.TableMapEvent), metadata); err != nil {
+		t.Fatalf("Expected no error when adding table map event, got %s", err)
+	}
+
+	tableMetadata, _ := tableMap.LookupTableMetadata(42)
+
+	if !reflect.DeepEqual(tableMetadata.Fields, map[int]string{0: "id", 1: "name", 2: "level"}) {
+		t.Fatalf("Wrong fields in table metadata - got %v", tableMetadata.Fields)
+	}
+
+	if tableMetadata.Columns[0].Type != "int(10) unsigned" || tableMetadata.Columns[1].Type != "varchar(20)" || tableMetadata.Columns[1].Charset != 255 {
+		t.Fatalf("Wrong column metadata - got %v", tableMetadata.Columns)
+	}
+
+	if !reflect.DeepEqual(tableMetadata.PrimaryKey, []int{0}) || !reflect.DeepEqual(tableMetadata.UniqueKeys, [][]int{{1}}) {
+		t.Fatalf("Wrong keys in table metadata - got %v and %v", tableMetadata.PrimaryKey, tableMetadata.UniqueKeys)
+	}
+}
+
+type testSchemaProvider struct {
+	fields  map[int]string
+	types   map[int]string
+	keys    []database.Key
+	lookups int
+}
+
+func (p *testSchemaProvider) GetColumns(schema, table string) ([]database.Column, error) {
+	p.lookups++
+
+	columns := make([]database.Column, len(p.fields))
+
+	for i := range columns {
+		columns[i] = database.Column{Name: p.fields[i], Type: p.types[i]}
+	}
+
+	return columns, nil
+}
+
+func (p *testSchemaProvider) GetKeys(schema, table string) ([]database.Key, error) {
+	return p.keys, nil
+}
+
+// A table `test_db`.`users` (id INT UNSIGNED, name VARCHAR(20), level TINYINT)
+// with table id 42, as logged with binlog_row_metadata=FULL
+func fullTableMapMetadata() []byte {
+	return []byte{
+		metadataSignedness, 1, 0x80, // id is unsigned, level is signed
+		metadataDefaultCharset, 3, 0xfc, 255, 0, // utf8mb4_0900_ai_ci
+		metadataColumnName, 14, 2, 'i', 'd', 4, 'n', 'a', 'm', 'e', 5, 'l', 'e', 'v', 'e', 'l',
+		metadataSimplePrimaryKey, 1, 0,
+		12, 1, 0xe0, // column visibility, not decoded
+	}
+}
+
+func testTableMapEvent(optionalMetadata []byte) []byte {
+	body := []byte{42, 0, 0, 0, 0, 0, 1, 0}
+	body = append(body, 7)
+	body = append(body, "test_db"...)
+	body = append(body, 0, 5)
+	body = append(body, "users"...)
+	body = append(body, 0, 3, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_TINY)
+	body = append(body, 2, 80, 0) // column metadata: VARCHAR(20) in utf8mb4
+	body = append(body, 0x02)     // null bitmap
+	body = append(body, optionalMetadata...)
+
+	return testEvent(replication.TABLE_MAP_EVENT, 500, body)
+}
+
+func testWriteRowsEvent() []byte {
+	body := []byte{42, 0, 0, 0, 0, 0, 1, 0} // table id, STMT_END_F
+	body = append(body, 2, 0)               // extra data length
+	body = append(body, 3, 0x07)            // column count, columns present
+	body = append(body, 0x00, 7, 0, 0, 0, 3, 'M', 'a', 'x', 0xff)
+
+	return testEvent(replication.WRITE_ROWS_EVENTv2, 600, body)
+}
+
+func testXidEvent() []byte {
+	return testEvent(replication.XID_EVENT, 700, []byte{9, 0, 0, 0, 0, 0, 0, 0})
+}
+
+// Events with a dummy CRC32 checksum, as the fixture binlogs have checksums
+func testEvent(eventType replication.EventType, logPos uint32, body []byte) []byte {
+	data := make([]byte, replication.EventHeaderSize, replication.EventHeaderSize+len(body)+4)
+
+	binary.LittleEndian.PutUint32(data[0:4], 1493008340)
+	data[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(data[5:9], 1)
+	binary.LittleEndian.PutUint32(data[9:13], uint32(replication.EventHeaderSize+len(body)+4))
+	binary.LittleEndian.PutUint32(data[13:17], logPos)
+
+	data = append(data, body...)
+
+	return append(data, 0, 0, 0, 0)
+}
+
+func fixtureFormatDescription(t *testing.T) []byte {
+	return readFixtureEvents(t, filepath.Join(os.Getenv("DATA_DIR"), "fixtures/mysql-bin.05"))[0]
+}
+
+func decodeFixtureFormatDescription(t *testing.T, decoder *eventDecoder) {
+	if _, _, err := decoder.decode(fixtureFormatDescription(t)); err != nil {
+		t.Fatalf("Failed to decode format description event: %s", err)
+	}
+}
@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/satori/go.uuid"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"sync"
+)
+
+// The binlog syncer reconnects with the GTID set it was started with, and only updates
+// it for decoded GTID events, which it doesn't see in raw mode. The set is updated here
+// once a transaction is handled instead, guarded against concurrent reads of the syncer.
+type syncedGtidSet struct {
+	m       sync.Mutex
+	gtidSet mysql.GTIDSet
+	flavor  string
+}
+
+func (s *syncedGtidSet) String() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.gtidSet.String()
+}
+
+func (s *syncedGtidSet) Encode() []byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.gtidSet.Encode()
+}
+
+func (s *syncedGtidSet) Equal(o mysql.GTIDSet) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.gtidSet.Equal(o)
+}
+
+func (s *syncedGtidSet) Contain(o mysql.GTIDSet) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.gtidSet.Contain(o)
+}
+
+func (s *syncedGtidSet) Update(gtid string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.gtidSet.Update(gtid)
+}
+
+func (s *syncedGtidSet) Clone() mysql.GTIDSet {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.gtidSet.Clone()
+}
+
+func (s *syncedGtidSet) containsGtid(gtid string) bool {
+	gtidSet, err := mysql.ParseGTIDSet(s.flavor, gtid)
+
+	return err == nil && s.Contain(gtidSet)
+}
+
+// Adds the GTID of each handled transaction to the GTID set. After a reconnect the
+// server sends all transactions missing in the set again, including ones which were
+// received but not handled yet, so transactions already in the set are skipped. GTID
+// events of transactions not skipped are handled too, for the GTIDs of the messages.
+func trackGtidSet(gtidSet *syncedGtidSet, handleEvent rawEventFunc) rawEventFunc {
+	var gtid string
+	skipping := false
+
+	return func(data []byte) error {
+		if len(data) < replication.EventHeaderSize {
+			return handleEvent(data)
+		}
+
+		switch replication.EventType(data[4]) {
+		case replication.GTID_EVENT, replication.MARIADB_GTID_EVENT:
+			var err error
+
+			gtid, err = decodeGtid(data)
+
+			if err != nil {
+				return err
+			}
+
+			skipping = gtidSet.containsGtid(gtid)
+
+			if skipping {
+				glog.V(2).Infof("Skipping transaction %s received again after reconnect", gtid)
+				return nil
+			}
+
+			return handleEvent(data)
+
+		case replication.ROTATE_EVENT, replication.FORMAT_DESCRIPTION_EVENT:
+			return handleEvent(data)
+		}
+
+		if skipping {
+			return nil
+		}
+
+		err := handleEvent(data)
+
+		if err != nil {
+			return err
+		}
+
+		if gtid != "" && isTransactionEnd(data) {
+			err = gtidSet.Update(gtid)
+			gtid = ""
+		}
+
+		return err
+	}
+}
+
+// A transaction ends with a XID event, or is a single statement like DDL, or ends with
+// a COMMIT query for non-transactional tables
+func isTransactionEnd(data []byte) bool {
+	switch replication.EventType(data[4]) {
+	case replication.XID_EVENT:
+		return true
+	case replication.QUERY_EVENT:
+		return !isBeginQueryEvent(data)
+	}
+
+	return false
+}
+
+func isBeginQueryEvent(data []byte) bool {
+	body := data[replication.EventHeaderSize:]
+
+	// thread id (4), execution time (4), schema length (1), error code (2), status vars length (2)
+	if len(body) < 13 {
+		return false
+	}
+
+	schemaLength := int(body[8])
+	statusVarsLength := int(body[11]) | int(body[12])<<8
+	queryStart := 13 + statusVarsLength + schemaLength + 1
+
+	return queryStart+5 <= len(body) && string(body[queryStart:queryStart+5]) == "BEGIN"
+}
+
+func decodeGtid(data []byte) (string, error) {
+	body := data[replication.EventHeaderSize:]
+
+	if replication.EventType(data[4]) == replication.MARIADB_GTID_EVENT {
+		// sequence number (8), domain id (4), server id from the header
+		if len(body) < 12 {
+			return "", fmt.Errorf("Truncated MariaDB GTID event")
+		}
+
+		event := replication.MariadbGTIDEvent{}
+		event.Decode(body)
+		event.GTID.ServerID = uint32(data[5]) | uint32(data[6])<<8 | uint32(data[7])<<16 | uint32(data[8])<<24
+
+		return fmt.Sprintf("%d-%d-%d", event.GTID.DomainID, event.GTID.ServerID, event.GTID.SequenceNumber), nil
+	}
+
+	event := replication.GTIDEvent{}
+
+	if len(body) < 25 || event.Decode(body) != nil {
+		return "", fmt.Errorf("Truncated GTID event")
+	}
+
+	sid, err := uuid.FromBytes(event.SID)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d", sid, event.GNO), nil
+}
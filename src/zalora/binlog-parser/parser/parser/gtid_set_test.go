@@ -0,0 +1,68 @@
+// +build unit
+
+package parser
+
+import (
+	"encoding/binary"
+	"github.com/satori/go.uuid"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"testing"
+)
+
+func TestTrackGtidSet(t *testing.T) {
+	const sid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+	startSet, _ := mysql.ParseGTIDSet(mysql.MySQLFlavor, sid+":1-2")
+	gtidSet := &syncedGtidSet{gtidSet: startSet, flavor: mysql.MySQLFlavor}
+
+	var handled []replication.EventType
+
+	handleEvent := trackGtidSet(gtidSet, func(data []byte) error {
+		handled = append(handled, replication.EventType(data[4]))
+		return nil
+	})
+
+	events := [][]byte{
+		testGtidEvent(sid, 3), testQueryEvent("BEGIN"), testXidEvent(),
+		testGtidEvent(sid, 3), testQueryEvent("BEGIN"), testXidEvent(), // received again after a reconnect
+		testGtidEvent(sid, 4), testQueryEvent("DROP TABLE t"),
+	}
+
+	for _, event := range events {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	expectedHandled := []replication.EventType{
+		replication.GTID_EVENT, replication.QUERY_EVENT, replication.XID_EVENT,
+		replication.GTID_EVENT, replication.QUERY_EVENT,
+	}
+
+	if !reflect.DeepEqual(handled, expectedHandled) {
+		t.Fatalf("Wrong events handled - got %v", handled)
+	}
+
+	if gtidSet.String() != sid+":1-4" {
+		t.Fatalf("Wrong GTID set after handling events - got %s", gtidSet)
+	}
+}
+
+func testGtidEvent(sid string, gno int64) []byte {
+	body := []byte{1}
+	body = append(body, uuid.FromStringOrNil(sid).Bytes()...)
+	body = append(body, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(body[17:], uint64(gno))
+
+	return testEvent(replication.GTID_EVENT, 100, body)
+}
+
+func testQueryEvent(query string) []byte {
+	body := make([]byte, 13) // thread id, execution time, no schema, error code, no status vars
+	body = append(body, 0)
+	body = append(body, query...)
+
+	return testEvent(replication.QUERY_EVENT, 200, body)
+}
@@ -0,0 +1,619 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"strconv"
+	"zalora/binlog-parser/parser/conversion"
+)
+
+// Logged by MySQL 8 with binlog_row_value_options=PARTIAL_JSON instead of an
+// UPDATE_ROWS_EVENTv2, go-mysql doesn't know it
+const partialUpdateRowsEvent replication.EventType = 39
+
+// See Json_diff in MySQL's sql/json_diff.h
+const (
+	jsonDiffReplace = 0
+	jsonDiffInsert  = 1
+	jsonDiffRemove  = 2
+
+	valueOptionPartialJsonUpdates = 1
+)
+
+type jsonDiff struct {
+	operation byte
+	path      string
+	value     []byte // JSON text
+}
+
+// The values of a row image, by column, as they are logged including their length prefix
+type rowImage struct {
+	nullBitmap []byte
+	columns    []int
+	values     map[int][]byte
+}
+
+// A partial update is turned into an UPDATE_ROWS_EVENTv2 by leaving out the value
+// options of the after images and emptying the partially updated JSON values. The JSON
+// diffs of these values are applied to the before images once the event is decoded.
+func (d *eventDecoder) decodePartialUpdateRowsEvent(data []byte) (*replication.BinlogEvent, error) {
+	if len(data) < replication.EventHeaderSize+d.checksumSize {
+		return nil, fmt.Errorf("Truncated partial update rows event")
+	}
+
+	position := binary.LittleEndian.Uint32(data[13:17])
+	body := data[replication.EventHeaderSize : len(data)-d.checksumSize]
+	truncated := fmt.Errorf("Truncated partial update rows event at position %d", position)
+
+	if len(body) < d.tableIdSize+4 {
+		return nil, truncated
+	}
+
+	tableId := littleEndianUint(body[:d.tableIdSize])
+	tableMapEvent, ok := d.tables[tableId]
+
+	if !ok {
+		return nil, fmt.Errorf("No table map event for table id %d of partial update rows event at position %d", tableId, position)
+	}
+
+	pos := d.tableIdSize + 2
+	pos += int(binary.LittleEndian.Uint16(body[pos:])) // extra data, including its length
+
+	columnCount, n, ok := lengthEncodedInt(body[minInt(pos, len(body)):])
+	bitmapSize := int(columnCount+7) / 8
+	pos += n
+
+	if !ok || columnCount != tableMapEvent.ColumnCount || pos+2*bitmapSize > len(body) {
+		return nil, truncated
+	}
+
+	beforeBitmap := body[pos : pos+bitmapSize]
+	afterBitmap := body[pos+bitmapSize : pos+2*bitmapSize]
+	pos += 2 * bitmapSize
+
+	rewritten := append([]byte{}, data[:replication.EventHeaderSize]...)
+	rewritten = append(rewritten, body[:pos]...)
+
+	var partialUpdates []map[int][]jsonDiff
+
+	for pos < len(body) {
+		n, err := rowImageLength(body[pos:], tableMapEvent, beforeBitmap)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s at position %d", err, position)
+		}
+
+		rewritten = append(rewritten, body[pos:pos+n]...)
+		pos += n
+
+		valueOptions, n, ok := lengthEncodedInt(body[pos:])
+		pos += n
+
+		if !ok {
+			return nil, truncated
+		}
+
+		var partialColumns map[int]bool
+
+		if valueOptions&valueOptionPartialJsonUpdates != 0 {
+			partialColumns, n, err = readPartialBits(body[pos:], tableMapEvent)
+
+			if err != nil {
+				return nil, truncated
+			}
+
+			pos += n
+		}
+
+		image, n, err := readRowImage(body[pos:], tableMapEvent, afterBitmap)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s at position %d", err, position)
+		}
+
+		pos += n
+		diffs := make(map[int][]jsonDiff)
+
+		for _, column := range image.columns {
+			value := image.values[column]
+
+			if value == nil || !partialColumns[column] {
+				continue
+			}
+
+			lengthSize := int(tableMapEvent.ColumnMeta[column])
+			diffs[column], err = d.decodeJsonDiffs(tableMapEvent, column, value[lengthSize:])
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid JSON diff of column %d at position %d: %s", column, position, err)
+			}
+
+			image.values[column] = make([]byte, lengthSize) // an empty JSON value
+		}
+
+		rewritten = append(rewritten, image.bytes()...)
+		partialUpdates = append(partialUpdates, diffs)
+	}
+
+	rewritten = append(rewritten, data[len(data)-d.checksumSize:]...)
+	rewritten[4] = byte(replication.UPDATE_ROWS_EVENTv2)
+	binary.LittleEndian.PutUint32(rewritten[9:13], uint32(len(rewritten)))
+
+	e, err := d.parser.Parse(rewritten)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rowsEvent := e.Event.(*replication.RowsEvent)
+
+	if err := d.decodeTemporalValues(rewritten, rowsEvent); err != nil {
+		return nil, fmt.Errorf("Invalid partial update rows event at position %d: %s", position, err)
+	}
+
+	for i, diffs := range partialUpdates {
+		before, after := rowsEvent.Rows[2*i], rowsEvent.Rows[2*i+1]
+
+		for column, columnDiffs := range diffs {
+			document, ok := before[column].([]byte)
+
+			if !ok || !isBitSet(beforeBitmap, column) {
+				return nil, fmt.Errorf("Partial JSON update of column %d at position %d can't be applied without the value before the update, see binlog_row_image", column, position)
+			}
+
+			after[column], err = applyJsonDiffs(document, columnDiffs)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to apply JSON diff of column %d at position %d: %s", column, position, err)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// One bit for each JSON column of the table, set if the column is updated partially
+func readPartialBits(data []byte, tableMapEvent *replication.TableMapEvent) (map[int]bool, int, error) {
+	var jsonColumns []int
+
+	for i, columnType := range tableMapEvent.ColumnType {
+		if columnType == mysql.MYSQL_TYPE_JSON {
+			jsonColumns = append(jsonColumns, i)
+		}
+	}
+
+	size := (len(jsonColumns) + 7) / 8
+
+	if size > len(data) {
+		return nil, 0, fmt.Errorf("truncated partial bits")
+	}
+
+	partialColumns := make(map[int]bool)
+
+	for i, column := range jsonColumns {
+		partialColumns[column] = isBitSet(data, i)
+	}
+
+	return partialColumns, size, nil
+}
+
+func (d *eventDecoder) decodeJsonDiffs(tableMapEvent *replication.TableMapEvent, column int, data []byte) ([]jsonDiff, error) {
+	var diffs []jsonDiff
+	reader := packedReader{data: data}
+
+	for !reader.done() {
+		diff := jsonDiff{operation: reader.data[0]}
+		reader.data = reader.data[1:]
+		diff.path = reader.nextString()
+
+		if diff.operation != jsonDiffRemove {
+			binaryValue := reader.nextString()
+
+			if reader.err != nil {
+				break
+			}
+
+			value, err := d.decodeJsonValue(tableMapEvent, column, []byte(binaryValue))
+
+			if err != nil {
+				return nil, err
+			}
+
+			diff.value = value
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	return diffs, nil
+}
+
+// go-mysql's JSON decoding is only reachable through rows events, so the binary JSON value
+// is decoded from a WRITE_ROWS_EVENTv2 with just the value's column
+func (d *eventDecoder) decodeJsonValue(tableMapEvent *replication.TableMapEvent, column int, binaryValue []byte) ([]byte, error) {
+	lengthSize := int(tableMapEvent.ColumnMeta[column])
+	bitmap := make([]byte, (tableMapEvent.ColumnCount+7)/8)
+	bitmap[column/8] = 1 << uint(column%8)
+
+	body := make([]byte, d.tableIdSize)
+
+	for i := range body {
+		body[i] = byte(tableMapEvent.TableID >> uint(8*i))
+	}
+
+	body = append(body, 0, 0, 2, 0) // flags, extra data length
+	body = append(body, packedInt(tableMapEvent.ColumnCount)...)
+	body = append(body, bitmap...)
+	body = append(body, 0) // null bitmap
+
+	for i := 0; i < lengthSize; i++ {
+		body = append(body, byte(len(binaryValue)>>uint(8*i)))
+	}
+
+	body = append(body, binaryValue...)
+
+	data := make([]byte, replication.EventHeaderSize, replication.EventHeaderSize+len(body)+d.checksumSize)
+	data[4] = byte(replication.WRITE_ROWS_EVENTv2)
+	data = append(data, body...)
+	data = append(data, make([]byte, d.checksumSize)...)
+	binary.LittleEndian.PutUint32(data[9:13], uint32(len(data)))
+
+	e, err := d.parser.Parse(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Event.(*replication.RowsEvent).Rows[0][column].([]byte), nil
+}
+
+func rowImageLength(data []byte, tableMapEvent *replication.TableMapEvent, bitmap []byte) (int, error) {
+	_, n, err := readRowImage(data, tableMapEvent, bitmap)
+	return n, err
+}
+
+// Splits a row image into its values, see Rows_log_event in MySQL's
+// libbinlogevents/include/rows_event.h for the format
+func readRowImage(data []byte, tableMapEvent *replication.TableMapEvent, bitmap []byte) (rowImage, int, error) {
+	image := rowImage{values: make(map[int][]byte)}
+
+	for i := 0; i < int(tableMapEvent.ColumnCount); i++ {
+		if isBitSet(bitmap, i) {
+			image.columns = append(image.columns, i)
+		}
+	}
+
+	pos := (len(image.columns) + 7) / 8
+
+	if pos > len(data) {
+		return rowImage{}, 0, fmt.Errorf("Truncated row image")
+	}
+
+	image.nullBitmap = data[:pos]
+
+	for i, column := range image.columns {
+		if isBitSet(image.nullBitmap, i) {
+			continue
+		}
+
+		n, err := valueLength(data[pos:], tableMapEvent.ColumnType[column], tableMapEvent.ColumnMeta[column])
+
+		if err != nil {
+			return rowImage{}, 0, fmt.Errorf("Invalid value of column %d: %s", column, err)
+		}
+
+		image.values[column] = data[pos : pos+n]
+		pos += n
+	}
+
+	return image, pos, nil
+}
+
+func (image rowImage) bytes() []byte {
+	data := append([]byte{}, image.nullBitmap...)
+
+	for _, column := range image.columns {
+		data = append(data, image.values[column]...)
+	}
+
+	return data
+}
+
+// The size of a value in a row image, following RowsEvent.decodeValue in go-mysql
+func valueLength(data []byte, columnType byte, columnMeta uint16) (int, error) {
+	var n int
+
+	switch columnType {
+	case mysql.MYSQL_TYPE_NULL:
+		n = 0
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_YEAR:
+		n = 1
+	case mysql.MYSQL_TYPE_SHORT:
+		n = 2
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE, mysql.MYSQL_TYPE_TIME:
+		n = 3
+	case mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_TIMESTAMP:
+		n = 4
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_DATETIME:
+		n = 8
+	case mysql.MYSQL_TYPE_TIME2:
+		n = 3 + int(columnMeta+1)/2
+	case mysql.MYSQL_TYPE_TIMESTAMP2:
+		n = 4 + int(columnMeta+1)/2
+	case mysql.MYSQL_TYPE_DATETIME2:
+		n = 5 + int(columnMeta+1)/2
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		n = decimalLength(int(columnMeta>>8), int(columnMeta&0xff))
+	case mysql.MYSQL_TYPE_BIT:
+		n = (int(columnMeta>>8)*8 + int(columnMeta&0xff) + 7) / 8
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		return lengthPrefixedLength(data, columnMeta)
+	case mysql.MYSQL_TYPE_STRING:
+		realType, length := conversion.StringColumnMeta(columnMeta)
+
+		if realType == mysql.MYSQL_TYPE_ENUM || realType == mysql.MYSQL_TYPE_SET {
+			n = length
+		} else {
+			return lengthPrefixedLength(data, uint16(length))
+		}
+	case mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_JSON:
+		if int(columnMeta) > len(data) || columnMeta > 4 {
+			return 0, fmt.Errorf("truncated value")
+		}
+
+		n = int(columnMeta) + int(littleEndianUint(data[:columnMeta]))
+	default:
+		return 0, fmt.Errorf("unsupported column type %d", columnType)
+	}
+
+	if n > len(data) {
+		return 0, fmt.Errorf("truncated value")
+	}
+
+	return n, nil
+}
+
+// Strings have a one byte length prefix if their maximum length is below 256 bytes
+func lengthPrefixedLength(data []byte, maxLength uint16) (int, error) {
+	var n int
+
+	if maxLength < 256 && len(data) >= 1 {
+		n = 1 + int(data[0])
+	} else if maxLength >= 256 && len(data) >= 2 {
+		n = 2 + int(binary.LittleEndian.Uint16(data))
+	}
+
+	if n == 0 || n > len(data) {
+		return 0, fmt.Errorf("truncated value")
+	}
+
+	return n, nil
+}
+
+// Decimals are stored with 4 bytes for each 9 digits, on each side of the decimal point
+func decimalLength(precision, scale int) int {
+	compressedBytes := []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+	integral := precision - scale
+
+	return integral/9*4 + compressedBytes[integral%9] + scale/9*4 + compressedBytes[scale%9]
+}
+
+func applyJsonDiffs(data []byte, diffs []jsonDiff) ([]byte, error) {
+	document, err := decodeJsonDocument(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, diff := range diffs {
+		path, err := parseJsonPath(diff.path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+
+		if diff.operation != jsonDiffRemove {
+			if value, err = decodeJsonDocument(diff.value); err != nil {
+				return nil, err
+			}
+		}
+
+		if document, err = applyJsonDiff(document, path, diff.operation, value); err != nil {
+			return nil, fmt.Errorf("%s at path %s", err, diff.path)
+		}
+	}
+
+	return json.Marshal(document)
+}
+
+func decodeJsonDocument(data []byte) (interface{}, error) {
+	var document interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // keeps numbers exactly as they were
+
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// A member name or an array index of a path like $.a."b c"[1]
+type jsonPathLeg struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func parseJsonPath(path string) ([]jsonPathLeg, error) {
+	if len(path) == 0 || path[0] != '$' {
+		return nil, fmt.Errorf("Invalid JSON path %s", path)
+	}
+
+	var legs []jsonPathLeg
+
+	for i := 1; i < len(path); {
+		switch {
+		case path[i] == '[':
+			end := i + 1
+
+			for end < len(path) && path[end] != ']' {
+				end++
+			}
+
+			index, err := strconv.Atoi(path[i+1 : minInt(end, len(path))])
+
+			if err != nil || end == len(path) {
+				return nil, fmt.Errorf("Invalid array index in JSON path %s", path)
+			}
+
+			legs = append(legs, jsonPathLeg{index: index, isIndex: true})
+			i = end + 1
+		case path[i] == '.' && i+1 < len(path) && path[i+1] == '"':
+			end := i + 2
+
+			for end < len(path) && path[end] != '"' {
+				if path[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			var key string
+
+			if end >= len(path) || json.Unmarshal([]byte(path[i+1:end+1]), &key) != nil {
+				return nil, fmt.Errorf("Invalid member name in JSON path %s", path)
+			}
+
+			legs = append(legs, jsonPathLeg{key: key})
+			i = end + 1
+		case path[i] == '.':
+			end := i + 1
+
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+
+			legs = append(legs, jsonPathLeg{key: path[i+1 : end]})
+			i = end
+		default:
+			return nil, fmt.Errorf("Invalid JSON path %s", path)
+		}
+	}
+
+	return legs, nil
+}
+
+// Replaces, inserts or removes the value at the path, returning the updated document
+func applyJsonDiff(document interface{}, path []jsonPathLeg, operation byte, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		if operation != jsonDiffReplace {
+			return nil, fmt.Errorf("can't insert or remove the whole document")
+		}
+
+		return value, nil
+	}
+
+	leg := path[0]
+
+	switch container := document.(type) {
+	case map[string]interface{}:
+		if leg.isIndex {
+			break
+		}
+
+		if len(path) > 1 {
+			child, err := applyJsonDiff(container[leg.key], path[1:], operation, value)
+			container[leg.key] = child
+
+			return container, err
+		}
+
+		if operation == jsonDiffRemove {
+			delete(container, leg.key)
+		} else {
+			container[leg.key] = value
+		}
+
+		return container, nil
+
+	case []interface{}:
+		if !leg.isIndex {
+			break
+		}
+
+		if leg.index > len(container) || leg.index == len(container) && (len(path) > 1 || operation != jsonDiffInsert) {
+			return nil, fmt.Errorf("array index %d out of range", leg.index)
+		}
+
+		if len(path) > 1 {
+			child, err := applyJsonDiff(container[leg.index], path[1:], operation, value)
+			container[leg.index] = child
+
+			return container, err
+		}
+
+		switch operation {
+		case jsonDiffReplace:
+			container[leg.index] = value
+		case jsonDiffInsert:
+			container = append(container[:leg.index], append([]interface{}{value}, container[leg.index:]...)...)
+		case jsonDiffRemove:
+			container = append(container[:leg.index], container[leg.index+1:]...)
+		}
+
+		return container, nil
+	}
+
+	return nil, fmt.Errorf("path doesn't match the document")
+}
+
+func isBitSet(bitmap []byte, i int) bool {
+	return i/8 < len(bitmap) && bitmap[i/8]&(1<<uint(i%8)) != 0
+}
+
+func littleEndianUint(data []byte) uint64 {
+	var value uint64
+
+	for i, b := range data {
+		value |= uint64(b) << uint(8*i)
+	}
+
+	return value
+}
+
+func packedInt(value uint64) []byte {
+	switch {
+	case value < 251:
+		return []byte{byte(value)}
+	case value < 1<<16:
+		return []byte{0xfc, byte(value), byte(value >> 8)}
+	case value < 1<<24:
+		return []byte{0xfd, byte(value), byte(value >> 8), byte(value >> 16)}
+	}
+
+	data := []byte{0xfe, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(data[1:], value)
+
+	return data
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,141 @@
+// +build unit
+
+package parser
+
+import (
+	"encoding/json"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"testing"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestParsePartialUpdateRowsEvent(t *testing.T) {
+	provider := &testSchemaProvider{fields: map[int]string{0: "id", 1: "doc"}}
+	tableMap := database.NewTableMapFromSchemaProvider(provider)
+
+	var parsed []messages.Message
+
+	handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+		parsed = append(parsed, message)
+		return nil
+	}, ParseOptions{}, func() {})
+
+	events := [][]byte{fixtureFormatDescription(t), testJsonTableMapEvent(), testPartialUpdateRowsEvent(0x03), testXidEvent()}
+
+	for _, event := range events {
+		if err := handleEvent(event); err != nil {
+			t.Fatalf("Expected no error when handling event, got %s", err)
+		}
+	}
+
+	if len(parsed) != 3 {
+		t.Fatalf("Expected one message between transaction boundaries - got %v", parsed)
+	}
+
+	update := parsed[1].(messages.UpdateMessage)
+
+	expectedOldRow := messages.MessageRow{"id": int32(7), "doc": json.RawMessage(`{"a":1}`)}
+	expectedNewRow := messages.MessageRow{"id": int32(7), "doc": json.RawMessage(`{"a":2,"b":"x"}`)}
+
+	if !reflect.DeepEqual(update.OldData.Row, expectedOldRow) || !reflect.DeepEqual(update.NewData.Row, expectedNewRow) {
+		t.Fatalf("Wrong rows - got %s and %s", update.OldData.Row, update.NewData.Row)
+	}
+
+	t.Run("Without before image", func(t *testing.T) {
+		decoder := newEventDecoder()
+		decodeFixtureFormatDescription(t, decoder)
+
+		if _, _, err := decoder.decode(testJsonTableMapEvent()); err != nil {
+			t.Fatalf("Expected no error when decoding table map event, got %s", err)
+		}
+
+		if _, _, err := decoder.decode(testPartialUpdateRowsEvent(0x01)); err == nil {
+			t.Fatal("Expected error for partial JSON update without the value before the update")
+		}
+	})
+}
+
+func TestApplyJsonDiffs(t *testing.T) {
+	document := []byte(`{"a": [1, 2.50, {"b": null}], "c d": "x"}`)
+
+	testCases := []struct {
+		name     string
+		diff     jsonDiff
+		expected string
+	}{
+		{"Replace member", jsonDiff{jsonDiffReplace, `$."c d"`, []byte(`true`)}, `{"a":[1,2.50,{"b":null}],"c d":true}`},
+		{"Replace array element", jsonDiff{jsonDiffReplace, `$.a[2].b`, []byte(`[]`)}, `{"a":[1,2.50,{"b":[]}],"c d":"x"}`},
+		{"Insert member", jsonDiff{jsonDiffInsert, `$.e`, []byte(`1`)}, `{"a":[1,2.50,{"b":null}],"c d":"x","e":1}`},
+		{"Insert array element", jsonDiff{jsonDiffInsert, `$.a[1]`, []byte(`"y"`)}, `{"a":[1,"y",2.50,{"b":null}],"c d":"x"}`},
+		{"Append array element", jsonDiff{jsonDiffInsert, `$.a[3]`, []byte(`3`)}, `{"a":[1,2.50,{"b":null},3],"c d":"x"}`},
+		{"Remove member", jsonDiff{jsonDiffRemove, `$.a`, nil}, `{"c d":"x"}`},
+		{"Remove array element", jsonDiff{jsonDiffRemove, `$.a[0]`, nil}, `{"a":[2.50,{"b":null}],"c d":"x"}`},
+		{"Replace document", jsonDiff{jsonDiffReplace, `$`, []byte(`"z"`)}, `"z"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated, err := applyJsonDiffs(document, []jsonDiff{tc.diff})
+
+			if err != nil {
+				t.Fatalf("Expected no error when applying JSON diff, got %s", err)
+			}
+
+			if string(updated) != tc.expected {
+				t.Fatalf("Wrong updated document - expected %s, got %s", tc.expected, updated)
+			}
+		})
+	}
+
+	for _, path := range []string{"a", "$.a[", "$.a[x]", `$."a`, "$.a[5]", "$.c d[0]"} {
+		t.Run("Invalid path "+path, func(t *testing.T) {
+			if _, err := applyJsonDiffs(document, []jsonDiff{{jsonDiffReplace, path, []byte(`1`)}}); err == nil {
+				t.Fatalf("Expected error when applying JSON diff at path %s", path)
+			}
+		})
+	}
+}
+
+// A table `test_db`.`docs` (id INT, doc JSON) with table id 43
+func testJsonTableMapEvent() []byte {
+	body := []byte{43, 0, 0, 0, 0, 0, 1, 0}
+	body = append(body, 7)
+	body = append(body, "test_db"...)
+	body = append(body, 0, 4)
+	body = append(body, "docs"...)
+	body = append(body, 0, 2, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON)
+	body = append(body, 1, 4) // column metadata: length of the JSON length prefix
+	body = append(body, 0x02) // null bitmap
+
+	return testEvent(replication.TABLE_MAP_EVENT, 500, body)
+}
+
+// Updates {"a": 1} with JSON_SET(doc, '$.a', 2, '$.b', 'x'), the before image holds the
+// columns of the before bitmap
+func testPartialUpdateRowsEvent(beforeBitmap byte) []byte {
+	body := []byte{43, 0, 0, 0, 0, 0, 1, 0} // table id, STMT_END_F
+	body = append(body, 2, 0)               // extra data length
+	body = append(body, 2, beforeBitmap, 0x03)
+
+	// before image
+	body = append(body, 0x00, 7, 0, 0, 0)
+
+	if beforeBitmap&0x02 != 0 {
+		body = append(body, 13, 0, 0, 0, 0x00, 1, 0, 12, 0, 11, 0, 1, 0, 0x05, 1, 0, 'a') // small object {"a": 1}
+	}
+
+	diffs := []byte{
+		jsonDiffReplace, 3, '$', '.', 'a', 3, 0x05, 2, 0, // int16 2
+		jsonDiffInsert, 3, '$', '.', 'b', 3, 0x0c, 1, 'x', // string "x"
+	}
+
+	// after image
+	body = append(body, valueOptionPartialJsonUpdates, 0x01)
+	body = append(body, 0x00, 7, 0, 0, 0, byte(len(diffs)), 0, 0, 0)
+	body = append(body, diffs...)
+
+	return testEvent(partialUpdateRowsEvent, 600, body)
+}
@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"zalora/binlog-parser/database"
+)
+
+// Optional metadata field types of a table map event, see Table_map_log_event in
+// MySQL's libbinlogevents/include/rows_event.h
+const (
+	metadataSignedness           = 1
+	metadataDefaultCharset       = 2
+	metadataColumnCharset        = 3
+	metadataColumnName           = 4
+	metadataSetStrValue          = 5
+	metadataEnumStrValue         = 6
+	metadataSimplePrimaryKey     = 8
+	metadataPrimaryKeyWithPrefix = 9
+)
+
+type tableMapMetadata struct {
+	columnNames []string
+	unsigned    map[int]bool
+	charsets    map[int]uint64
+	values      map[int][]string
+	primaryKey  []int
+}
+
+func decodeTableMapMetadata(tableMapEvent *replication.TableMapEvent, data []byte) (tableMapMetadata, error) {
+	metadata := tableMapMetadata{unsigned: make(map[int]bool), charsets: make(map[int]uint64)}
+
+	for len(data) > 0 {
+		fieldType := data[0]
+		length, n, ok := lengthEncodedInt(data[1:])
+
+		if !ok || 1+n+int(length) > len(data) {
+			return tableMapMetadata{}, fmt.Errorf("field %d of length %d exceeds metadata", fieldType, length)
+		}
+
+		field := packedReader{data: data[1+n : 1+n+int(length)]}
+		data = data[1+n+int(length):]
+
+		switch fieldType {
+		case metadataSignedness:
+			for i, column := range columnsOfKind(tableMapEvent, isNumericColumn) {
+				if i/8 < len(field.data) && field.data[i/8]&(0x80>>uint(i%8)) != 0 {
+					metadata.unsigned[column] = true
+				}
+			}
+
+		case metadataDefaultCharset:
+			columns := columnsOfKind(tableMapEvent, isCharacterColumn)
+			defaultCharset := field.next()
+
+			for _, column := range columns {
+				metadata.charsets[column] = defaultCharset
+			}
+
+			for !field.done() {
+				index, charset := int(field.next()), field.next()
+
+				if index < len(columns) {
+					metadata.charsets[columns[index]] = charset
+				}
+			}
+
+		case metadataColumnCharset:
+			for _, column := range columnsOfKind(tableMapEvent, isCharacterColumn) {
+				metadata.charsets[column] = field.next()
+			}
+
+		case metadataColumnName:
+			for !field.done() {
+				metadata.columnNames = append(metadata.columnNames, field.nextString())
+			}
+
+		case metadataSetStrValue, metadataEnumStrValue:
+			realType := byte(mysql.MYSQL_TYPE_SET)
+
+			if fieldType == metadataEnumStrValue {
+				realType = mysql.MYSQL_TYPE_ENUM
+			}
+
+			for _, column := range columnsOfKind(tableMapEvent, isStringColumnOfType(realType)) {
+				values := make([]string, field.next())
+
+				for i := range values {
+					values[i] = field.nextString()
+				}
+
+				if metadata.values == nil {
+					metadata.values = make(map[int][]string)
+				}
+
+				metadata.values[column] = values
+			}
+
+		case metadataSimplePrimaryKey:
+			for !field.done() {
+				metadata.primaryKey = append(metadata.primaryKey, int(field.next()))
+			}
+
+		case metadataPrimaryKeyWithPrefix:
+			for !field.done() {
+				metadata.primaryKey = append(metadata.primaryKey, int(field.next()))
+				field.next() // prefix length
+			}
+		}
+
+		if field.err != nil {
+			return tableMapMetadata{}, fmt.Errorf("field %d: %s", fieldType, field.err)
+		}
+	}
+
+	return metadata, nil
+}
+
+// Column names are only logged with binlog_row_metadata=FULL
+func (m *tableMapMetadata) hasColumnNames(columnCount uint64) bool {
+	return m != nil && uint64(len(m.columnNames)) == columnCount
+}
+
+func (m *tableMapMetadata) columns() map[int]database.ColumnMetadata {
+	columns := make(map[int]database.ColumnMetadata)
+
+	for column, unsigned := range m.unsigned {
+		columnMetadata := columns[column]
+		columnMetadata.Unsigned = unsigned
+		columns[column] = columnMetadata
+	}
+
+	for column, charset := range m.charsets {
+		columnMetadata := columns[column]
+		columnMetadata.Charset = charset
+		columns[column] = columnMetadata
+	}
+
+	for column, values := range m.values {
+		columnMetadata := columns[column]
+		columnMetadata.Values = values
+		columns[column] = columnMetadata
+	}
+
+	return columns
+}
+
+func columnsOfKind(tableMapEvent *replication.TableMapEvent, isKind func(byte, uint16) bool) []int {
+	var columns []int
+
+	for i, columnType := range tableMapEvent.ColumnType {
+		if isKind(columnType, tableMapEvent.ColumnMeta[i]) {
+			columns = append(columns, i)
+		}
+	}
+
+	return columns
+}
+
+func isNumericColumn(columnType byte, columnMeta uint16) bool {
+	switch columnType {
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG,
+		mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_DOUBLE:
+		return true
+	}
+
+	return false
+}
+
+// ENUM and SET columns are logged as MYSQL_TYPE_STRING with the real type in the metadata
+func isCharacterColumn(columnType byte, columnMeta uint16) bool {
+	switch columnType {
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_BLOB:
+		return true
+	case mysql.MYSQL_TYPE_STRING:
+		realType := byte(columnMeta >> 8)
+		return realType != mysql.MYSQL_TYPE_ENUM && realType != mysql.MYSQL_TYPE_SET
+	}
+
+	return false
+}
+
+func isStringColumnOfType(realType byte) func(byte, uint16) bool {
+	return func(columnType byte, columnMeta uint16) bool {
+		return columnType == mysql.MYSQL_TYPE_STRING && byte(columnMeta>>8) == realType
+	}
+}
+
+// Reads the packed integers and length-prefixed strings of a metadata field
+type packedReader struct {
+	data []byte
+	err  error
+}
+
+func (r *packedReader) done() bool {
+	return len(r.data) == 0 || r.err != nil
+}
+
+func (r *packedReader) next() uint64 {
+	if r.done() {
+		r.err = fmt.Errorf("unexpected end of field")
+		return 0
+	}
+
+	value, n, ok := lengthEncodedInt(r.data)
+
+	if !ok {
+		r.err = fmt.Errorf("unexpected end of field")
+		return 0
+	}
+
+	r.data = r.data[n:]
+
+	return value
+}
+
+func (r *packedReader) nextString() string {
+	length := int(r.next())
+
+	if r.err != nil || length > len(r.data) {
+		r.err = fmt.Errorf("unexpected end of field")
+		return ""
+	}
+
+	value := string(r.data[:length])
+	r.data = r.data[length:]
+
+	return value
+}
+
+// Like mysql.LengthEncodedInt, but doesn't read beyond the end of the data
+func lengthEncodedInt(data []byte) (uint64, int, bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+
+	var size int
+
+	switch data[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	default:
+		return uint64(data[0]), 1, data[0] < 0xfb
+	}
+
+	if len(data) < size {
+		return 0, 0, false
+	}
+
+	value, _, n := mysql.LengthEncodedInt(data)
+
+	return value, n, true
+}
@@ -0,0 +1,78 @@
+// +build unit
+
+package parser
+
+import (
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeTableMapMetadata(t *testing.T) {
+	// (id BIGINT UNSIGNED, code CHAR(2), flags SET(...), body TEXT, amount DECIMAL(10,2) UNSIGNED)
+	tableMapEvent := &replication.TableMapEvent{
+		ColumnCount: 5,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_NEWDECIMAL},
+		ColumnMeta:  []uint16{0, uint16(mysql.MYSQL_TYPE_STRING)<<8 | 8, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, 2, 10<<8 | 2},
+	}
+
+	testCases := []struct {
+		name             string
+		data             []byte
+		expectedMetadata tableMapMetadata
+	}{
+		{
+			"Default charset with exceptions",
+			[]byte{
+				metadataSignedness, 1, 0xc0,
+				metadataDefaultCharset, 3, 33, 1, 63, // utf8_general_ci, body is binary
+				metadataPrimaryKeyWithPrefix, 4, 0, 0, 1, 2,
+			},
+			tableMapMetadata{
+				unsigned:   map[int]bool{0: true, 4: true},
+				charsets:   map[int]uint64{1: 33, 3: 63},
+				primaryKey: []int{0, 1},
+			},
+		},
+		{
+			"SET values",
+			[]byte{metadataSetStrValue, 7, 2, 1, 'a', 3, 'b', 'c', 'd'},
+			tableMapMetadata{
+				unsigned: map[int]bool{},
+				charsets: map[int]uint64{},
+				values:   map[int][]string{2: {"a", "bcd"}},
+			},
+		},
+		{
+			"Column charsets",
+			[]byte{metadataColumnCharset, 2, 8, 45},
+			tableMapMetadata{
+				unsigned: map[int]bool{},
+				charsets: map[int]uint64{1: 8, 3: 45},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metadata, err := decodeTableMapMetadata(tableMapEvent, tc.data)
+
+			if err != nil {
+				t.Fatalf("Expected no error when decoding metadata, got %s", err)
+			}
+
+			if !reflect.DeepEqual(metadata, tc.expectedMetadata) {
+				t.Fatalf("Wrong metadata decoded - got %+v", metadata)
+			}
+		})
+	}
+
+	t.Run("Truncated metadata", func(t *testing.T) {
+		for _, data := range [][]byte{{metadataColumnName, 10, 2, 'i', 'd'}, {metadataColumnName, 3, 5, 'i', 'd'}, {metadataSimplePrimaryKey, 1, 0xfc}} {
+			if _, err := decodeTableMapMetadata(tableMapEvent, data); err == nil {
+				t.Fatalf("Expected error when decoding truncated metadata %v", data)
+			}
+		}
+	})
+}
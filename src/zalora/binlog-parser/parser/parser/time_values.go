@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"time"
+)
+
+// go-mysql decodes TIME(1), TIME(3) and TIME(5) values as 00:00:00 and returns the others
+// as strings, so TIME values are decoded again from the row images, as durations. It also
+// turns DATETIME values with a zero month or day into valid dates, e.g. 2017-04-00 into
+// 2017-03-31, so these are decoded again as strings like 2017-04-00 10:00:00.
+func (d *eventDecoder) decodeTemporalValues(data []byte, rowsEvent *replication.RowsEvent) error {
+	var temporalColumns []int
+
+	for i, columnType := range rowsEvent.Table.ColumnType {
+		switch columnType {
+		case mysql.MYSQL_TYPE_TIME2, mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+			temporalColumns = append(temporalColumns, i)
+		}
+	}
+
+	if len(temporalColumns) == 0 {
+		return nil
+	}
+
+	body := data[replication.EventHeaderSize : len(data)-d.checksumSize]
+	pos := d.tableIdSize + 2
+
+	if rowsEvent.Version == 2 {
+		pos += int(binary.LittleEndian.Uint16(body[pos:])) // extra data, including its length
+	}
+
+	_, n, _ := lengthEncodedInt(body[pos:])
+	pos += n + len(rowsEvent.ColumnBitmap1) + len(rowsEvent.ColumnBitmap2)
+
+	bitmaps := [][]byte{rowsEvent.ColumnBitmap1}
+
+	if rowsEvent.ColumnBitmap2 != nil {
+		bitmaps = append(bitmaps, rowsEvent.ColumnBitmap2)
+	}
+
+	for i, row := range rowsEvent.Rows {
+		image, n, err := readRowImage(body[pos:], rowsEvent.Table, bitmaps[i%len(bitmaps)])
+
+		if err != nil {
+			return err
+		}
+
+		pos += n
+
+		for _, column := range temporalColumns {
+			value := image.values[column]
+
+			if value == nil {
+				continue
+			}
+
+			if columnType := rowsEvent.Table.ColumnType[column]; columnType == mysql.MYSQL_TYPE_TIME2 {
+				row[column] = decodeTime2(value, rowsEvent.Table.ColumnMeta[column])
+			} else if datetime, ok := zeroInDatetime(value, columnType); ok {
+				row[column] = datetime
+			}
+		}
+	}
+
+	return nil
+}
+
+// DATETIME values with a zero month or day, but not zero dates, which go-mysql decodes as
+// strings already. See TIME_from_longlong_datetime_packed in MySQL's sql-common/my_time.cc.
+func zeroInDatetime(data []byte, columnType byte) (string, bool) {
+	var year, month, day, hour, minute, second int64
+
+	if columnType == mysql.MYSQL_TYPE_DATETIME {
+		packed := int64(binary.LittleEndian.Uint64(data))
+		date, clock := packed/1000000, packed%1000000
+		year, month, day = date/10000, date%10000/100, date%100
+		hour, minute, second = clock/10000, clock%10000/100, clock%100
+	} else {
+		packed := int64(bigEndianUint(data[:5])) - 0x8000000000
+		ymd, hms := packed>>17, packed%(1<<17)
+		year, month, day = ymd>>5/13, ymd>>5%13, ymd%(1<<5)
+		hour, minute, second = hms>>12, hms>>6%(1<<6), hms%(1<<6)
+	}
+
+	if year == 0 && month == 0 && day == 0 || month != 0 && day != 0 {
+		return "", false
+	}
+
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second), true
+}
+
+// See TIME_from_longlong_time_packed and my_time_packed_from_binary in MySQL's
+// sql-common/my_time.cc
+func decodeTime2(data []byte, fsp uint16) time.Duration {
+	intPart := int64(bigEndianUint(data[:3])) - 0x800000
+	var packed int64
+
+	switch fsp {
+	case 1, 2:
+		frac := int64(data[3])
+
+		if intPart < 0 && frac > 0 {
+			intPart++
+			frac -= 0x100
+		}
+
+		packed = intPart<<24 + frac*10000
+	case 3, 4:
+		frac := int64(binary.BigEndian.Uint16(data[3:5]))
+
+		if intPart < 0 && frac > 0 {
+			intPart++
+			frac -= 0x10000
+		}
+
+		packed = intPart<<24 + frac*100
+	case 5, 6:
+		packed = int64(bigEndianUint(data[:6])) - 0x800000000000
+	default:
+		packed = intPart << 24
+	}
+
+	sign := time.Duration(1)
+
+	if packed < 0 {
+		sign = -1
+		packed = -packed
+	}
+
+	hms := packed >> 24
+	duration := time.Duration((hms>>12)%(1<<10))*time.Hour +
+		time.Duration((hms>>6)%(1<<6))*time.Minute +
+		time.Duration(hms%(1<<6))*time.Second +
+		time.Duration(packed%(1<<24))*time.Microsecond
+
+	return sign * duration
+}
+
+func bigEndianUint(data []byte) uint64 {
+	var value uint64
+
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	return value
+}
@@ -0,0 +1,115 @@
+// +build unit
+
+package parser
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Each fixture is a value as logged in a row image, with the type and the metadata of its
+// column as logged in the table map event, and the value expected in the JSON output. The
+// column type is the one of the schema, if needed, e.g. for the labels of ENUM columns.
+type valueFixture struct {
+	Name       string
+	Type       string
+	Meta       string
+	Value      string
+	ColumnType string
+	Expected   json.RawMessage
+}
+
+var fixtureColumnTypes = map[string]byte{
+	"LONGLONG":   mysql.MYSQL_TYPE_LONGLONG,
+	"FLOAT":      mysql.MYSQL_TYPE_FLOAT,
+	"DOUBLE":     mysql.MYSQL_TYPE_DOUBLE,
+	"NEWDECIMAL": mysql.MYSQL_TYPE_NEWDECIMAL,
+	"DATETIME":   mysql.MYSQL_TYPE_DATETIME,
+	"DATETIME2":  mysql.MYSQL_TYPE_DATETIME2,
+	"TIMESTAMP":  mysql.MYSQL_TYPE_TIMESTAMP,
+	"TIMESTAMP2": mysql.MYSQL_TYPE_TIMESTAMP2,
+	"DATE":       mysql.MYSQL_TYPE_DATE,
+	"TIME":       mysql.MYSQL_TYPE_TIME,
+	"TIME2":      mysql.MYSQL_TYPE_TIME2,
+	"YEAR":       mysql.MYSQL_TYPE_YEAR,
+	"STRING":     mysql.MYSQL_TYPE_STRING,
+}
+
+func TestValueFixtures(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("DATA_DIR"), "fixtures/values.json"))
+
+	if err != nil {
+		t.Fatalf("Failed to read value fixtures: %s", err)
+	}
+
+	var fixtures []valueFixture
+
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("Failed to decode value fixtures: %s", err)
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.Name, func(t *testing.T) {
+			columnType, ok := fixtureColumnTypes[fixture.Type]
+			meta, metaErr := hex.DecodeString(fixture.Meta)
+			value, valueErr := hex.DecodeString(fixture.Value)
+
+			if !ok || metaErr != nil || valueErr != nil {
+				t.Fatalf("Invalid fixture %+v", fixture)
+			}
+
+			tableMap := database.NewTableMapFromSchemaProvider(&testSchemaProvider{fields: map[int]string{0: "v"}, types: map[int]string{0: fixture.ColumnType}})
+
+			var parsed []messages.Message
+
+			handleEvent := createEventHandler(tableMap, func(message messages.Message) error {
+				parsed = append(parsed, message)
+				return nil
+			}, ParseOptions{}, func() {})
+
+			events := [][]byte{fixtureFormatDescription(t), testValueTableMapEvent(columnType, meta), testValueWriteRowsEvent(value), testXidEvent()}
+
+			for _, event := range events {
+				if err := handleEvent(event); err != nil {
+					t.Fatalf("Expected no error when handling event, got %s", err)
+				}
+			}
+
+			output, _ := json.Marshal(parsed[1].(messages.InsertMessage).Data.Row["v"])
+
+			if string(output) != string(fixture.Expected) {
+				t.Fatalf("Wrong value - expected %s, got %s", fixture.Expected, output)
+			}
+		})
+	}
+}
+
+// A table `test_db`.`values` with a single column of the type with table id 44
+func testValueTableMapEvent(columnType byte, meta []byte) []byte {
+	body := []byte{44, 0, 0, 0, 0, 0, 1, 0}
+	body = append(body, 7)
+	body = append(body, "test_db"...)
+	body = append(body, 0, 6)
+	body = append(body, "values"...)
+	body = append(body, 0, 1, columnType, byte(len(meta)))
+	body = append(body, meta...)
+	body = append(body, 0x01) // null bitmap
+
+	return testEvent(replication.TABLE_MAP_EVENT, 500, body)
+}
+
+func testValueWriteRowsEvent(value []byte) []byte {
+	body := []byte{44, 0, 0, 0, 0, 0, 1, 0}  // table id, STMT_END_F
+	body = append(body, 2, 0, 1, 0x01, 0x00) // extra data length, column count, columns present, null bitmap
+	body = append(body, value...)
+
+	return testEvent(replication.WRITE_ROWS_EVENTv2, 600, body)
+}
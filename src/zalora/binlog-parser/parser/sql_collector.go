@@ -0,0 +1,520 @@
+package parser
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+	"github.com/lib/pq"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"zalora/binlog-parser/parser/conversion"
+	"zalora/binlog-parser/parser/messages"
+)
+
+const (
+	SQL_DIALECT_MYSQL    = "mysql"
+	SQL_DIALECT_POSTGRES = "postgres"
+	SQL_DIALECT_SQLITE   = "sqlite3"
+
+	// Conflicts are rows already there for inserts, or rows not there for updates and
+	// deletes. They fail the transaction, are skipped, or the row is inserted or replaced.
+	SQL_CONFLICTS_ERROR   = "error"
+	SQL_CONFLICTS_IGNORE  = "ignore"
+	SQL_CONFLICTS_REPLACE = "replace"
+)
+
+// The dialect is the one of the target database, the table template names the target
+// table of the rows of a table, with {schema} and {table} replaced. Binary values are
+// decoded with the BinaryEncoding of the parse options.
+type SqlReplayOptions struct {
+	Dialect        string
+	TableTemplate  string
+	Conflicts      string
+	BinaryEncoding string
+}
+
+// Opens the target database of the dialect
+func OpenSqlTarget(dialect, dsn string) (*sql.DB, error) {
+	dsn, err := sqlTargetDsn(dialect, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(dialect, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Updates are checked to have found their row, which MySQL only tells with
+// clientFoundRows, so it is added to MySQL DSNs. TIMESTAMP values are in UTC, so sessions
+// use UTC as their time zone.
+func sqlTargetDsn(dialect, dsn string) (string, error) {
+	switch dialect {
+	case SQL_DIALECT_MYSQL:
+		config, err := mysql.ParseDSN(dsn)
+
+		if err != nil {
+			return "", err
+		}
+
+		if config.Params == nil {
+			config.Params = make(map[string]string)
+		}
+
+		config.ClientFoundRows = true
+		config.Params["time_zone"] = "'+00:00'"
+		dsn = config.FormatDSN()
+	case SQL_DIALECT_POSTGRES:
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			var err error
+
+			if dsn, err = pq.ParseURL(dsn); err != nil {
+				return "", err
+			}
+		}
+
+		dsn += " timezone=UTC"
+	}
+
+	return dsn, nil
+}
+
+type sqlStatement struct {
+	query string
+	args  []interface{}
+}
+
+// Applies the row messages of each binlog transaction in one transaction of the target
+// database, from its TransactionBegin message to its TransactionCommit message. Target
+// transactions are only started for binlog transactions with row messages left.
+func sqlCollector(db *sql.DB, options SqlReplayOptions) collector {
+	var tx *sql.Tx
+
+	return func(message messages.Message) error {
+		if message.GetType() == messages.MESSAGE_TYPE_TRANSACTION_COMMIT {
+			if tx == nil {
+				return nil
+			}
+
+			err := tx.Commit()
+			tx = nil
+
+			if err != nil {
+				glog.Errorf("Failed to commit transaction %d to target database: %s", message.GetHeader().XId, err)
+				return err
+			}
+
+			return nil
+		}
+
+		statement, ok, err := sqlStatementForMessage(message, options)
+
+		if err != nil || !ok {
+			return err
+		}
+
+		if tx == nil {
+			if tx, err = db.Begin(); err != nil {
+				glog.Errorf("Failed to start transaction in target database: %s", err)
+				return err
+			}
+		}
+
+		if err = execSqlStatement(tx, statement, message, options); err != nil {
+			glog.Errorf("Failed to apply %s message of transaction %d at position %d: %s", message.GetType(), message.GetHeader().XId, message.GetHeader().BinlogPosition, err)
+			tx.Rollback()
+			tx = nil
+
+			return err
+		}
+
+		return nil
+	}
+}
+
+// Updates and deletes have to change exactly one row unless conflicts are ignored or
+// replaced. Updates of rows which aren't there are inserted when replacing conflicts,
+// more than one row changed fails unless conflicts are ignored, e.g. for keys which
+// aren't unique in the target table.
+func execSqlStatement(tx *sql.Tx, statement sqlStatement, message messages.Message, options SqlReplayOptions) error {
+	result, err := tx.Exec(statement.query, statement.args...)
+
+	if err != nil || message.GetType() == messages.MESSAGE_TYPE_INSERT || options.Conflicts == SQL_CONFLICTS_IGNORE {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+
+	if err != nil || rows == 1 {
+		return err
+	}
+
+	if rows > 1 {
+		return fmt.Errorf("%d rows to %s found instead of one", rows, strings.ToLower(string(message.GetType())))
+	}
+
+	if options.Conflicts != SQL_CONFLICTS_REPLACE {
+		return fmt.Errorf("Row to %s not found", strings.ToLower(string(message.GetType())))
+	}
+
+	update, ok := message.(messages.UpdateMessage)
+
+	if !ok {
+		return nil
+	}
+
+	insert, _, err := sqlStatementForMessage(messages.NewInsertMessage(update.GetHeader(), update.Key, update.NewData), options)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(insert.query, insert.args...)
+
+	return err
+}
+
+// Messages other than row messages have no statement, schema changes are not replayed
+func sqlStatementForMessage(message messages.Message, options SqlReplayOptions) (sqlStatement, bool, error) {
+	s := &sqlStatementBuilder{dialect: options.Dialect, binaryEncoding: options.BinaryEncoding}
+	ok := s.message(message, options)
+
+	return sqlStatement{s.query.String(), s.args}, ok && s.err == nil, s.err
+}
+
+// Like sqlStatementForMessage, with the values written into the statement as literals
+func sqlTextForMessage(message messages.Message, options SqlReplayOptions) (string, bool, error) {
+	s := &sqlStatementBuilder{dialect: options.Dialect, binaryEncoding: options.BinaryEncoding, literals: true}
+	ok := s.message(message, options)
+
+	return s.query.String(), ok && s.err == nil, s.err
+}
+
+func (s *sqlStatementBuilder) message(message messages.Message, options SqlReplayOptions) bool {
+	header := message.GetHeader()
+	table := sqlTable(options, header.Schema, header.Table)
+
+	switch m := message.(type) {
+	case messages.InsertMessage:
+		columns := sortedColumns(m.Data.Row)
+
+		switch {
+		case options.Conflicts == SQL_CONFLICTS_IGNORE && options.Dialect == SQL_DIALECT_MYSQL:
+			s.write("INSERT IGNORE INTO ", table)
+		case options.Conflicts == SQL_CONFLICTS_IGNORE && options.Dialect == SQL_DIALECT_SQLITE:
+			s.write("INSERT OR IGNORE INTO ", table)
+		default:
+			s.write("INSERT INTO ", table)
+		}
+
+		s.write(" (")
+
+		for i, column := range columns {
+			s.write(separator(i, ", "), s.quote(column))
+		}
+
+		s.write(") VALUES (")
+
+		for i, column := range columns {
+			s.write(separator(i, ", "))
+			s.value(m.Data.Row[column], m.Data.Columns, column)
+		}
+
+		s.write(")")
+		s.conflictClause(options.Conflicts, columns, sortedColumns(m.Key))
+	case messages.UpdateMessage:
+		columns := sortedColumns(m.NewData.Row)
+
+		if len(columns) == 0 {
+			return false
+		}
+
+		s.write("UPDATE ", table, " SET ")
+
+		for i, column := range columns {
+			s.write(separator(i, ", "), s.quote(column), " = ")
+			s.value(m.NewData.Row[column], m.NewData.Columns, column)
+		}
+
+		s.whereClause(table, m.Key, m.OldData)
+	case messages.DeleteMessage:
+		s.write("DELETE FROM ", table)
+		s.whereClause(table, m.Key, m.Data)
+	default:
+		return false
+	}
+
+	return true
+}
+
+type sqlStatementBuilder struct {
+	dialect        string
+	binaryEncoding string
+	literals       bool
+	query          strings.Builder
+	args           []interface{}
+	err            error
+}
+
+func (s *sqlStatementBuilder) write(parts ...string) {
+	for _, part := range parts {
+		s.query.WriteString(part)
+	}
+}
+
+func (s *sqlStatementBuilder) quote(identifier string) string {
+	if s.dialect == SQL_DIALECT_MYSQL {
+		return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+	}
+
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func (s *sqlStatementBuilder) value(value interface{}, columns map[string]messages.MessageColumnType, column string) {
+	var columnType *messages.MessageColumnType
+
+	if t, ok := columns[column]; ok {
+		columnType = &t
+	}
+
+	arg, err := sqlValue(value, columnType, s.binaryEncoding)
+
+	if err != nil && s.err == nil {
+		s.err = fmt.Errorf("Can't convert value of column %s: %s", column, err)
+	}
+
+	if s.literals {
+		s.write(sqlLiteral(arg, s.dialect))
+		return
+	}
+
+	s.args = append(s.args, arg)
+
+	if s.dialect == SQL_DIALECT_POSTGRES {
+		s.write("$", strconv.Itoa(len(s.args)))
+	} else {
+		s.write("?")
+	}
+}
+
+// Rows are identified by their key, or by all their columns if they have none. Of
+// identical rows without key, only one is changed, with LIMIT 1 in MySQL, and by its ctid
+// in PostgreSQL or its rowid in SQLite.
+func (s *sqlStatementBuilder) whereClause(table string, key messages.MessageRow, data messages.MessageRowData) {
+	row := key
+
+	if len(key) == 0 {
+		row = data.Row
+	}
+
+	if len(row) == 0 && s.err == nil {
+		s.err = fmt.Errorf("No columns logged to identify the row by")
+	}
+
+	switch {
+	case len(key) > 0 || s.dialect == SQL_DIALECT_MYSQL:
+		s.write(" WHERE ")
+	case s.dialect == SQL_DIALECT_POSTGRES:
+		s.write(" WHERE ctid = (SELECT ctid FROM ", table, " WHERE ")
+	default:
+		s.write(" WHERE rowid = (SELECT rowid FROM ", table, " WHERE ")
+	}
+
+	for i, column := range sortedColumns(row) {
+		s.write(separator(i, " AND "), s.quote(column))
+
+		if row[column] == nil {
+			s.write(" IS NULL")
+			continue
+		}
+
+		s.write(" = ")
+		s.value(row[column], data.Columns, column)
+	}
+
+	switch {
+	case len(key) > 0:
+	case s.dialect == SQL_DIALECT_MYSQL:
+		s.write(" LIMIT 1")
+	default:
+		s.write(" LIMIT 1)")
+	}
+}
+
+// Inserts replacing conflicting rows update all inserted columns. PostgreSQL and SQLite
+// need the key columns for that, without them rows are inserted as they are.
+func (s *sqlStatementBuilder) conflictClause(conflicts string, columns []string, keyColumns []string) {
+	switch {
+	case conflicts == SQL_CONFLICTS_IGNORE && s.dialect == SQL_DIALECT_POSTGRES:
+		s.write(" ON CONFLICT DO NOTHING")
+	case conflicts != SQL_CONFLICTS_REPLACE:
+	case s.dialect == SQL_DIALECT_MYSQL:
+		s.write(" ON DUPLICATE KEY UPDATE ")
+
+		for i, column := range columns {
+			s.write(separator(i, ", "), s.quote(column), " = VALUES(", s.quote(column), ")")
+		}
+	case len(keyColumns) > 0:
+		s.write(" ON CONFLICT (")
+
+		for i, column := range keyColumns {
+			s.write(separator(i, ", "), s.quote(column))
+		}
+
+		s.write(") DO UPDATE SET ")
+
+		for i, column := range columns {
+			s.write(separator(i, ", "), s.quote(column), " = excluded.", s.quote(column))
+		}
+	}
+}
+
+// Tables are quoted per part, so that a template like {schema}.{table} names a table in
+// a schema
+func sqlTable(options SqlReplayOptions, schema, table string) string {
+	s := &sqlStatementBuilder{dialect: options.Dialect}
+	var parts []string
+
+	for _, part := range strings.Split(options.TableTemplate, ".") {
+		parts = append(parts, s.quote(strings.NewReplacer("{schema}", schema, "{table}", table).Replace(part)))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+var isoDurationPattern = regexp.MustCompile(`^(-?)PT(\d+)H(\d+)M(\d+)(\.\d+)?S$`)
+
+// Converts values from their format in messages back to ones the target database takes,
+// as far as their column type is known
+func sqlValue(value interface{}, columnType *messages.MessageColumnType, binaryEncoding string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case uint64:
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(v, 10), nil
+		}
+
+		return int64(v), nil
+	case []string:
+		return strings.Join(v, ","), nil
+	case json.RawMessage:
+		return string(v), nil
+	}
+
+	s, ok := value.(string)
+
+	if columnType == nil || !ok {
+		if columnType != nil && columnType.Type == "JSON" {
+			data, err := json.Marshal(value)
+			return string(data), err
+		}
+
+		return value, nil
+	}
+
+	switch columnType.Type {
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+		if binaryEncoding == conversion.BINARY_ENCODING_HEX {
+			return hex.DecodeString(s)
+		}
+
+		return base64.StdEncoding.DecodeString(s)
+	case "DATETIME", "TIMESTAMP":
+		t, err := time.Parse(time.RFC3339Nano, s)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return t.Format("2006-01-02 15:04:05.999999"), nil
+	case "TIME":
+		match := isoDurationPattern.FindStringSubmatch(s)
+
+		if match == nil {
+			return nil, fmt.Errorf("Invalid duration %s", s)
+		}
+
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		seconds, _ := strconv.Atoi(match[4])
+
+		return fmt.Sprintf("%s%02d:%02d:%02d%s", match[1], hours, minutes, seconds, match[5]), nil
+	}
+
+	return s, nil
+}
+
+// Strings are quoted like the dialect's string literals, MySQL's with backslash escapes
+func sqlLiteral(value interface{}, dialect string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+
+		return "0"
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		if dialect == SQL_DIALECT_POSTGRES {
+			return "'\\x" + hex.EncodeToString(v) + "'::bytea"
+		}
+
+		return "X'" + hex.EncodeToString(v) + "'"
+	case string:
+		if dialect == SQL_DIALECT_MYSQL {
+			return "'" + mysqlStringEscaper.Replace(v) + "'"
+		}
+
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	}
+
+	return fmt.Sprint(value)
+}
+
+var mysqlStringEscaper = strings.NewReplacer("\\", "\\\\", "'", "\\'", "\x00", "\\0", "\n", "\\n", "\r", "\\r", "\x1a", "\\Z")
+
+func sortedColumns(row messages.MessageRow) []string {
+	var columns []string
+
+	for column := range row {
+		columns = append(columns, column)
+	}
+
+	sort.Strings(columns)
+
+	return columns
+}
+
+func separator(i int, s string) string {
+	if i == 0 {
+		return ""
+	}
+
+	return s
+}
@@ -0,0 +1,48 @@
+// +build integration
+
+package parser
+
+import (
+	"os"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestSqlCollectorMysql(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "replayed_buildings", time.Unix(1492065270, 0), 397, 9, "")
+	key := messages.MessageRow{"building_no": 1}
+	row := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1, "building_name": "ACME"}}
+
+	for _, conflicts := range []string{SQL_CONFLICTS_ERROR, SQL_CONFLICTS_REPLACE} {
+		t.Run(conflicts, func(t *testing.T) {
+			db, err := OpenSqlTarget(SQL_DIALECT_MYSQL, os.Getenv("TEST_DB_DSN"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer db.Exec("DROP TABLE replayed_buildings")
+
+			if _, err := db.Exec("CREATE TABLE replayed_buildings (building_no INT PRIMARY KEY, building_name VARCHAR(255))"); err != nil {
+				t.Fatal(err)
+			}
+
+			chain := NewConsumerChain()
+			chain.CollectAsSql(db, SqlReplayOptions{Dialect: SQL_DIALECT_MYSQL, TableTemplate: "{table}", Conflicts: conflicts})
+			defer chain.Close()
+
+			// applied again, the update finds its row already updated
+			for _, message := range []messages.Message{
+				messages.NewInsertMessage(header, key, row),
+				messages.NewTransactionCommitMessage(header, 1, time.Unix(1492065270, 0)),
+				messages.NewUpdateMessage(header, key, row, row),
+				messages.NewTransactionCommitMessage(header, 1, time.Unix(1492065270, 0)),
+			} {
+				if err := chain.consumeMessage(message); err != nil {
+					t.Fatalf("Expected no error when applying %s message, got %s", message.GetType(), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+// +build unit
+
+package parser
+
+import (
+	"database/sql"
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"reflect"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestSqlStatementForMessage(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "buildings", time.Unix(1492065270, 0), 397, 9, "")
+	key := messages.MessageRow{"building_no": 1}
+	row := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1, "building_name": "ACME"}}
+	updated := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1, "building_name": "ACME Headquaters"}}
+	noKey := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1, "building_name": nil}}
+
+	testCases := []struct {
+		name          string
+		options       SqlReplayOptions
+		message       messages.Message
+		expectedQuery string
+		expectedArgs  []interface{}
+	}{
+		{
+			"MySQL insert",
+			SqlReplayOptions{Dialect: SQL_DIALECT_MYSQL, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_ERROR},
+			messages.NewInsertMessage(header, key, row),
+			"INSERT INTO `buildings` (`building_name`, `building_no`) VALUES (?, ?)",
+			[]interface{}{"ACME", 1},
+		},
+		{
+			"MySQL insert ignoring conflicts",
+			SqlReplayOptions{Dialect: SQL_DIALECT_MYSQL, TableTemplate: "{schema}.{table}", Conflicts: SQL_CONFLICTS_IGNORE},
+			messages.NewInsertMessage(header, key, row),
+			"INSERT IGNORE INTO `test_db`.`buildings` (`building_name`, `building_no`) VALUES (?, ?)",
+			[]interface{}{"ACME", 1},
+		},
+		{
+			"MySQL insert replacing conflicts",
+			SqlReplayOptions{Dialect: SQL_DIALECT_MYSQL, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_REPLACE},
+			messages.NewInsertMessage(header, key, row),
+			"INSERT INTO `buildings` (`building_name`, `building_no`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `building_name` = VALUES(`building_name`), `building_no` = VALUES(`building_no`)",
+			[]interface{}{"ACME", 1},
+		},
+		{
+			"PostgreSQL insert ignoring conflicts",
+			SqlReplayOptions{Dialect: SQL_DIALECT_POSTGRES, TableTemplate: "binlog_{table}", Conflicts: SQL_CONFLICTS_IGNORE},
+			messages.NewInsertMessage(header, key, row),
+			`INSERT INTO "binlog_buildings" ("building_name", "building_no") VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			[]interface{}{"ACME", 1},
+		},
+		{
+			"PostgreSQL insert replacing conflicts",
+			SqlReplayOptions{Dialect: SQL_DIALECT_POSTGRES, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_REPLACE},
+			messages.NewInsertMessage(header, key, row),
+			`INSERT INTO "buildings" ("building_name", "building_no") VALUES ($1, $2) ON CONFLICT ("building_no") DO UPDATE SET "building_name" = excluded."building_name", "building_no" = excluded."building_no"`,
+			[]interface{}{"ACME", 1},
+		},
+		{
+			"PostgreSQL update",
+			SqlReplayOptions{Dialect: SQL_DIALECT_POSTGRES, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_ERROR},
+			messages.NewUpdateMessage(header, key, row, updated),
+			`UPDATE "buildings" SET "building_name" = $1, "building_no" = $2 WHERE "building_no" = $3`,
+			[]interface{}{"ACME Headquaters", 1, 1},
+		},
+		{
+			"SQLite insert ignoring conflicts",
+			SqlReplayOptions{Dialect: SQL_DIALECT_SQLITE, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_IGNORE},
+			messages.NewInsertMessage(header, key, row),
+			`INSERT OR IGNORE INTO "buildings" ("building_name", "building_no") VALUES (?, ?)`,
+			[]interface{}{"ACME", 1},
+		},
+		{
+			"SQLite delete without key",
+			SqlReplayOptions{Dialect: SQL_DIALECT_SQLITE, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_ERROR},
+			messages.NewDeleteMessage(header, nil, noKey),
+			`DELETE FROM "buildings" WHERE rowid = (SELECT rowid FROM "buildings" WHERE "building_name" IS NULL AND "building_no" = ? LIMIT 1)`,
+			[]interface{}{1},
+		},
+		{
+			"MySQL update without key",
+			SqlReplayOptions{Dialect: SQL_DIALECT_MYSQL, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_ERROR},
+			messages.NewUpdateMessage(header, nil, noKey, row),
+			"UPDATE `buildings` SET `building_name` = ?, `building_no` = ? WHERE `building_name` IS NULL AND `building_no` = ? LIMIT 1",
+			[]interface{}{"ACME", 1, 1},
+		},
+		{
+			"PostgreSQL update without key",
+			SqlReplayOptions{Dialect: SQL_DIALECT_POSTGRES, TableTemplate: "{table}", Conflicts: SQL_CONFLICTS_ERROR},
+			messages.NewUpdateMessage(header, nil, noKey, row),
+			`UPDATE "buildings" SET "building_name" = $1, "building_no" = $2 WHERE ctid = (SELECT ctid FROM "buildings" WHERE "building_name" IS NULL AND "building_no" = $3 LIMIT 1)`,
+			[]interface{}{"ACME", 1, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			statement, ok, err := sqlStatementForMessage(tc.message, tc.options)
+
+			if err != nil || !ok {
+				t.Fatalf("Expected statement, got error %v", err)
+			}
+
+			if statement.query != tc.expectedQuery {
+				t.Fatalf("Expected query %s, got %s", tc.expectedQuery, statement.query)
+			}
+
+			if !reflect.DeepEqual(statement.args, tc.expectedArgs) {
+				t.Fatalf("Expected args %v, got %v", tc.expectedArgs, statement.args)
+			}
+		})
+	}
+
+	t.Run("Query", func(t *testing.T) {
+		if _, ok, _ := sqlStatementForMessage(messages.NewQueryMessage(header, "DROP TABLE buildings"), SqlReplayOptions{}); ok {
+			t.Fatal("Expected no statement for query message")
+		}
+	})
+}
+
+func TestSqlTargetDsn(t *testing.T) {
+	testCases := []struct {
+		dialect  string
+		dsn      string
+		expected string
+	}{
+		{SQL_DIALECT_MYSQL, "report:secret@tcp(reporting:3306)/test_db", "report:secret@tcp(reporting:3306)/test_db?clientFoundRows=true&time_zone=%27%2B00%3A00%27"},
+		{SQL_DIALECT_POSTGRES, "host=reporting dbname=test_db", "host=reporting dbname=test_db timezone=UTC"},
+		{SQL_DIALECT_POSTGRES, "postgres://report@reporting/test_db", "dbname='test_db' host='reporting' user='report' timezone=UTC"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.dsn, func(t *testing.T) {
+			dsn, err := sqlTargetDsn(tc.dialect, tc.dsn)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dsn != tc.expected {
+				t.Fatalf("Expected %s, got %s", tc.expected, dsn)
+			}
+		})
+	}
+}
+
+func TestSqlValue(t *testing.T) {
+	testCases := []struct {
+		name       string
+		value      interface{}
+		columnType *messages.MessageColumnType
+		encoding   string
+		expected   interface{}
+	}{
+		{"NULL", nil, &messages.MessageColumnType{Type: "INT"}, "", nil},
+		{"Integer", int32(42), &messages.MessageColumnType{Type: "INT"}, "", int32(42)},
+		{"Large unsigned integer", uint64(18446744073709551615), &messages.MessageColumnType{Type: "BIGINT", Unsigned: true}, "", "18446744073709551615"},
+		{"Base64", "AAH/", &messages.MessageColumnType{Type: "VARBINARY"}, "base64", []byte{0, 1, 255}},
+		{"Hex", "0001ff", &messages.MessageColumnType{Type: "BLOB"}, "hex", []byte{0, 1, 255}},
+		{"Datetime", "2017-04-13T06:34:30.500+08:00", &messages.MessageColumnType{Type: "DATETIME", Precision: 3}, "", "2017-04-13 06:34:30.5"},
+		{"Timestamp", "2017-04-13T06:34:30Z", &messages.MessageColumnType{Type: "TIMESTAMP"}, "", "2017-04-13 06:34:30"},
+		{"Time", "PT12H30M5S", &messages.MessageColumnType{Type: "TIME"}, "", "12:30:05"},
+		{"Negative time", "-PT1H0M0.500S", &messages.MessageColumnType{Type: "TIME", Precision: 3}, "", "-01:00:00.500"},
+		{"SET", []string{"gift", "express"}, &messages.MessageColumnType{Type: "SET"}, "", "gift,express"},
+		{"JSON", json.RawMessage(`{"color":"red"}`), &messages.MessageColumnType{Type: "JSON"}, "", `{"color":"red"}`},
+		{"Unknown type", "PT12H30M5S", nil, "", "PT12H30M5S"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := sqlValue(tc.value, tc.columnType, tc.encoding)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(value, tc.expected) {
+				t.Fatalf("Expected %#v, got %#v", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestSqlLiteral(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    interface{}
+		dialect  string
+		expected string
+	}{
+		{"NULL", nil, SQL_DIALECT_MYSQL, "NULL"},
+		{"Integer", int64(-42), SQL_DIALECT_MYSQL, "-42"},
+		{"Float", 1.5, SQL_DIALECT_MYSQL, "1.5"},
+		{"MySQL string", "It's a\\ \n", SQL_DIALECT_MYSQL, `'It\'s a\\ \n'`},
+		{"PostgreSQL string", "It's a\\ \n", SQL_DIALECT_POSTGRES, "'It''s a\\ \n'"},
+		{"MySQL bytes", []byte{0, 1, 255}, SQL_DIALECT_MYSQL, "X'0001ff'"},
+		{"PostgreSQL bytes", []byte{0, 1, 255}, SQL_DIALECT_POSTGRES, `'\x0001ff'::bytea`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if literal := sqlLiteral(tc.value, tc.dialect); literal != tc.expected {
+				t.Fatalf("Expected %s, got %s", tc.expected, literal)
+			}
+		})
+	}
+}
+
+type building struct {
+	no   int
+	name string
+}
+
+func TestSqlCollector(t *testing.T) {
+	header := func(xId uint64) messages.MessageHeader {
+		return messages.NewMessageHeader("test_db", "buildings", time.Unix(1492065270, 0), 397, xId, "")
+	}
+
+	row := func(no int, name string) messages.MessageRowData {
+		return messages.MessageRowData{Row: messages.MessageRow{"building_no": no, "building_name": name}}
+	}
+
+	key := func(no int) messages.MessageRow {
+		return messages.MessageRow{"building_no": no}
+	}
+
+	transaction := func(xId uint64, rowMessages ...messages.Message) []messages.Message {
+		begin := messages.NewTransactionBeginMessage(header(xId), len(rowMessages), time.Unix(1492065270, 0))
+		commit := messages.NewTransactionCommitMessage(header(xId), len(rowMessages), time.Unix(1492065270, 0))
+
+		return append(append([]messages.Message{begin}, rowMessages...), commit)
+	}
+
+	open := func(t *testing.T, conflicts string) (ConsumerChain, *sql.DB) {
+		db, err := sql.Open("sqlite3", ":memory:")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every connection has an in-memory database of its own
+		db.SetMaxOpenConns(1)
+
+		if _, err := db.Exec("CREATE TABLE buildings (building_no INTEGER PRIMARY KEY, building_name TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+
+		chain := NewConsumerChain()
+		chain.CollectAsSql(db, SqlReplayOptions{Dialect: SQL_DIALECT_SQLITE, TableTemplate: "{table}", Conflicts: conflicts})
+
+		return chain, db
+	}
+
+	consume := func(chain ConsumerChain, messages []messages.Message) error {
+		for _, message := range messages {
+			if err := chain.consumeMessage(message); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	assertBuildings := func(t *testing.T, db *sql.DB, expected ...building) {
+		rows, err := db.Query("SELECT building_no, building_name FROM buildings ORDER BY building_no")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer rows.Close()
+
+		var buildings []building
+
+		for rows.Next() {
+			var b building
+
+			if err := rows.Scan(&b.no, &b.name); err != nil {
+				t.Fatal(err)
+			}
+
+			buildings = append(buildings, b)
+		}
+
+		if !reflect.DeepEqual(buildings, expected) {
+			t.Fatalf("Expected buildings %v, got %v", expected, buildings)
+		}
+	}
+
+	t.Run("Transactions", func(t *testing.T) {
+		chain, db := open(t, SQL_CONFLICTS_ERROR)
+		defer chain.Close()
+
+		err := consume(chain, transaction(1,
+			messages.NewInsertMessage(header(1), key(1), row(1, "ACME")),
+			messages.NewInsertMessage(header(1), key(2), row(2, "Annex")),
+		))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = consume(chain, transaction(2,
+			messages.NewUpdateMessage(header(2), key(1), row(1, "ACME"), row(1, "ACME Headquaters")),
+			messages.NewDeleteMessage(header(2), key(2), row(2, "Annex")),
+		))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertBuildings(t, db, building{1, "ACME Headquaters"})
+
+		// the update applied before the conflicting insert is rolled back with it
+		err = consume(chain, transaction(3,
+			messages.NewUpdateMessage(header(3), key(1), row(1, "ACME Headquaters"), row(1, "ACME")),
+			messages.NewInsertMessage(header(3), key(1), row(1, "ACME")),
+		))
+
+		if err == nil {
+			t.Fatal("Expected error for duplicate key")
+		}
+
+		assertBuildings(t, db, building{1, "ACME Headquaters"})
+
+		err = consume(chain, transaction(4, messages.NewDeleteMessage(header(4), key(2), row(2, "Annex"))))
+
+		if err == nil {
+			t.Fatal("Expected error for missing row")
+		}
+	})
+
+	t.Run("Ignoring conflicts", func(t *testing.T) {
+		chain, db := open(t, SQL_CONFLICTS_IGNORE)
+		defer chain.Close()
+
+		err := consume(chain, transaction(1,
+			messages.NewInsertMessage(header(1), key(1), row(1, "ACME")),
+			messages.NewInsertMessage(header(1), key(1), row(1, "Annex")),
+			messages.NewUpdateMessage(header(1), key(2), row(2, "Annex"), row(2, "Annex 2")),
+			messages.NewDeleteMessage(header(1), key(3), row(3, "Depot")),
+		))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertBuildings(t, db, building{1, "ACME"})
+	})
+
+	t.Run("Replacing conflicts", func(t *testing.T) {
+		chain, db := open(t, SQL_CONFLICTS_REPLACE)
+		defer chain.Close()
+
+		err := consume(chain, transaction(1,
+			messages.NewInsertMessage(header(1), key(1), row(1, "ACME")),
+			messages.NewInsertMessage(header(1), key(1), row(1, "ACME Headquaters")),
+			messages.NewUpdateMessage(header(1), key(2), row(2, "Annex"), row(2, "Annex 2")),
+			messages.NewDeleteMessage(header(1), key(3), row(3, "Depot")),
+		))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertBuildings(t, db, building{1, "ACME Headquaters"}, building{2, "Annex 2"})
+	})
+
+	t.Run("Identical rows", func(t *testing.T) {
+		chain, db := open(t, SQL_CONFLICTS_REPLACE)
+		defer chain.Close()
+
+		if _, err := db.Exec("DROP TABLE buildings; CREATE TABLE buildings (building_no INTEGER, building_name TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+
+		err := consume(chain, transaction(1,
+			messages.NewInsertMessage(header(1), nil, row(1, "ACME")),
+			messages.NewInsertMessage(header(1), nil, row(1, "ACME")),
+			messages.NewDeleteMessage(header(1), nil, row(1, "ACME")),
+		))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertBuildings(t, db, building{1, "ACME"})
+
+		// the key isn't unique in the target table
+		err = consume(chain, transaction(2,
+			messages.NewInsertMessage(header(2), nil, row(1, "ACME")),
+			messages.NewUpdateMessage(header(2), key(1), row(1, "ACME"), row(1, "ACME Headquaters")),
+		))
+
+		if err == nil {
+			t.Fatal("Expected error for more than one row updated")
+		}
+
+		assertBuildings(t, db, building{1, "ACME"})
+	})
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"strings"
+	"zalora/binlog-parser/parser/messages"
+)
+
+// Writes each message as a MySQL statement, preceded by a comment with its position.
+// Transaction boundaries are written as BEGIN and COMMIT.
+// Queries are written as they were logged, after a USE statement for their schema when
+// it differs from the one of the query before. The first statement sets the session time
+// zone to UTC, the one TIMESTAMP values are written in.
+func sqlTextCollector(stream io.Writer, binaryEncoding string) collector {
+	options := SqlReplayOptions{
+		Dialect:        SQL_DIALECT_MYSQL,
+		TableTemplate:  "{schema}.{table}",
+		Conflicts:      SQL_CONFLICTS_ERROR,
+		BinaryEncoding: binaryEncoding,
+	}
+
+	var schema string
+	started := false
+
+	return func(message messages.Message) error {
+		var statement string
+
+		switch m := message.(type) {
+		case messages.QueryMessage:
+			statement = strings.TrimRight(strings.TrimSpace(string(m.Query)), ";")
+
+			// the semicolon mustn't end up in a comment at the end of the query
+			if lastLine := statement[strings.LastIndex(statement, "\n")+1:]; strings.Contains(lastLine, "--") || strings.Contains(lastLine, "#") {
+				statement += "\n"
+			}
+
+			if m.Header.Schema != "" && m.Header.Schema != schema {
+				schema = m.Header.Schema
+				statement = fmt.Sprintf("USE `%s`;\n%s", strings.Replace(schema, "`", "``", -1), statement)
+			}
+		case messages.TransactionMessage:
+			if m.Type == messages.MESSAGE_TYPE_TRANSACTION_BEGIN {
+				statement = "BEGIN"
+			} else {
+				statement = "COMMIT"
+			}
+		default:
+			var ok bool
+			var err error
+
+			if statement, ok, err = sqlTextForMessage(message, options); err != nil {
+				glog.Errorf("Failed to convert %s message to SQL: %s", message.GetType(), err)
+				return err
+			} else if !ok {
+				return nil
+			}
+		}
+
+		if !started {
+			if _, err := io.WriteString(stream, sqlUtcTimeZone); err != nil {
+				glog.Errorf("Failed to write SQL to file %s", err)
+				return err
+			}
+
+			started = true
+		}
+
+		_, err := fmt.Fprintf(stream, "%s\n%s;\n", sqlComment(message.GetHeader()), statement)
+
+		if err != nil {
+			glog.Errorf("Failed to write SQL to file %s", err)
+			return err
+		}
+
+		return nil
+	}
+}
+
+func sqlComment(header messages.MessageHeader) string {
+	comment := fmt.Sprintf("-- position %d, xid %d", header.BinlogPosition, header.XId)
+
+	if header.BinlogFile != "" {
+		comment = fmt.Sprintf("-- %s position %d, xid %d", header.BinlogFile, header.BinlogPosition, header.XId)
+	}
+
+	if header.Gtid != "" {
+		comment += ", gtid " + header.Gtid
+	}
+
+	return comment
+}
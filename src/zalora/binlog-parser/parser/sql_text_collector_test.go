@@ -0,0 +1,87 @@
+// +build unit
+
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"zalora/binlog-parser/parser/messages"
+)
+
+func TestSqlTextCollector(t *testing.T) {
+	header := messages.NewMessageHeader("test_db", "buildings", time.Unix(1492065270, 0), 397, 9, "")
+	header.BinlogFile = "mysql-bin.000001"
+
+	gtidHeader := messages.NewMessageHeader("test_db", "", time.Unix(1492065270, 0), 120, 0, "0-3704-2815")
+	otherSchemaHeader := messages.NewMessageHeader("other_db", "", time.Unix(1492065270, 0), 150, 0, "")
+
+	columns := map[string]messages.MessageColumnType{
+		"building_no":   {Type: "INT"},
+		"building_name": {Type: "VARCHAR"},
+		"photo":         {Type: "BLOB"},
+		"opened":        {Type: "DATETIME"},
+		"attributes":    {Type: "JSON"},
+	}
+
+	row := messages.MessageRowData{
+		Row: messages.MessageRow{
+			"building_no":   1,
+			"building_name": "ACME's \"Headquaters\"\n",
+			"photo":         "AAH/",
+			"opened":        "2017-04-13T06:34:30Z",
+			"attributes":    json.RawMessage(`{"floors":3}`),
+		},
+		Columns: columns,
+	}
+
+	updated := messages.MessageRowData{Row: messages.MessageRow{"building_no": 1, "building_name": nil}, Columns: columns}
+
+	var buffer bytes.Buffer
+
+	collect := sqlTextCollector(&buffer, "base64")
+
+	for _, message := range []messages.Message{
+		messages.NewQueryMessage(gtidHeader, "CREATE TABLE buildings (building_no INT PRIMARY KEY) -- buildings"),
+		messages.NewQueryMessage(gtidHeader, "ALTER TABLE buildings ADD COLUMN building_name VARCHAR(255);"),
+		messages.NewQueryMessage(otherSchemaHeader, "DROP TABLE rooms"),
+		messages.NewTransactionBeginMessage(header, 3, time.Unix(1492065270, 0)),
+		messages.NewInsertMessage(header, messages.MessageRow{"building_no": 1}, row),
+		messages.NewUpdateMessage(header, messages.MessageRow{"building_no": 1}, row, updated),
+		messages.NewDeleteMessage(header, nil, updated),
+		messages.NewTransactionCommitMessage(header, 3, time.Unix(1492065270, 0)),
+	} {
+		if err := collect(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := strings.Join([]string{
+		"SET time_zone = '+00:00';",
+		"-- position 120, xid 0, gtid 0-3704-2815",
+		"USE `test_db`;",
+		"CREATE TABLE buildings (building_no INT PRIMARY KEY) -- buildings",
+		";",
+		"-- position 120, xid 0, gtid 0-3704-2815",
+		"ALTER TABLE buildings ADD COLUMN building_name VARCHAR(255);",
+		"-- position 150, xid 0",
+		"USE `other_db`;",
+		"DROP TABLE rooms;",
+		"-- mysql-bin.000001 position 397, xid 9",
+		"BEGIN;",
+		"-- mysql-bin.000001 position 397, xid 9",
+		"INSERT INTO `test_db`.`buildings` (`attributes`, `building_name`, `building_no`, `opened`, `photo`) VALUES ('{\"floors\":3}', 'ACME\\'s \"Headquaters\"\\n', 1, '2017-04-13 06:34:30', X'0001ff');",
+		"-- mysql-bin.000001 position 397, xid 9",
+		"UPDATE `test_db`.`buildings` SET `building_name` = NULL, `building_no` = 1 WHERE `building_no` = 1;",
+		"-- mysql-bin.000001 position 397, xid 9",
+		"DELETE FROM `test_db`.`buildings` WHERE `building_name` IS NULL AND `building_no` = 1 LIMIT 1;",
+		"-- mysql-bin.000001 position 397, xid 9",
+		"COMMIT;",
+	}, "\n") + "\n"
+
+	if buffer.String() != expected {
+		t.Fatalf("Expected %s, got %s", expected, buffer.String())
+	}
+}
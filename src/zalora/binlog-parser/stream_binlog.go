@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"zalora/binlog-parser/database"
+	"zalora/binlog-parser/parser"
+)
+
+func streamBinlog(source schemaSource, consumerChain parser.ConsumerChain, streamOptions parser.StreamOptions, options parser.ParseOptions) error {
+	if err := connectionFromDsn(source.dbDsn, &streamOptions); err != nil {
+		return err
+	}
+
+	glog.V(2).Infof("Streaming binlog from %s:%d as server id %d", streamOptions.Host, streamOptions.Port, streamOptions.ServerId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case <-signals:
+			glog.V(1).Info("Got signal, stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return withTableMap(source, func(tableMap database.TableMap) error {
+		return parser.StreamBinlog(ctx, streamOptions, tableMap, consumerChain, options)
+	})
+}
+
+// The replication connection uses the same server and credentials as DB_DSN
+func connectionFromDsn(dbDsn string, streamOptions *parser.StreamOptions) error {
+	config, err := mysql.ParseDSN(dbDsn)
+
+	if err != nil {
+		return err
+	}
+
+	if config.Net != "tcp" {
+		return fmt.Errorf("Streaming requires a tcp connection in DB_DSN, got %s", config.Net)
+	}
+
+	host, port, err := net.SplitHostPort(config.Addr)
+
+	if err != nil {
+		return err
+	}
+
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+
+	if err != nil {
+		return err
+	}
+
+	streamOptions.Host = host
+	streamOptions.Port = uint16(portNumber)
+	streamOptions.User = config.User
+	streamOptions.Password = config.Passwd
+
+	return nil
+}